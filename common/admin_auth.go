@@ -0,0 +1,277 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AdminSessionCookie 管理员会话Cookie名称
+const AdminSessionCookie = "xrcuo_admin_session"
+
+// AdminContextKey 上下文中保存当前管理员身份的键
+const AdminContextKey = "admin_user"
+
+// 未配置会话密钥时使用的进程级随机密钥
+var (
+	fallbackSessionSecret     []byte
+	fallbackSessionSecretOnce sync.Once
+)
+
+// 用户不存在时用于比较的占位密码哈希
+var (
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+// InitAdmin 根据配置初始化管理员账号
+// 账号不存在时自动创建；配置了密码且与数据库不一致时，以配置为准更新密码
+func InitAdmin() error {
+	username := config.GetAdminUsername()
+	password := config.GetAdminPassword()
+
+	user, err := db.GetAdminUserByUsername(username)
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		// 未配置密码时随机生成初始密码
+		if password == "" {
+			password, err = randomHex(12)
+			if err != nil {
+				return fmt.Errorf("生成管理员初始密码失败: %v", err)
+			}
+			logrus.Warnf("未配置管理员密码，已生成初始密码：%s（用户名：%s），请尽快写入配置文件", password, username)
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("哈希管理员密码失败: %v", err)
+		}
+		if _, err := db.CreateAdminUser(username, string(hash)); err != nil {
+			return err
+		}
+		logrus.Infof("管理员账号 %s 已创建", username)
+		return nil
+	}
+
+	// 配置中的密码与数据库不一致时同步更新
+	if password != "" && bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("哈希管理员密码失败: %v", err)
+		}
+		if err := db.UpdateAdminPassword(user.ID, string(hash)); err != nil {
+			return err
+		}
+		logrus.Infof("管理员账号 %s 的密码已按配置更新", username)
+	}
+
+	return nil
+}
+
+// AuthenticateAdmin 校验管理员用户名和密码，校验通过时返回管理员账号，否则返回nil
+func AuthenticateAdmin(username, password string) *models.AdminUser {
+	user, err := db.GetAdminUserByUsername(username)
+	if err != nil {
+		logrus.Errorf("查询管理员账号失败: %v", err)
+		return nil
+	}
+	if user == nil {
+		// 用户不存在时同样执行一次哈希比较，避免通过响应时间枚举用户名
+		dummyPasswordHashOnce.Do(func() {
+			dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil
+	}
+	return user
+}
+
+// sessionSecret 获取会话签名密钥
+func sessionSecret() []byte {
+	if secret := config.GetAdminSessionSecret(); secret != "" {
+		return []byte(secret)
+	}
+
+	fallbackSessionSecretOnce.Do(func() {
+		fallbackSessionSecret = make([]byte, 32)
+		if _, err := rand.Read(fallbackSessionSecret); err != nil {
+			logrus.Fatalf("生成会话签名密钥失败: %v", err)
+		}
+		logrus.Warn("未配置 admin.session_secret，已使用随机密钥，服务重启后需要重新登录")
+	})
+	return fallbackSessionSecret
+}
+
+// signSession 生成会话签名
+func signSession(payload string) string {
+	mac := hmac.New(sha256.New, sessionSecret())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewAdminSession 生成管理员会话令牌
+// 格式：base64(用户名|会话版本|过期时间戳).签名
+// 修改密码或退出登录后管理员的会话版本递增，之前签发的令牌随之失效
+func NewAdminSession(user *models.AdminUser, ttl time.Duration) string {
+	payload := user.Username + "|" + strconv.FormatInt(user.SessionGen, 10) + "|" + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + signSession(encoded)
+}
+
+// ParseAdminSession 校验会话令牌并返回管理员用户名
+// 除签名和有效期外，还需要令牌中的会话版本与数据库中一致
+func ParseAdminSession(token string) (string, bool) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return "", false
+	}
+
+	// 校验签名
+	if !hmac.Equal([]byte(signature), []byte(signSession(encoded))) {
+		return "", false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+
+	// 用户名可能包含分隔符，从右侧取出过期时间和会话版本
+	rest, expiresStr, found := cutLast(string(payload), "|")
+	if !found {
+		return "", false
+	}
+	username, generationStr, found := cutLast(rest, "|")
+	if !found || username == "" {
+		return "", false
+	}
+
+	// 校验是否过期
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", false
+	}
+
+	// 校验会话版本，修改密码或退出登录后旧会话失效
+	generation, err := strconv.ParseInt(generationStr, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	user, err := db.GetAdminUserByUsername(username)
+	if err != nil {
+		logrus.Errorf("查询管理员账号失败: %v", err)
+		return "", false
+	}
+	if user == nil || user.SessionGen != generation {
+		return "", false
+	}
+
+	return username, true
+}
+
+// cutLast 在最后一个分隔符处切分字符串
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// SetAdminSessionCookie 写入管理员会话Cookie
+func SetAdminSessionCookie(c *gin.Context, user *models.AdminUser) {
+	ttl := config.GetAdminSessionTTL()
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(AdminSessionCookie, NewAdminSession(user, ttl), int(ttl.Seconds()), "/", "", c.Request.TLS != nil, true)
+}
+
+// ClearAdminSessionCookie 清除管理员会话Cookie
+func ClearAdminSessionCookie(c *gin.Context) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(AdminSessionCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+}
+
+// adminFromToken 校验管理API令牌
+// 支持 X-Admin-Token 请求头和 Authorization: Bearer <token>
+func adminFromToken(c *gin.Context) bool {
+	expected := config.GetAdminToken()
+	if expected == "" {
+		return false
+	}
+
+	token := c.GetHeader("X-Admin-Token")
+	if token == "" {
+		if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		}
+	}
+	if token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// AdminAuthMiddleware 管理员认证中间件
+// 浏览器访问页面时校验会话Cookie，未登录则跳转到登录页；
+// JSON接口同时接受会话Cookie和管理API令牌，未认证返回401
+func AdminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 优先校验会话Cookie
+		if cookie, err := c.Cookie(AdminSessionCookie); err == nil {
+			if username, ok := ParseAdminSession(cookie); ok {
+				c.Set(AdminContextKey, username)
+				c.Next()
+				return
+			}
+		}
+
+		// 其次校验管理API令牌
+		if adminFromToken(c) {
+			c.Set(AdminContextKey, "token")
+			c.Next()
+			return
+		}
+
+		// 浏览器页面请求跳转到登录页
+		if c.Request.Method == http.MethodGet && strings.Contains(c.GetHeader("Accept"), "text/html") {
+			c.Redirect(http.StatusFound, "/login?redirect="+url.QueryEscape(c.Request.URL.RequestURI()))
+			c.Abort()
+			return
+		}
+
+		ErrorResponse(c, http.StatusUnauthorized, CodeUnauthorized, "需要管理员身份认证")
+		c.Abort()
+	}
+}
+
+// randomHex 生成指定字节数的随机十六进制字符串
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,216 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// setupAdmin 初始化临时数据库和管理员账号，返回管理员账号
+func setupAdmin(t *testing.T, password string) *models.AdminUser {
+	t.Helper()
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.Admin.Username = "admin"
+		cfg.Admin.Password = password
+		cfg.Admin.SessionSecret = "test-session-secret"
+	})
+	if err := InitAdmin(); err != nil {
+		t.Fatalf("初始化管理员失败: %v", err)
+	}
+	user, err := db.GetAdminUserByUsername("admin")
+	if err != nil || user == nil {
+		t.Fatalf("查询管理员失败: %v", err)
+	}
+	return user
+}
+
+func TestAuthenticateAdmin(t *testing.T) {
+	setupAdmin(t, "correct-password")
+
+	cases := []struct {
+		username, password string
+		ok                 bool
+	}{
+		{"admin", "correct-password", true},
+		{"admin", "wrong-password", false},
+		{"admin", "", false},
+		{"nobody", "correct-password", false},
+	}
+	for _, tc := range cases {
+		if user := AuthenticateAdmin(tc.username, tc.password); (user != nil) != tc.ok {
+			t.Errorf("%s/%s: 期望校验结果 %v，得到 %v", tc.username, tc.password, tc.ok, user != nil)
+		}
+	}
+}
+
+func TestAdminSessionSigning(t *testing.T) {
+	user := setupAdmin(t, "correct-password")
+	valid := NewAdminSession(user, time.Hour)
+	encoded, signature, _ := strings.Cut(valid, ".")
+
+	other := *user
+	other.Username = "other"
+	forgedPayload, _, _ := strings.Cut(NewAdminSession(&other, time.Hour), ".")
+	// 修改签名的第一个字符，保证与原签名不同
+	tampered := "A"
+	if signature[0] == 'A' {
+		tampered = "B"
+	}
+
+	cases := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"有效会话", valid, true},
+		{"已过期", NewAdminSession(user, -time.Minute), false},
+		{"签名被篡改", encoded + "." + tampered + signature[1:], false},
+		{"替换载荷", forgedPayload + "." + signature, false},
+		{"缺少签名", encoded, false},
+		{"空令牌", "", false},
+	}
+	for _, tc := range cases {
+		username, ok := ParseAdminSession(tc.token)
+		if ok != tc.ok {
+			t.Errorf("%s: 期望校验结果 %v，得到 %v", tc.name, tc.ok, ok)
+		}
+		if ok && username != "admin" {
+			t.Errorf("%s: 期望用户名 admin，得到 %s", tc.name, username)
+		}
+	}
+
+	// 更换签名密钥后之前签发的会话失效
+	cfg := *config.GetInstance().GetConfig()
+	cfg.Admin.SessionSecret = "another-secret"
+	config.GetInstance().SetConfig(&cfg)
+	if _, ok := ParseAdminSession(valid); ok {
+		t.Fatal("更换签名密钥后会话仍然有效")
+	}
+}
+
+func TestAdminSessionRevokedByPasswordChange(t *testing.T) {
+	user := setupAdmin(t, "old-password")
+	token := NewAdminSession(user, time.Hour)
+	if _, ok := ParseAdminSession(token); !ok {
+		t.Fatal("修改密码前会话应当有效")
+	}
+
+	// 配置中的密码变化后 InitAdmin 更新密码并递增会话版本
+	cfg := *config.GetInstance().GetConfig()
+	cfg.Admin.Password = "new-password"
+	config.GetInstance().SetConfig(&cfg)
+	if err := InitAdmin(); err != nil {
+		t.Fatalf("更新管理员密码失败: %v", err)
+	}
+	if _, ok := ParseAdminSession(token); ok {
+		t.Fatal("修改密码后旧会话仍然有效")
+	}
+
+	user = AuthenticateAdmin("admin", "new-password")
+	if user == nil {
+		t.Fatal("新密码校验失败")
+	}
+	if _, ok := ParseAdminSession(NewAdminSession(user, time.Hour)); !ok {
+		t.Fatal("修改密码后新签发的会话应当有效")
+	}
+}
+
+func TestLogoutRevokesSessions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	user := setupAdmin(t, "correct-password")
+	token := NewAdminSession(user, time.Hour)
+	otherDevice := NewAdminSession(user, time.Hour)
+
+	r := gin.New()
+	r.POST("/logout", LogoutHandler)
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: AdminSessionCookie, Value: token})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/login" {
+		t.Fatalf("退出登录期望跳转到 /login，得到 %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if !strings.Contains(w.Header().Get("Set-Cookie"), AdminSessionCookie+"=;") {
+		t.Fatalf("退出登录期望清除会话Cookie，得到 %s", w.Header().Get("Set-Cookie"))
+	}
+	// 退出登录后，复制的Cookie和其他设备上的会话都失效
+	for _, token := range []string{token, otherDevice} {
+		if _, ok := ParseAdminSession(token); ok {
+			t.Fatal("退出登录后会话仍然有效")
+		}
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Admin.LoginMaxFailures = 3
+	cfg.Admin.LoginFailureWindow = time.Minute
+	cfg.Admin.LoginLockout = 10 * time.Minute
+	config.GetInstance().SetConfig(cfg)
+	throttle := newLoginThrottle()
+	now := time.Now()
+
+	// 同一用户名从不同IP失败，达到上限后锁定用户名
+	for i, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		if _, locked := throttle.lockedUntil(loginThrottleKeys("admin", ip), now); locked {
+			t.Fatalf("第 %d 次失败前不应锁定", i+1)
+		}
+		throttle.recordFailure(loginThrottleKeys("admin", ip), now)
+	}
+	until, locked := throttle.lockedUntil(loginThrottleKeys("admin", "192.0.2.9"), now)
+	if !locked || !until.Equal(now.Add(10*time.Minute)) {
+		t.Fatalf("用户名失败次数达到上限后期望锁定至 %v，得到 %v %v", now.Add(10*time.Minute), until, locked)
+	}
+	if _, locked := throttle.lockedUntil(loginThrottleKeys("other", "192.0.2.1"), now); locked {
+		t.Fatal("其他用户名和失败次数未达上限的IP不应被锁定")
+	}
+
+	// 同一IP尝试不同用户名，达到上限后锁定IP
+	for _, username := range []string{"a", "b", "c"} {
+		throttle.recordFailure(loginThrottleKeys(username, "198.51.100.1"), now)
+	}
+	if _, locked := throttle.lockedUntil(loginThrottleKeys("d", "198.51.100.1"), now); !locked {
+		t.Fatal("IP失败次数达到上限后期望锁定")
+	}
+
+	// 锁定到期后可以重新登录
+	if _, locked := throttle.lockedUntil(loginThrottleKeys("admin", "192.0.2.9"), now.Add(10*time.Minute)); locked {
+		t.Fatal("锁定到期后不应继续锁定")
+	}
+
+	// 超过时间窗口的失败不再累计，登录成功后清除失败计数
+	keys := loginThrottleKeys("windowed", "203.0.113.1")
+	throttle.recordFailure(keys, now)
+	throttle.recordFailure(keys, now)
+	throttle.recordFailure(keys, now.Add(2*time.Minute))
+	throttle.reset(keys)
+	throttle.recordFailure(keys, now.Add(2*time.Minute))
+	throttle.recordFailure(keys, now.Add(2*time.Minute))
+	if _, locked := throttle.lockedUntil(keys, now.Add(2*time.Minute)); locked {
+		t.Fatal("窗口过期或登录成功后的失败次数不应累计到之前的计数上")
+	}
+}
+
+func TestSafeRedirect(t *testing.T) {
+	cases := map[string]string{
+		"":                     "/stats",
+		"/keys":                "/keys",
+		"/stats?range=7d":      "/stats?range=7d",
+		"//evil.example":       "/stats",
+		"/\\evil.example":      "/stats",
+		"https://evil.example": "/stats",
+	}
+	for target, expected := range cases {
+		if got := safeRedirect(target); got != expected {
+			t.Errorf("%q: 期望 %q，得到 %q", target, expected, got)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package common
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// safeRedirect 校验登录后的跳转地址，只允许站内相对路径，防止开放重定向
+func safeRedirect(target string) string {
+	if target == "" || !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/stats"
+	}
+	return target
+}
+
+// LoginPageHandler 处理管理员登录页面
+func LoginPageHandler(c *gin.Context) {
+	// 已登录时直接跳转
+	if cookie, err := c.Cookie(AdminSessionCookie); err == nil {
+		if _, ok := ParseAdminSession(cookie); ok {
+			c.Redirect(http.StatusFound, safeRedirect(c.Query("redirect")))
+			return
+		}
+	}
+
+	c.HTML(http.StatusOK, "login.html", gin.H{
+		"Redirect": safeRedirect(c.Query("redirect")),
+	})
+}
+
+// LoginHandler 处理管理员登录请求
+// 同一用户名或同一IP连续登录失败达到上限后暂时禁止登录
+func LoginHandler(c *gin.Context) {
+	username := c.PostForm("username")
+	password := c.PostForm("password")
+	redirect := safeRedirect(c.PostForm("redirect"))
+	keys := loginThrottleKeys(username, c.ClientIP())
+	now := time.Now()
+
+	if until, locked := globalLoginThrottle.lockedUntil(keys, now); locked {
+		logrus.Warnf("管理员登录被暂时禁止: username=%s, ip=%s", username, c.ClientIP())
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(until.Sub(now).Seconds()))))
+		c.HTML(http.StatusTooManyRequests, "login.html", gin.H{
+			"Redirect": redirect,
+			"Username": username,
+			"Error":    "登录失败次数过多，请稍后再试",
+		})
+		return
+	}
+
+	var user *models.AdminUser
+	if username != "" && password != "" {
+		user = AuthenticateAdmin(username, password)
+	}
+	if user == nil {
+		globalLoginThrottle.recordFailure(keys, now)
+		logrus.Warnf("管理员登录失败: username=%s, ip=%s", username, c.ClientIP())
+		c.HTML(http.StatusUnauthorized, "login.html", gin.H{
+			"Redirect": redirect,
+			"Username": username,
+			"Error":    "用户名或密码错误",
+		})
+		return
+	}
+
+	globalLoginThrottle.reset(keys)
+	SetAdminSessionCookie(c, user)
+	logrus.Infof("管理员 %s 登录成功, ip=%s", username, c.ClientIP())
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// LogoutHandler 处理管理员退出登录请求
+// 退出登录时递增会话版本，该管理员在其他设备上的会话同时失效
+func LogoutHandler(c *gin.Context) {
+	if cookie, err := c.Cookie(AdminSessionCookie); err == nil {
+		if username, ok := ParseAdminSession(cookie); ok {
+			if err := db.RevokeAdminSessions(username); err != nil {
+				logrus.Errorf("注销管理员 %s 的会话失败: %v", username, err)
+			}
+		}
+	}
+	ClearAdminSessionCookie(c)
+	c.Redirect(http.StatusFound, "/login")
+}
@@ -0,0 +1,148 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+	"gopkg.in/yaml.v3"
+)
+
+// AuditActorSystem 系统操作（如配置重载）在审计日志中的操作者
+const AuditActorSystem = "system"
+
+// redactedValue 审计日志中替换敏感配置项的值
+const redactedValue = "******"
+
+// sensitiveConfigKeys 审计日志中需要隐藏的配置项
+var sensitiveConfigKeys = map[string]bool{
+	"password":       true,
+	"token":          true,
+	"secret":         true,
+	"session_secret": true,
+	"ed25519_key":    true,
+	"signing_pepper": true,
+}
+
+// RecordAudit 记录一次管理操作，操作者和客户端IP从请求上下文中获取
+// before/after 为操作前后的数据，创建操作的 before 和删除操作的 after 传nil；
+// API密钥的明文不会写入审计日志
+func RecordAudit(c *gin.Context, action, targetType, targetID string, before, after interface{}) {
+	recordAudit(c.GetString(AdminContextKey), c.ClientIP(), action, targetType, targetID, before, after)
+}
+
+// recordAudit 写入审计日志，写入失败只记录错误日志，不影响管理操作本身
+func recordAudit(actor, clientIP, action, targetType, targetID string, before, after interface{}) {
+	entry := &models.AuditLog{
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     auditJSON(before),
+		After:      auditJSON(after),
+		ClientIP:   clientIP,
+		CreatedAt:  time.Now(),
+	}
+	if err := db.CreateAuditLog(entry); err != nil {
+		logrus.Errorf("写入审计日志失败: %s %s %s: %v", actor, action, targetID, err)
+	}
+}
+
+// auditJSON 将审计数据序列化为JSON，nil返回nil
+func auditJSON(value interface{}) []byte {
+	if value == nil {
+		return nil
+	}
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil
+	}
+
+	// 密钥明文和签名密钥只在创建和轮换时返回给管理员，不写入审计日志
+	if apiKey, ok := value.(*models.APIKey); ok {
+		snapshot := *apiKey
+		snapshot.Key = ""
+		snapshot.IssuedSigningSecret = ""
+		snapshot.ExpiresIn = nil
+		value = &snapshot
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		logrus.Errorf("序列化审计数据失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// configSections 将配置转换为按顶层配置节组织的通用结构
+func configSections(cfg *config.Config) map[string]interface{} {
+	sections := map[string]interface{}{}
+	if cfg == nil {
+		return sections
+	}
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		logrus.Errorf("序列化配置失败: %v", err)
+		return sections
+	}
+	if err := yaml.Unmarshal(data, &sections); err != nil {
+		logrus.Errorf("解析配置失败: %v", err)
+		return sections
+	}
+	return sections
+}
+
+// redactConfig 递归隐藏敏感配置项，未设置的敏感项保持为空，便于看出是否已配置
+func redactConfig(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if s, ok := item.(string); ok && sensitiveConfigKeys[key] {
+				if s != "" {
+					v[key] = redactedValue
+				}
+				continue
+			}
+			redactConfig(item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			redactConfig(item)
+		}
+	}
+}
+
+// RecordConfigReload 记录一次配置重载，只记录发生变化的配置节
+// 先比较原始值再隐藏敏感配置项，只修改了密码等敏感项时也会记录所在的配置节；配置文件内容没有变化时不记录
+func RecordConfigReload(oldConfig, newConfig *config.Config) {
+	oldSections := configSections(oldConfig)
+	newSections := configSections(newConfig)
+
+	before := map[string]interface{}{}
+	after := map[string]interface{}{}
+	for name, section := range newSections {
+		if !reflect.DeepEqual(oldSections[name], section) {
+			before[name] = oldSections[name]
+			after[name] = section
+		}
+	}
+	for name, section := range oldSections {
+		if _, ok := newSections[name]; !ok {
+			before[name] = section
+			after[name] = nil
+		}
+	}
+	if len(after) == 0 {
+		return
+	}
+	redactConfig(before)
+	redactConfig(after)
+
+	recordAudit(AuditActorSystem, "", "config.reload", "config", "", before, after)
+}
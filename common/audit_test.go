@@ -0,0 +1,94 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+func TestAuditJSONRedactsAPIKey(t *testing.T) {
+	apiKey := &models.APIKey{
+		ID:                  1,
+		Key:                 "plaintext-key",
+		KeyHash:             "key-hash",
+		IssuedSigningSecret: "issued-signing-secret",
+		Name:                "audited",
+	}
+	data := string(auditJSON(apiKey))
+	for _, secret := range []string{"plaintext-key", "key-hash", "signing-secret"} {
+		if strings.Contains(data, secret) {
+			t.Errorf("审计数据不应包含 %s: %s", secret, data)
+		}
+	}
+	if !strings.Contains(data, `"name":"audited"`) {
+		t.Errorf("审计数据期望包含密钥名称: %s", data)
+	}
+	// 返回给管理员的密钥信息不受影响
+	if apiKey.Key != "plaintext-key" || apiKey.IssuedSigningSecret != "issued-signing-secret" {
+		t.Errorf("序列化审计数据不应修改原始数据: %+v", apiKey)
+	}
+
+	var nilKey *models.APIKey
+	if auditJSON(nilKey) != nil || auditJSON(nil) != nil {
+		t.Error("nil期望返回nil")
+	}
+}
+
+func TestRecordConfigReloadRedactsSecrets(t *testing.T) {
+	setupTestDB(t, nil)
+	oldConfig := &config.Config{}
+	oldConfig.Admin.Password = "old-password"
+	oldConfig.Admin.Token = "old-token"
+	oldConfig.Token.Secret = "old-token-secret"
+
+	newConfig := &config.Config{}
+	newConfig.Admin.Password = "new-password"
+	newConfig.Admin.Token = ""
+	newConfig.Token.Secret = "old-token-secret"
+	newConfig.Token.TTL = 1
+	newConfig.APIKey.SigningPepper = "new-pepper"
+	newConfig.RateLimit.Redis.Password = "redis-password"
+	newConfig.Webhook.Endpoints = []config.WebhookEndpoint{{URL: "https://example.com/hook", Secret: "hook-secret"}}
+
+	RecordConfigReload(oldConfig, newConfig)
+
+	logs, _, err := db.GetAuditLogs(models.AuditLogFilter{Action: "config.reload", Limit: 10})
+	if err != nil {
+		t.Fatalf("查询审计日志失败: %v", err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("期望一条配置重载记录，得到 %d", len(logs))
+	}
+	entry := logs[0]
+	data := string(entry.Before) + string(entry.After)
+	for _, secret := range []string{"old-password", "new-password", "old-token", "old-token-secret", "new-pepper", "redis-password", "hook-secret"} {
+		if strings.Contains(data, secret) {
+			t.Errorf("审计日志不应包含 %s: %s", secret, data)
+		}
+	}
+	// 只修改敏感项的配置节也会记录，未变化的配置节不记录
+	for _, section := range []string{`"admin"`, `"token"`, `"api_key"`, `"rate_limit"`, `"webhook"`} {
+		if !strings.Contains(string(entry.After), section) {
+			t.Errorf("期望记录配置节 %s: %s", section, entry.After)
+		}
+	}
+	if strings.Contains(string(entry.After), `"database"`) {
+		t.Errorf("未变化的配置节不应记录: %s", entry.After)
+	}
+	// 清空的敏感项保持为空，便于看出未配置
+	if !strings.Contains(string(entry.After), `"token":""`) {
+		t.Errorf("清空的敏感项期望保持为空: %s", entry.After)
+	}
+	if entry.Actor != AuditActorSystem {
+		t.Errorf("期望操作者为 %s，得到 %s", AuditActorSystem, entry.Actor)
+	}
+
+	// 配置没有变化时不记录
+	RecordConfigReload(newConfig, newConfig)
+	if _, total, err := db.GetAuditLogs(models.AuditLogFilter{Action: "config.reload"}); err != nil || total != 1 {
+		t.Fatalf("配置没有变化时不应记录，得到 %d %v", total, err)
+	}
+}
@@ -0,0 +1,123 @@
+package common
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+)
+
+// 无法获取处理位置的原因
+var (
+	errConcurrencyQueueFull    = errors.New("排队请求数已达上限")
+	errConcurrencyQueueTimeout = errors.New("排队等待超时")
+)
+
+// concurrencyLimiter 一个插件的并发限制，超出同时处理数的请求按到达顺序排队
+type concurrencyLimiter struct {
+	mutex    sync.Mutex
+	inFlight int        // 正在处理的请求数
+	waiters  *list.List // 排队等待的请求，元素为 chan struct{}，轮到该请求时关闭
+}
+
+// newConcurrencyLimiter 创建并发限制
+func newConcurrencyLimiter() *concurrencyLimiter {
+	return &concurrencyLimiter{waiters: list.New()}
+}
+
+// acquire 获取一个处理位置，需要排队时等待到轮到本请求、等待超时或请求被取消
+func (l *concurrencyLimiter) acquire(ctx context.Context, limit config.ConcurrencyLimit) error {
+	l.mutex.Lock()
+	// 配置重载后并发数可能调大，先让排在前面的请求开始处理
+	l.dispatch(limit.MaxInFlight)
+	if l.inFlight < limit.MaxInFlight && l.waiters.Len() == 0 {
+		l.inFlight++
+		l.mutex.Unlock()
+		return nil
+	}
+	if l.waiters.Len() >= limit.MaxQueue {
+		l.mutex.Unlock()
+		return errConcurrencyQueueFull
+	}
+	ready := make(chan struct{})
+	element := l.waiters.PushBack(ready)
+	l.mutex.Unlock()
+
+	timer := time.NewTimer(limit.QueueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		err = errConcurrencyQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	select {
+	case <-ready:
+		// 超时的同时已轮到本请求，位置已经计入，继续处理
+		return nil
+	default:
+		l.waiters.Remove(element)
+		return err
+	}
+}
+
+// release 释放处理位置，并按当前的并发数让排队的请求开始处理
+func (l *concurrencyLimiter) release(maxInFlight int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.inFlight--
+	l.dispatch(maxInFlight)
+}
+
+// dispatch 在并发数允许的范围内按顺序唤醒排队的请求，调用方需持有 mutex
+func (l *concurrencyLimiter) dispatch(maxInFlight int) {
+	for l.inFlight < maxInFlight && l.waiters.Len() > 0 {
+		close(l.waiters.Remove(l.waiters.Front()).(chan struct{}))
+		l.inFlight++
+	}
+}
+
+// ConcurrencyMiddleware 按配置限制插件同时处理的请求数
+// 超出并发数的请求排队等待，队列已满或等待超时时返回503；每次请求读取最新的配置，重载后立即生效
+func ConcurrencyMiddleware(plugin string) gin.HandlerFunc {
+	limiter := newConcurrencyLimiter()
+	return func(c *gin.Context) {
+		limit, ok := config.GetConcurrencyLimit(plugin)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		if err := limiter.acquire(c.Request.Context(), limit); err != nil {
+			logrus.Debugf("插件 %s 的请求未能开始处理: %v", plugin, err)
+			c.Header("Retry-After", "1")
+			ErrorResponse(c, http.StatusServiceUnavailable, CodeServerBusy, "服务繁忙，请稍后重试")
+			c.Abort()
+			return
+		}
+		defer func() {
+			// 处理期间并发限制被移除时唤醒所有排队的请求
+			maxInFlight := math.MaxInt
+			if limit, ok := config.GetConcurrencyLimit(plugin); ok {
+				maxInFlight = limit.MaxInFlight
+			}
+			limiter.release(maxInFlight)
+		}()
+
+		c.Next()
+	}
+}
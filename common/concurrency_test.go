@@ -0,0 +1,197 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// acquireAsync 在后台获取处理位置，返回接收结果的通道
+func acquireAsync(l *concurrencyLimiter, ctx context.Context, limit config.ConcurrencyLimit) <-chan error {
+	result := make(chan error, 1)
+	go func() { result <- l.acquire(ctx, limit) }()
+	return result
+}
+
+// waitQueued 等待排队的请求数达到 n
+func waitQueued(t *testing.T, l *concurrencyLimiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		l.mutex.Lock()
+		queued := l.waiters.Len()
+		l.mutex.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("排队的请求数没有达到 %d", n)
+}
+
+func TestConcurrencyLimiterQueue(t *testing.T) {
+	l := newConcurrencyLimiter()
+	limit := config.ConcurrencyLimit{MaxInFlight: 1, MaxQueue: 2, QueueTimeout: time.Minute}
+	ctx := context.Background()
+
+	if err := l.acquire(ctx, limit); err != nil {
+		t.Fatalf("第一个请求期望直接开始处理，得到 %v", err)
+	}
+	first := acquireAsync(l, ctx, limit)
+	waitQueued(t, l, 1)
+	second := acquireAsync(l, ctx, limit)
+	waitQueued(t, l, 2)
+
+	// 队列已满时直接拒绝
+	if err := l.acquire(ctx, limit); err != errConcurrencyQueueFull {
+		t.Fatalf("队列已满时期望 errConcurrencyQueueFull，得到 %v", err)
+	}
+
+	// 释放位置后按到达顺序开始处理
+	l.release(limit.MaxInFlight)
+	if err := <-first; err != nil {
+		t.Fatalf("排在最前的请求期望开始处理，得到 %v", err)
+	}
+	select {
+	case err := <-second:
+		t.Fatalf("并发数已满时第二个排队的请求不应开始处理: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.release(limit.MaxInFlight)
+	if err := <-second; err != nil {
+		t.Fatalf("第二个排队的请求期望开始处理，得到 %v", err)
+	}
+	l.release(limit.MaxInFlight)
+	if l.inFlight != 0 || l.waiters.Len() != 0 {
+		t.Fatalf("全部释放后期望没有处理中和排队的请求，得到 %d 和 %d", l.inFlight, l.waiters.Len())
+	}
+}
+
+func TestConcurrencyLimiterTimeoutAndCancel(t *testing.T) {
+	l := newConcurrencyLimiter()
+	limit := config.ConcurrencyLimit{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 20 * time.Millisecond}
+	if err := l.acquire(context.Background(), limit); err != nil {
+		t.Fatalf("第一个请求期望直接开始处理，得到 %v", err)
+	}
+
+	if err := l.acquire(context.Background(), limit); err != errConcurrencyQueueTimeout {
+		t.Fatalf("排队超时期望 errConcurrencyQueueTimeout，得到 %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	limit.QueueTimeout = time.Minute
+	result := acquireAsync(l, ctx, limit)
+	waitQueued(t, l, 1)
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Fatalf("请求取消时期望 context.Canceled，得到 %v", err)
+	}
+
+	// 超时和取消的请求已离开队列，不占用位置
+	if l.waiters.Len() != 0 {
+		t.Fatalf("超时和取消的请求仍在队列中: %d", l.waiters.Len())
+	}
+	l.release(limit.MaxInFlight)
+	if err := l.acquire(context.Background(), limit); err != nil {
+		t.Fatalf("释放后期望可以开始处理，得到 %v", err)
+	}
+}
+
+func TestConcurrencyLimiterRaisedLimit(t *testing.T) {
+	l := newConcurrencyLimiter()
+	limit := config.ConcurrencyLimit{MaxInFlight: 1, MaxQueue: 5, QueueTimeout: time.Minute}
+	ctx := context.Background()
+	l.acquire(ctx, limit)
+	queued := acquireAsync(l, ctx, limit)
+	waitQueued(t, l, 1)
+
+	// 配置重载调大并发数后，新请求先让排队的请求开始处理，自己也不需要排队
+	limit.MaxInFlight = 3
+	if err := l.acquire(ctx, limit); err != nil {
+		t.Fatalf("调大并发数后期望直接开始处理，得到 %v", err)
+	}
+	if err := <-queued; err != nil {
+		t.Fatalf("调大并发数后排队的请求期望开始处理，得到 %v", err)
+	}
+	if l.inFlight != 3 {
+		t.Fatalf("期望3个请求正在处理，得到 %d", l.inFlight)
+	}
+}
+
+func TestConcurrencyMiddlewareAfterAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.Concurrency.Plugins = map[string]config.ConcurrencyLimit{
+			"slow": {MaxInFlight: 1, MaxQueue: 0, QueueTimeout: time.Second},
+		}
+	})
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "slow", MaxUsage: 100, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	other, err := db.CreateAPIKey(&models.APIKey{Name: "other", MaxUsage: 100, Enabled: true, Scopes: []string{"ip"}})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+
+	// 与 RegisterAll 相同的顺序：验证、并发限制、扣除使用次数
+	started, finish := make(chan struct{}), make(chan struct{})
+	r := gin.New()
+	r.GET("/api/slow", PluginContextMiddleware("slow"), APIKeyMiddleware(), ConcurrencyMiddleware("slow"), UsageMiddleware(), func(c *gin.Context) {
+		if c.Query("block") != "" {
+			close(started)
+			<-finish
+		}
+		c.String(http.StatusOK, "ok")
+	})
+	request := func(key, query string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/slow"+query, nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	usage := func(key *models.APIKey) int64 {
+		snapshot := *key
+		globalUsage.apply(&snapshot)
+		return snapshot.CurrentUsage
+	}
+
+	// 占用唯一的处理位置
+	done := make(chan int)
+	go func() { done <- request(apiKey.Key, "?block=1") }()
+	<-started
+
+	// 权限范围不包含该插件的请求在并发限制之前被拒绝，不会得到503
+	if code := request(other.Key, ""); code != http.StatusForbidden {
+		t.Fatalf("未通过权限检查期望 403，得到 %d", code)
+	}
+	if code := request("invalid", ""); code != http.StatusUnauthorized {
+		t.Fatalf("无效密钥期望 401，得到 %d", code)
+	}
+	// 处理位置已满时被拒绝，不消耗使用次数
+	if code := request(apiKey.Key, ""); code != http.StatusServiceUnavailable {
+		t.Fatalf("并发数已满期望 503，得到 %d", code)
+	}
+	if used := usage(apiKey); used != 1 {
+		t.Fatalf("被并发限制拒绝的请求不应消耗使用次数，期望 1，得到 %d", used)
+	}
+
+	close(finish)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("占用处理位置的请求期望 200，得到 %d", code)
+	}
+	if code := request(apiKey.Key, ""); code != http.StatusOK {
+		t.Fatalf("处理位置释放后期望 200，得到 %d", code)
+	}
+	if used := usage(apiKey); used != 2 {
+		t.Fatalf("期望使用 2 次，得到 %d", used)
+	}
+}
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+)
+
+// setupTestDB 使用临时数据库初始化配置和数据库，configure 可以在初始化前修改配置
+func setupTestDB(tb testing.TB, configure func(cfg *config.Config)) {
+	tb.Helper()
+	logrus.SetLevel(logrus.WarnLevel)
+
+	cfg := &config.Config{}
+	cfg.Database.Path = filepath.Join(tb.TempDir(), "test.db")
+	if configure != nil {
+		configure(cfg)
+	}
+	config.GetInstance().SetConfig(cfg)
+
+	if err := db.InitDB(); err != nil {
+		tb.Fatalf("初始化数据库失败: %v", err)
+	}
+	tb.Cleanup(func() { db.CloseDB() })
+
+	// 密钥和账户缓存、内存中的使用次数、已使用的nonce按ID保存，清空上一个测试数据库留下的数据
+	apiKeyCacheInstance.Flush()
+	resetSignatureNonces()
+	globalUsage = newUsageAccumulator(db.FlushAPIKeyUsage)
+	globalAccountUsage = newUsageAccumulator(db.FlushAccountUsage)
+	// 封禁和限流状态按IP保存，同样清空，测试可以重复运行
+	globalIPBans.reset()
+	globalRateLimiter.reset()
+}
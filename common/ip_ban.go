@@ -0,0 +1,201 @@
+package common
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// errIPBanned 客户端IP因多次使用无效密钥被临时封禁
+var errIPBanned = errors.New("无效API密钥尝试次数过多，当前IP已被临时封禁")
+
+// isKeyGuessFailure 判断认证失败是否可能是在猜测密钥，只有这类失败计入封禁次数
+// 缺少密钥、签名时间戳过期、访问令牌过期等是客户端的正常错误，不计入
+func isKeyGuessFailure(err error) bool {
+	return errors.Is(err, errAPIKeyInvalid) || errors.Is(err, errSignatureInvalid)
+}
+
+// authFailures 一个IP在当前时间窗口内的无效密钥次数
+type authFailures struct {
+	count       int
+	windowStart time.Time
+}
+
+// ipBanList 记录各IP的无效密钥次数和当前有效的封禁
+// 封禁同时写入数据库，服务重启后从数据库恢复
+type ipBanList struct {
+	mutex    sync.Mutex
+	failures map[string]*authFailures
+	bans     map[string]time.Time // IP -> 封禁结束时间
+}
+
+// 全局IP封禁列表
+var globalIPBans = &ipBanList{
+	failures: make(map[string]*authFailures),
+	bans:     make(map[string]time.Time),
+}
+
+// ipBanCleanupInterval 清理过期的失败计数和封禁的时间间隔
+const ipBanCleanupInterval = time.Minute
+
+// InitIPBans 从数据库加载仍然有效的封禁，并启动定期清理任务
+func InitIPBans() error {
+	now := time.Now()
+	bans, err := db.GetIPBans(true, now)
+	if err != nil {
+		return err
+	}
+
+	globalIPBans.mutex.Lock()
+	for _, ban := range bans {
+		globalIPBans.bans[ban.IP] = ban.BannedUntil
+	}
+	globalIPBans.mutex.Unlock()
+	if len(bans) > 0 {
+		logrus.Infof("已加载 %d 个有效的IP封禁", len(bans))
+	}
+
+	go func() {
+		ticker := time.NewTicker(ipBanCleanupInterval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			globalIPBans.cleanup(now)
+		}
+	}()
+	return nil
+}
+
+// bannedUntil 返回IP的封禁结束时间，未被封禁时 banned 为false
+func (l *ipBanList) bannedUntil(ip string, now time.Time) (until time.Time, banned bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	until, banned = l.bans[ip]
+	if banned && !now.Before(until) {
+		delete(l.bans, ip)
+		return time.Time{}, false
+	}
+	return until, banned
+}
+
+// recordFailure 记录一次无效密钥，时间窗口内达到阈值时封禁该IP
+func (l *ipBanList) recordFailure(ip string, now time.Time) {
+	threshold := config.GetAPIKeyBanThreshold()
+	window := config.GetAPIKeyBanWindow()
+
+	l.mutex.Lock()
+	f := l.failures[ip]
+	if f == nil || now.Sub(f.windowStart) > window {
+		f = &authFailures{windowStart: now}
+		l.failures[ip] = f
+	}
+	f.count++
+	count := f.count
+	if count < threshold {
+		l.mutex.Unlock()
+		return
+	}
+	// 达到阈值后重新计数，封禁结束后再次达到阈值时再次封禁
+	delete(l.failures, ip)
+	l.mutex.Unlock()
+
+	ban, err := banIP(ip, count, now)
+	if err != nil {
+		logrus.Errorf("封禁IP %s 失败: %v", ip, err)
+		return
+	}
+
+	l.mutex.Lock()
+	l.bans[ip] = ban.BannedUntil
+	l.mutex.Unlock()
+	logrus.Warnf("IP %s 在 %v 内使用无效API密钥 %d 次，第 %d 次封禁，封禁至 %s",
+		ip, window, count, ban.Level, ban.BannedUntil.Format(time.RFC3339))
+}
+
+// banIP 封禁IP并写入数据库，返回封禁记录
+// 上一次封禁结束后不超过最长封禁时长又被封禁时，封禁时长翻倍（不超过最长封禁时长），否则从首次封禁的时长重新开始
+func banIP(ip string, failures int, now time.Time) (*models.IPBan, error) {
+	base := config.GetAPIKeyBanDuration()
+	maxDuration := config.GetAPIKeyBanMaxDuration()
+
+	ban, err := db.GetIPBan(ip)
+	if err != nil && !errors.Is(err, db.ErrIPBanNotFound) {
+		return nil, err
+	}
+	if ban == nil {
+		ban = &models.IPBan{IP: ip, CreatedAt: now}
+	}
+	if ban.Level > 0 && now.Sub(ban.BannedUntil) <= maxDuration {
+		ban.Level++
+	} else {
+		ban.Level = 1
+	}
+
+	duration := base
+	for i := 1; i < ban.Level && duration < maxDuration; i++ {
+		duration *= 2
+	}
+	if duration > maxDuration {
+		duration = maxDuration
+	}
+
+	ban.Failures = failures
+	ban.BannedUntil = now.Add(duration)
+	ban.UpdatedAt = now
+	if err := db.SaveIPBan(ban); err != nil {
+		return nil, err
+	}
+	return ban, nil
+}
+
+// reset 清空内存中的失败计数和封禁，数据库中的封禁记录不受影响
+func (l *ipBanList) reset() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.failures = make(map[string]*authFailures)
+	l.bans = make(map[string]time.Time)
+}
+
+// cleanup 清理已过时间窗口的失败计数和已结束的封禁
+func (l *ipBanList) cleanup(now time.Time) {
+	window := config.GetAPIKeyBanWindow()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for ip, f := range l.failures {
+		if now.Sub(f.windowStart) > window {
+			delete(l.failures, ip)
+		}
+	}
+	for ip, until := range l.bans {
+		if !now.Before(until) {
+			delete(l.bans, ip)
+		}
+	}
+}
+
+// ClearIPBan 解除IP的封禁并删除封禁记录，同时清除该IP的无效密钥计数
+// 返回删除前的封禁记录，IP没有封禁记录时返回 db.ErrIPBanNotFound
+func ClearIPBan(ip string) (*models.IPBan, error) {
+	ban, err := db.GetIPBan(ip)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.DeleteIPBan(ip); err != nil {
+		return nil, err
+	}
+
+	globalIPBans.mutex.Lock()
+	delete(globalIPBans.bans, ip)
+	delete(globalIPBans.failures, ip)
+	globalIPBans.mutex.Unlock()
+	logrus.Infof("IP %s 的封禁已解除", ip)
+	return ban, nil
+}
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+func TestBanIPDuration(t *testing.T) {
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.APIKey.BanDuration = time.Minute
+		cfg.APIKey.BanMaxDuration = 3 * time.Minute
+	})
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	// 封禁结束后很快再次被封禁时时长翻倍，不超过最长封禁时长
+	for i, expected := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		ban, err := banIP("203.0.113.1", 10, now)
+		if err != nil {
+			t.Fatalf("第 %d 次封禁失败: %v", i+1, err)
+		}
+		if ban.Level != i+1 || ban.BannedUntil.Sub(now) != expected {
+			t.Fatalf("第 %d 次封禁: 期望 %v，得到第 %d 次 %v", i+1, expected, ban.Level, ban.BannedUntil.Sub(now))
+		}
+		now = ban.BannedUntil.Add(time.Second)
+	}
+
+	// 上一次封禁结束超过最长封禁时长后重新从首次封禁的时长开始
+	now = now.Add(time.Hour)
+	ban, err := banIP("203.0.113.1", 10, now)
+	if err != nil {
+		t.Fatalf("封禁失败: %v", err)
+	}
+	if ban.Level != 1 || ban.BannedUntil.Sub(now) != time.Minute {
+		t.Fatalf("期望重新从首次封禁开始，得到第 %d 次 %v", ban.Level, ban.BannedUntil.Sub(now))
+	}
+}
+
+func TestRecordFailureWindow(t *testing.T) {
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.APIKey.BanThreshold = 3
+		cfg.APIKey.BanWindow = time.Minute
+	})
+	now := time.Now()
+	const ip = "203.0.113.2"
+
+	// 超出时间窗口的失败重新计数
+	globalIPBans.recordFailure(ip, now)
+	globalIPBans.recordFailure(ip, now)
+	globalIPBans.recordFailure(ip, now.Add(2*time.Minute))
+	if _, banned := globalIPBans.bannedUntil(ip, now.Add(2*time.Minute)); banned {
+		t.Fatal("时间窗口外的失败不应累计")
+	}
+
+	globalIPBans.recordFailure(ip, now.Add(2*time.Minute))
+	globalIPBans.recordFailure(ip, now.Add(2*time.Minute))
+	until, banned := globalIPBans.bannedUntil(ip, now.Add(2*time.Minute))
+	if !banned {
+		t.Fatal("时间窗口内达到阈值期望封禁")
+	}
+	// 封禁写入数据库，封禁结束后自动解除
+	if ban, err := db.GetIPBan(ip); err != nil || ban.Level != 1 || ban.Failures != 3 {
+		t.Fatalf("期望封禁写入数据库，得到 %+v %v", ban, err)
+	}
+	if _, banned := globalIPBans.bannedUntil(ip, until); banned {
+		t.Fatal("封禁结束后期望解除")
+	}
+}
+
+func TestAPIKeyMiddlewareIPBan(t *testing.T) {
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.APIKey.BanThreshold = 3
+	})
+	r := pluginTestRouter()
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "ban", MaxUsage: 100, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	const bannedAddr, otherAddr = "203.0.113.3:1000", "203.0.113.4:1000"
+
+	// 缺少密钥不计入封禁次数
+	for i := 0; i < 3; i++ {
+		pluginRequest(r, "/api/ip/", bannedAddr, nil)
+	}
+	if w, _ := pluginRequest(r, "/api/ip/", bannedAddr, map[string]string{"X-API-Key": apiKey.Key}); w.Code != http.StatusOK {
+		t.Fatalf("缺少密钥不应触发封禁，得到 %d", w.Code)
+	}
+
+	for i := 0; i < 3; i++ {
+		if w, _ := pluginRequest(r, "/api/ip/", bannedAddr, map[string]string{"X-API-Key": "invalid"}); w.Code != http.StatusUnauthorized {
+			t.Fatalf("第 %d 次无效密钥期望 401，得到 %d", i+1, w.Code)
+		}
+	}
+
+	// 封禁后即使使用有效密钥也被拒绝
+	w, code := pluginRequest(r, "/api/ip/", bannedAddr, map[string]string{"X-API-Key": apiKey.Key})
+	if w.Code != http.StatusForbidden || code != CodeIPError || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("封禁后期望 403 %d 并返回 Retry-After，得到 %d %d %q", CodeIPError, w.Code, code, w.Header().Get("Retry-After"))
+	}
+	// 其他IP不受影响
+	if w, _ := pluginRequest(r, "/api/ip/", otherAddr, map[string]string{"X-API-Key": apiKey.Key}); w.Code != http.StatusOK {
+		t.Fatalf("其他IP期望 200，得到 %d", w.Code)
+	}
+
+	// 解除封禁后恢复访问
+	if _, err := ClearIPBan("203.0.113.3"); err != nil {
+		t.Fatalf("解除封禁失败: %v", err)
+	}
+	if w, _ := pluginRequest(r, "/api/ip/", bannedAddr, map[string]string{"X-API-Key": apiKey.Key}); w.Code != http.StatusOK {
+		t.Fatalf("解除封禁后期望 200，得到 %d", w.Code)
+	}
+}
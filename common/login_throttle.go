@@ -0,0 +1,120 @@
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+)
+
+// loginThrottle 按用户名和客户端IP分别统计管理员登录失败次数
+// 任一方在时间窗口内失败次数达到上限后，在锁定时长内拒绝该用户名或IP的登录，不再校验密码
+type loginThrottle struct {
+	mutex    sync.Mutex
+	failures map[string]*authFailures
+	locks    map[string]time.Time // 键 -> 锁定结束时间
+}
+
+// 全局管理员登录失败限制
+var globalLoginThrottle = newLoginThrottle()
+
+// newLoginThrottle 创建登录失败限制
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{
+		failures: make(map[string]*authFailures),
+		locks:    make(map[string]time.Time),
+	}
+}
+
+// init 启动定期清理过期失败计数和锁定的任务
+func init() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			globalLoginThrottle.cleanup(now)
+		}
+	}()
+}
+
+// loginThrottleKeys 登录请求对应的计数键，用户名和IP分别计数
+func loginThrottleKeys(username, ip string) []string {
+	return []string{"user:" + username, "ip:" + ip}
+}
+
+// lockedUntil 返回最晚的锁定结束时间，用户名和IP都未被锁定时 locked 为false
+func (t *loginThrottle) lockedUntil(keys []string, now time.Time) (until time.Time, locked bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, key := range keys {
+		end, exists := t.locks[key]
+		if !exists {
+			continue
+		}
+		if !now.Before(end) {
+			delete(t.locks, key)
+			continue
+		}
+		if end.After(until) {
+			until, locked = end, true
+		}
+	}
+	return until, locked
+}
+
+// recordFailure 记录一次登录失败，时间窗口内达到上限时锁定对应的用户名或IP
+func (t *loginThrottle) recordFailure(keys []string, now time.Time) {
+	maxFailures := config.GetAdminLoginMaxFailures()
+	window := config.GetAdminLoginFailureWindow()
+	lockout := config.GetAdminLoginLockout()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, key := range keys {
+		f := t.failures[key]
+		if f == nil || now.Sub(f.windowStart) > window {
+			f = &authFailures{windowStart: now}
+			t.failures[key] = f
+		}
+		f.count++
+		if f.count < maxFailures {
+			continue
+		}
+		delete(t.failures, key)
+		t.locks[key] = now.Add(lockout)
+		logrus.Warnf("管理员登录在 %v 内失败 %d 次，%s 暂时禁止登录至 %s", window, f.count, key, now.Add(lockout).Format(time.RFC3339))
+	}
+}
+
+// reset 登录成功后清除用户名和IP的失败计数
+func (t *loginThrottle) reset(keys []string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, key := range keys {
+		delete(t.failures, key)
+	}
+}
+
+// cleanup 清理已过时间窗口的失败计数和已结束的锁定
+func (t *loginThrottle) cleanup(now time.Time) {
+	window := config.GetAdminLoginFailureWindow()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for key, f := range t.failures {
+		if now.Sub(f.windowStart) > window {
+			delete(t.failures, key)
+		}
+	}
+	for key, until := range t.locks {
+		if !now.Before(until) {
+			delete(t.locks, key)
+		}
+	}
+}
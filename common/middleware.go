@@ -1,8 +1,12 @@
 package common
 
 import (
+	"errors"
 	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,6 +32,45 @@ func GetAPICache() *cache.Cache {
 	return apiKeyCacheInstance
 }
 
+// InvalidateAPIKey 使密钥的缓存和内存中的使用计数失效，修改密钥属性后调用
+// 当前密钥、轮换前的旧密钥和按ID缓存的条目（包括查询不到时缓存的条目）都会清除；尚未写入数据库的使用次数会先写入，下一次请求时从数据库重新加载
+func InvalidateAPIKey(apiKey *models.APIKey) {
+	for _, cacheKey := range []string{apiKey.KeyHash, apiKey.PrevKeyHash, apiKeyIDCacheKey(apiKey.ID)} {
+		if cacheKey == "" {
+			continue
+		}
+		apiKeyCacheInstance.Delete(cacheKey)
+		apiKeyCacheInstance.Delete(missCacheKey(cacheKey))
+	}
+	globalUsage.forget(apiKey.ID)
+}
+
+// accountCacheKey 缓存账户信息时使用的键
+func accountCacheKey(id int64) string {
+	return "account:" + strconv.FormatInt(id, 10)
+}
+
+// InvalidateAccount 使账户的缓存和内存中的合计使用计数失效，修改或删除账户后调用
+func InvalidateAccount(id int64) {
+	apiKeyCacheInstance.Delete(accountCacheKey(id))
+	globalAccountUsage.forget(id)
+}
+
+// lookupAccount 通过ID获取账户信息，优先从缓存获取
+func lookupAccount(id int64) (*models.Account, error) {
+	cacheKey := accountCacheKey(id)
+	if val, found := apiKeyCacheInstance.Get(cacheKey); found {
+		return val.(*models.Account), nil
+	}
+
+	account, err := db.GetAccountByID(id)
+	if err != nil {
+		return nil, err
+	}
+	apiKeyCacheInstance.Set(cacheKey, account, cache.DefaultExpiration)
+	return account, nil
+}
+
 // StopAPICacheCleanup 停止API密钥缓存的定期清理任务（go-cache不需要单独的清理任务，内部自动处理）
 func StopAPICacheCleanup() {
 	// go-cache内部自动处理清理，不需要单独停止
@@ -53,6 +96,7 @@ func RequestLoggerMiddleware() gin.HandlerFunc {
 			logrus.WithFields(logrus.Fields{
 				"method":     c.Request.Method,
 				"path":       c.Request.URL.Path,
+				"query":      maskedQuery(c.Request.URL), // api_key 参数已掩码
 				"status":     c.Writer.Status(),
 				"client_ip":  c.ClientIP(), // 注意：生产环境中可能需要掩码IP地址
 				"latency":    latency,
@@ -79,10 +123,10 @@ func CORSMiddleware() gin.HandlerFunc {
 
 		// CORS相关头
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-Key-Id, X-Timestamp, X-Nonce, X-Signature, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
 		c.Writer.Header().Set("Access-Control-Max-Age", "3600") // 预检请求结果缓存1小时
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, X-Response-Time")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, X-Response-Time, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After, X-Quota-Limit, X-Quota-Remaining, X-Quota-Cost")
 
 		// 安全头：防止点击劫持
 		c.Writer.Header().Set("X-Frame-Options", "DENY")
@@ -115,187 +159,546 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
-// APIKeyMiddleware API密钥验证中间件
-func APIKeyMiddleware() gin.HandlerFunc {
+// PluginContextKey 上下文中保存当前插件名称的键
+const PluginContextKey = "plugin_name"
+
+// PrincipalContextKey 上下文中保存调用方的键，用于按调用方统计
+// 携带API密钥的请求为 api_key:<ID>，匿名请求为 AnonymousPrincipal
+const PrincipalContextKey = "principal"
+
+// AnonymousPrincipal 匿名请求在统计中的调用方
+const AnonymousPrincipal = "anonymous"
+
+// PluginContextMiddleware 将当前请求所属的插件名称写入上下文
+func PluginContextMiddleware(name string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 从请求头或查询参数中获取API密钥
-		apiKey := c.GetHeader("Authorization")
-		if apiKey == "" {
-			apiKey = c.Query("api_key")
+		c.Set(PluginContextKey, name)
+		c.Next()
+	}
+}
+
+// apiKeyContextKey 上下文中保存已通过验证的API密钥的键，UsageMiddleware 据此扣除使用次数
+const apiKeyContextKey = "api_key"
+
+// credentialContextKey 上下文中保存认证信息验证结果的键
+// 插件路由的速率限制中间件和API密钥验证中间件共用同一结果，签名请求的nonce只能验证一次
+const credentialContextKey = "request_credential"
+
+// 认证失败的原因，错误信息直接返回给客户端
+var (
+	errAPIKeyMissing = errors.New("API密钥不能为空")
+	errAPIKeyInQuery = errors.New("不允许通过查询参数传递API密钥，请使用 Authorization: Bearer 或 X-API-Key 请求头")
+	errAPIKeyInvalid = errors.New("无效的API密钥")
+)
+
+// apiKeyQueryParam 通过查询参数传递API密钥时使用的参数名
+const apiKeyQueryParam = "api_key"
+
+// apiKeyFromRequest 从请求中获取API密钥，fromQuery 表示密钥来自查询参数
+// 依次支持 X-API-Key 请求头、Authorization: Bearer <key>、Authorization: <key>（兼容旧版本）和 api_key 查询参数
+func apiKeyFromRequest(c *gin.Context) (apiKey string, fromQuery bool) {
+	if apiKey = strings.TrimSpace(c.GetHeader("X-API-Key")); apiKey != "" {
+		return apiKey, false
+	}
+
+	if auth := strings.TrimSpace(c.GetHeader("Authorization")); auth != "" {
+		if scheme, token, found := strings.Cut(auth, " "); found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token), false
 		}
+		return auth, false
+	}
 
-		// 检查API密钥是否存在
-		if apiKey == "" {
-			ErrorResponse(c, http.StatusUnauthorized, 401, "API密钥不能为空")
-			c.Abort()
-			return
+	if apiKey = c.Query(apiKeyQueryParam); apiKey != "" {
+		return apiKey, true
+	}
+	return "", false
+}
+
+// requestCredential 请求中认证信息的验证结果
+type requestCredential struct {
+	keyInfo *models.APIKey     // 对应的密钥信息（缓存中的共享实例，只读）
+	keyHash string             // 用于检查轮换宽限期的密钥摘要
+	signed  bool               // 是否为签名请求
+	token   *accessTokenClaims // 使用访问令牌时的令牌声明
+	err     error              // 验证失败的原因
+}
+
+// resolveCredential 获取当前请求的认证信息验证结果，首次调用时验证并保存到上下文
+func resolveCredential(c *gin.Context) *requestCredential {
+	if val, exists := c.Get(credentialContextKey); exists {
+		return val.(*requestCredential)
+	}
+	cred := verifyCredential(c)
+	c.Set(credentialContextKey, cred)
+	return cred
+}
+
+// verifyCredential 验证请求中的认证信息，支持签名请求、访问令牌和直接携带的API密钥
+// 只验证凭据本身，密钥的启用状态、有效期等由 checkCredential 检查
+func verifyCredential(c *gin.Context) *requestCredential {
+	// 被封禁的IP直接拒绝，不再查询密钥
+	if _, banned := globalIPBans.bannedUntil(c.ClientIP(), time.Now()); banned {
+		return &requestCredential{err: errIPBanned}
+	}
+
+	if isSignedRequest(c) {
+		keyInfo, keyHash, err := verifySignedRequest(c)
+		return &requestCredential{keyInfo: keyInfo, keyHash: keyHash, signed: true, err: err}
+	}
+
+	// 从请求头或查询参数中获取API密钥
+	apiKey, fromQuery := apiKeyFromRequest(c)
+	if apiKey == "" {
+		return &requestCredential{err: errAPIKeyMissing}
+	}
+
+	// 查询参数中的密钥容易被记录到访问日志和代理中，可通过配置禁止
+	if fromQuery && config.IsAPIKeyQueryParamDisabled() {
+		return &requestCredential{err: errAPIKeyInQuery}
+	}
+
+	// 访问令牌无状态验证，使用次数计入签发令牌的密钥
+	if isAccessToken(apiKey) {
+		claims, err := parseAccessToken(apiKey, time.Now())
+		if err != nil {
+			return &requestCredential{err: err}
+		}
+		keyID, err := claims.keyID()
+		if err != nil {
+			return &requestCredential{err: errAccessTokenInvalid}
+		}
+		keyInfo, err := lookupAPIKeyByID(keyID)
+		if err != nil {
+			return &requestCredential{err: errAPIKeyInvalid}
 		}
+		return &requestCredential{keyInfo: keyInfo, keyHash: keyInfo.KeyHash, token: claims}
+	}
 
-		// 验证API密钥
-		var keyInfo *models.APIKey
-		// 从缓存获取
-		if val, found := apiKeyCacheInstance.Get(apiKey); found {
-			keyInfo = val.(*models.APIKey)
+	// 缓存和数据库中只保存密钥摘要
+	keyHash := db.HashAPIKey(apiKey)
+	keyInfo, err := lookupAPIKey(keyHash)
+	if err != nil {
+		return &requestCredential{err: errAPIKeyInvalid}
+	}
+	return &requestCredential{keyInfo: keyInfo, keyHash: keyHash}
+}
+
+// checkCredential 检查认证信息和密钥状态（宽限期、签名要求、启用状态、有效期、IP限制）
+// 通过时返回当前请求的密钥副本，并已应用所属套餐的设置和内存中的最新使用情况；未通过时写入错误响应并中止请求
+func checkCredential(c *gin.Context, cred *requestCredential, now time.Time) (*models.APIKey, bool) {
+	if errors.Is(cred.err, errIPBanned) {
+		if until, banned := globalIPBans.bannedUntil(c.ClientIP(), now); banned {
+			c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(until.Sub(now).Seconds())), 10))
+		}
+		ErrorResponse(c, http.StatusForbidden, CodeIPError, cred.err.Error())
+		c.Abort()
+		return nil, false
+	}
+	if errors.Is(cred.err, errSignatureBodySize) {
+		ErrorResponse(c, http.StatusRequestEntityTooLarge, CodeValidationError, cred.err.Error())
+		c.Abort()
+		return nil, false
+	}
+	if cred.err != nil {
+		// 无效密钥和无效签名计入客户端IP的失败次数，达到阈值后临时封禁
+		if isKeyGuessFailure(cred.err) {
+			globalIPBans.recordFailure(c.ClientIP(), now)
 		}
+		ErrorResponse(c, http.StatusUnauthorized, 401, cred.err.Error())
+		c.Abort()
+		return nil, false
+	}
+	cached := cred.keyInfo
 
-		if keyInfo == nil {
-			// 从数据库获取
-			var err error
-			keyInfo, err = db.GetAPIKeyByKey(apiKey)
-			if err != nil {
-				ErrorResponse(c, http.StatusUnauthorized, 401, "无效的API密钥")
-				c.Abort()
-				return
+	// 轮换前的旧密钥超过宽限期后不再可用
+	if !cached.AcceptsHash(cred.keyHash, now) {
+		ErrorResponse(c, http.StatusUnauthorized, CodeAPIKeyExpired, "API密钥已轮换，旧密钥已失效")
+		c.Abort()
+		return nil, false
+	}
+
+	// 要求签名的密钥不接受直接携带密钥的请求（访问令牌只能通过签名请求换取）
+	if cached.RequireSignature && !cred.signed && cred.token == nil {
+		ErrorResponse(c, http.StatusUnauthorized, 401, "该API密钥只接受签名请求")
+		c.Abort()
+		return nil, false
+	}
+
+	// 缓存中的密钥信息在请求间共享，只读；使用情况写入当前请求的副本
+	snapshot := *cached
+	keyInfo := &snapshot
+	applyPlan(keyInfo)
+	globalUsage.apply(keyInfo)
+
+	// 检查API密钥是否已被管理员禁用
+	if !keyInfo.Enabled {
+		ErrorResponse(c, http.StatusForbidden, CodeAPIKeyDisabled, "API密钥已禁用")
+		c.Abort()
+		return nil, false
+	}
+
+	// 检查API密钥是否已过期（绝对过期或闲置过期）
+	if keyInfo.IsExpired(now) {
+		notifyKeyExpired(keyInfo)
+		ErrorResponse(c, http.StatusUnauthorized, CodeAPIKeyExpired, "API密钥已过期")
+		c.Abort()
+		return nil, false
+	}
+
+	// 检查客户端IP是否在密钥允许的网段内
+	if clientIP := c.ClientIP(); !keyInfo.AllowsIP(clientIP) {
+		c.JSON(http.StatusForbidden, &Response{
+			Code: CodeIPError,
+			Msg:  "当前IP不在API密钥允许的地址范围内: " + clientIP,
+			Data: gin.H{"client_ip": clientIP},
+		})
+		c.Abort()
+		return nil, false
+	}
+
+	return keyInfo, true
+}
+
+// maskedQuery 返回用于日志的查询字符串，api_key 参数只保留前缀
+func maskedQuery(u *url.URL) string {
+	if u.RawQuery == "" {
+		return ""
+	}
+
+	// 逐个替换参数，保持其余参数的原始顺序和编码
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		name, value, _ := strings.Cut(param, "=")
+		if key, err := url.QueryUnescape(name); err == nil && key == apiKeyQueryParam {
+			if unescaped, err := url.QueryUnescape(value); err == nil {
+				value = unescaped
 			}
-			// 存入缓存
-			apiKeyCacheInstance.Set(apiKey, keyInfo, cache.DefaultExpiration)
+			params[i] = name + "=" + maskSecret(value)
 		}
+	}
+	return strings.Join(params, "&")
+}
 
-		// 检查API密钥是否已达到使用上限
-		if !keyInfo.IsPermanent && keyInfo.CurrentUsage >= keyInfo.MaxUsage {
-			ErrorResponse(c, http.StatusForbidden, 403, "API密钥已达到使用上限")
-			c.Abort()
-			return
-		}
+// maskSecret 掩码敏感值，较长的值保留与密钥列表一致的前缀便于排查
+func maskSecret(value string) string {
+	if len(value) > 8 {
+		return value[:8] + "***"
+	}
+	return "***"
+}
 
-		// 更新API密钥使用次数
-		if err := db.UpdateAPIKeyUsage(apiKey); err != nil {
-			ErrorResponse(c, http.StatusInternalServerError, 500, "更新API密钥使用次数失败")
-			c.Abort()
-			return
-		}
+// negativeLookupTTL 查询不到的密钥的缓存时间，避免无效密钥反复查询数据库
+const negativeLookupTTL = time.Minute
 
-		// 更新缓存中的使用次数
-		keyInfo.CurrentUsage++
-		apiKeyCacheInstance.Set(apiKey, keyInfo, cache.DefaultExpiration)
+// missCacheKey 缓存查询不到的密钥时使用的键
+func missCacheKey(cacheKey string) string {
+	return "miss:" + cacheKey
+}
 
-		// 将API密钥信息存储到上下文
-		c.Set("api_key", keyInfo)
+// lookupAPIKey 通过密钥摘要获取密钥信息，优先从缓存获取
+// 轮换前的旧密钥也会被查到并以旧摘要缓存，调用方需要通过 AcceptsHash 检查宽限期
+func lookupAPIKey(keyHash string) (*models.APIKey, error) {
+	if val, found := apiKeyCacheInstance.Get(keyHash); found {
+		return val.(*models.APIKey), nil
+	}
+	if _, found := apiKeyCacheInstance.Get(missCacheKey(keyHash)); found {
+		return nil, db.ErrAPIKeyNotFound
+	}
 
-		// 继续处理请求
-		c.Next()
+	// 从数据库获取并存入缓存
+	keyInfo, err := db.GetAPIKeyByHash(keyHash)
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			apiKeyCacheInstance.Set(missCacheKey(keyHash), struct{}{}, negativeLookupTTL)
+		}
+		return nil, err
 	}
+	apiKeyCacheInstance.Set(keyHash, keyInfo, cache.DefaultExpiration)
+	return keyInfo, nil
 }
 
-// tokenBucket 令牌桶
-type tokenBucket struct {
-	capacity       float64    // 令牌桶容量
-	rate           float64    // 令牌生成速率（每秒）
-	tokens         float64    // 当前令牌数量
-	lastRefillTime time.Time  // 上次填充令牌的时间
-	mutex          sync.Mutex // 保护令牌桶的互斥锁
+// apiKeyIDCacheKey 按ID缓存密钥信息时使用的键，与摘要的键不会冲突
+func apiKeyIDCacheKey(id int64) string {
+	return "id:" + strconv.FormatInt(id, 10)
 }
 
-// take 尝试获取一个令牌
-func (tb *tokenBucket) take() bool {
-	tb.mutex.Lock()
-	defer tb.mutex.Unlock()
-
-	now := time.Now()
-	elapsed := now.Sub(tb.lastRefillTime).Seconds()
+// lookupAPIKeyByID 通过密钥ID获取密钥信息（用于签名请求），优先从缓存获取
+func lookupAPIKeyByID(id int64) (*models.APIKey, error) {
+	cacheKey := apiKeyIDCacheKey(id)
+	if val, found := apiKeyCacheInstance.Get(cacheKey); found {
+		return val.(*models.APIKey), nil
+	}
+	if _, found := apiKeyCacheInstance.Get(missCacheKey(cacheKey)); found {
+		return nil, db.ErrAPIKeyNotFound
+	}
 
-	// 填充令牌
-	newTokens := elapsed * tb.rate
-	if newTokens > 0 {
-		tb.tokens = math.Min(tb.tokens+newTokens, tb.capacity)
-		tb.lastRefillTime = now
+	keyInfo, err := db.GetAPIKeyByID(id)
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			apiKeyCacheInstance.Set(missCacheKey(cacheKey), struct{}{}, negativeLookupTTL)
+		}
+		return nil, err
 	}
+	apiKeyCacheInstance.Set(cacheKey, keyInfo, cache.DefaultExpiration)
+	return keyInfo, nil
+}
 
-	// 尝试获取令牌
-	if tb.tokens >= 1 {
-		tb.tokens--
-		return true
+// setQuotaHeaders 写入API密钥配额响应头，永久密钥不限次数，不写入
+func setQuotaHeaders(c *gin.Context, keyInfo *models.APIKey, now time.Time) {
+	if keyInfo.IsPermanent {
+		return
 	}
+	remaining := keyInfo.MaxUsage - keyInfo.EffectiveUsage(now)
+	if remaining < 0 {
+		remaining = 0
+	}
+	c.Header("X-Quota-Limit", strconv.FormatInt(keyInfo.MaxUsage, 10))
+	c.Header("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+}
 
-	return false
+// quotaExceededResponse 返回配额用尽的错误响应
+// 周期配额会在当前周期结束时重置，通过 Retry-After 告知客户端需要等待的秒数；终身配额不会恢复，不写入 Retry-After
+func quotaExceededResponse(c *gin.Context, keyInfo *models.APIKey, now time.Time) {
+	c.Header("X-Quota-Limit", strconv.FormatInt(keyInfo.MaxUsage, 10))
+	c.Header("X-Quota-Remaining", "0")
+	if windowEnd := keyInfo.QuotaWindowEnd(now); !windowEnd.IsZero() {
+		c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(windowEnd.Sub(now).Seconds())), 10))
+	}
+	ErrorResponse(c, http.StatusForbidden, 403, "API密钥已达到使用上限")
 }
 
-// rateLimiter 速率限制器
-type rateLimiter struct {
-	buckets         map[string]*tokenBucket
-	mutex           sync.RWMutex
-	capacity        float64       // 默认令牌桶容量
-	rate            float64       // 默认令牌生成速率（每秒）
-	cleanupInterval time.Duration // 清理过期项的时间间隔
-	inactiveTimeout time.Duration // 令牌桶的不活动超时时间
+// accountQuotaExceededResponse 返回账户合计配额用尽的错误响应，周期配额通过 Retry-After 告知需要等待的秒数
+func accountQuotaExceededResponse(c *gin.Context, account *models.Account, now time.Time) {
+	if windowEnd := account.QuotaWindowEnd(now); !windowEnd.IsZero() {
+		c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(windowEnd.Sub(now).Seconds())), 10))
+	}
+	ErrorResponse(c, http.StatusForbidden, 403, "账户已达到使用上限")
 }
 
-// 全局速率限制器实例
-var globalRateLimiter *rateLimiter
+// anonymousRequest 处理允许匿名访问的请求
+// 每个客户端IP在每条匿名访问规则上单独限流（速率限制豁免网段内的客户端除外），不消耗任何密钥的配额
+func anonymousRequest(c *gin.Context, rule string, limit config.AnonymousLimit) {
+	c.Set(PrincipalContextKey, AnonymousPrincipal)
+	if globalRateLimiter.getSettings().isExempt(c.ClientIP()) {
+		c.Next()
+		return
+	}
+	status := globalRateLimiter.take("anon:"+rule+":"+c.ClientIP(), config.RateLimitPolicy{
+		Algorithm: config.RateLimitTokenBucket,
+		Burst:     limit.RateLimitBurst,
+		Rate:      limit.RateLimitRate,
+	})
+	setRateLimitHeaders(c, status)
+	if !status.allowed {
+		c.Header("Retry-After", strconv.FormatInt(status.retryAfter, 10))
+		ErrorResponse(c, http.StatusTooManyRequests, 429, "匿名请求过于频繁，请稍后再试或使用API密钥")
+		c.Abort()
+		return
+	}
 
-// init 初始化速率限制器
-func init() {
-	globalRateLimiter = &rateLimiter{
-		buckets:         make(map[string]*tokenBucket),
-		capacity:        100,              // 令牌桶容量，允许突发流量
-		rate:            1.666,            // 令牌生成速率，约100个/分钟
-		cleanupInterval: 10 * time.Minute, // 每10分钟清理一次
-		inactiveTimeout: 30 * time.Minute, // 30分钟不活动则清理
-	}
-
-	// 启动定期清理过期项的任务
-	go func() {
-		ticker := time.NewTicker(globalRateLimiter.cleanupInterval)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			globalRateLimiter.cleanupInactiveBuckets()
-		}
-	}()
-}
-
-// Allow 检查是否允许请求
-func (rl *rateLimiter) Allow(key string) bool {
-	// 先获取读锁，检查令牌桶是否存在
-	rl.mutex.RLock()
-	tb, exists := rl.buckets[key]
-	rl.mutex.RUnlock()
-
-	// 如果不存在，创建一个新的令牌桶
-	if !exists {
-		rl.mutex.Lock()
-		// 双重检查，避免并发创建
-		if tb, exists = rl.buckets[key]; !exists {
-			tb = &tokenBucket{
-				capacity:       rl.capacity,
-				rate:           rl.rate,
-				tokens:         rl.capacity, // 初始时填满令牌桶
-				lastRefillTime: time.Now(),
+	c.Next()
+}
+
+// APIKeyMiddleware API密钥验证中间件
+// 支持直接携带密钥、HMAC签名请求（X-Key-Id、X-Timestamp、X-Nonce、X-Signature 请求头）和访问令牌；
+// 配置为允许匿名访问的插件或路径，未携带任何认证信息时按客户端IP限流后放行
+// 只检查密钥状态、IP限制、权限范围和套餐，使用次数由之后的 UsageMiddleware 扣除
+func APIKeyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 验证认证信息和密钥状态，速率限制中间件已验证过时直接复用结果
+		now := time.Now()
+		cred := resolveCredential(c)
+		pluginName := c.GetString(PluginContextKey)
+		if errors.Is(cred.err, errAPIKeyMissing) {
+			if rule, limit, ok := config.GetAnonymousLimit(pluginName, c.Request.URL.Path); ok {
+				anonymousRequest(c, rule, limit)
+				return
 			}
-			rl.buckets[key] = tb
 		}
-		rl.mutex.Unlock()
-	}
+		keyInfo, ok := checkCredential(c, cred, now)
+		if !ok {
+			return
+		}
+		c.Set(PrincipalContextKey, "api_key:"+strconv.FormatInt(keyInfo.ID, 10))
 
-	// 尝试从令牌桶中获取一个令牌
-	return tb.take()
+		// 检查API密钥的权限范围是否包含当前插件和路径
+		// 访问令牌同时受签发时的声明和密钥当前的权限范围限制，签发后收窄的权限立即生效
+		missing := keyInfo.MissingScope(pluginName, c.Request.URL.Path)
+		if missing == "" && cred.token != nil {
+			missing = cred.token.missingScope(pluginName, c.Request.URL.Path)
+		}
+		if missing != "" {
+			c.JSON(http.StatusForbidden, &Response{
+				Code: CodeForbidden,
+				Msg:  "API密钥缺少访问权限: " + missing,
+				Data: gin.H{"missing_scope": missing},
+			})
+			c.Abort()
+			return
+		}
+
+		// 检查密钥所属套餐是否包含当前插件
+		if !planAllowsPlugin(keyInfo, pluginName) {
+			c.JSON(http.StatusForbidden, &Response{
+				Code: CodeForbidden,
+				Msg:  "API密钥所属套餐不包含该插件: " + pluginName,
+				Data: gin.H{"plan": keyInfo.Plan, "plugin": pluginName},
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set(apiKeyContextKey, keyInfo)
+		c.Next()
+	}
 }
 
-// cleanupInactiveBuckets 清理不活动的令牌桶
-func (rl *rateLimiter) cleanupInactiveBuckets() {
-	rl.mutex.Lock()
-	defer rl.mutex.Unlock()
+// UsageMiddleware 扣除API密钥和所属账户的使用次数
+// 需要在 APIKeyMiddleware 和并发限制之后使用，未通过验证、被并发限制拒绝的请求不消耗使用次数；匿名请求直接放行
+func UsageMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		val, exists := c.Get(apiKeyContextKey)
+		if !exists {
+			c.Next()
+			return
+		}
+		keyInfo := val.(*models.APIKey)
+		now := time.Now()
+
+		// 本次调用扣除的计费单位，由命中的路径或插件决定
+		cost := config.GetRequestCost(c.GetString(PluginContextKey), c.Request.URL.Path)
+
+		// 密钥属于账户时，先检查账户下所有密钥合计的使用上限
+		var account *models.Account
+		if keyInfo.AccountID > 0 {
+			cachedAccount, err := lookupAccount(keyInfo.AccountID)
+			if err != nil && !errors.Is(err, db.ErrAccountNotFound) {
+				logrus.Errorf("查询账户失败: %v", err)
+				ErrorResponse(c, http.StatusInternalServerError, CodeDatabaseError, "查询账户失败")
+				c.Abort()
+				return
+			}
+			if cachedAccount != nil {
+				accountSnapshot := *cachedAccount
+				account = &accountSnapshot
+				if err := globalAccountUsage.consumeAccount(account, cost, now); err != nil {
+					accountQuotaExceededResponse(c, account, now)
+					c.Abort()
+					return
+				}
+			}
+		}
 
-	now := time.Now()
-	for key, tb := range rl.buckets {
-		tb.mutex.Lock()
-		inactiveTime := now.Sub(tb.lastRefillTime)
-		tb.mutex.Unlock()
+		// 检查使用上限并记录本次使用（周期配额进入新周期后自动重置），增量由后台任务批量写入数据库
+		if err := globalUsage.consume(keyInfo, cost, now); err != nil {
+			// 本次调用未完成，撤销已计入账户的次数
+			if account != nil {
+				globalAccountUsage.release(account.ID, cost, windowEndUnix(account.QuotaWindowEnd(now)))
+			}
+			notifyQuotaExceeded(keyInfo, now)
+			quotaExceededResponse(c, keyInfo, now)
+			c.Abort()
+			return
+		}
+		notifyQuotaThresholds(keyInfo, cost)
+		setQuotaHeaders(c, keyInfo, now)
+		c.Header("X-Quota-Cost", strconv.FormatInt(cost, 10))
 
-		if inactiveTime > rl.inactiveTimeout {
-			delete(rl.buckets, key)
+		// 将所属账户存储到上下文，API密钥信息已记录本次使用
+		if account != nil {
+			c.Set("account", account)
 		}
+
+		// 继续处理请求
+		c.Next()
 	}
 }
 
-// RateLimitMiddleware 速率限制中间件
+// setRateLimitHeaders 写入IETF草案风格的限流响应头
+// RateLimit-Reset 为令牌桶恢复满额所需的秒数
+func setRateLimitHeaders(c *gin.Context, status rateLimitStatus) {
+	c.Header("RateLimit-Limit", strconv.FormatInt(status.limit, 10))
+	c.Header("RateLimit-Remaining", strconv.FormatInt(status.remaining, 10))
+	c.Header("RateLimit-Reset", strconv.FormatInt(status.reset, 10))
+}
+
+// rateLimitAPIKey 获取请求中有效的API密钥，用于按密钥限流，无效或不按密钥限流时返回nil
+// 验证结果保存在上下文中，API密钥验证中间件可直接复用
+func rateLimitAPIKey(c *gin.Context, byAPIKey bool) *models.APIKey {
+	if !byAPIKey {
+		return nil
+	}
+	cred := resolveCredential(c)
+	if cred.err != nil || !cred.keyInfo.AcceptsHash(cred.keyHash, time.Now()) {
+		return nil
+	}
+	return cred.keyInfo
+}
+
+// RateLimitMiddleware 按客户端IP限流的速率限制中间件，用于插件路由以外的页面和接口
+// 不读取请求中的认证信息，请求路径匹配路径策略时还需要通过该路径单独的限流器；豁免网段内的客户端不受限制
 func RateLimitMiddleware() gin.HandlerFunc {
+	return rateLimitMiddleware(false)
+}
+
+// APIRateLimitMiddleware 插件路由（/api 分组）的速率限制中间件
+// 携带有效API密钥的请求按密钥限流，使用密钥所属套餐或密钥自身的容量和速率（未设置时使用默认策略），算法与默认策略相同；
+// 匿名请求或密钥无效时按客户端IP限流。密钥的验证结果保存在上下文中，由API密钥验证中间件复用
+func APIRateLimitMiddleware() gin.HandlerFunc {
+	return rateLimitMiddleware(true)
+}
+
+// rateLimitMiddleware 创建速率限制中间件，byAPIKey 表示是否验证请求中的API密钥并按密钥限流
+func rateLimitMiddleware(byAPIKey bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 使用客户端IP作为速率限制的键
-		clientIP := c.ClientIP()
+		settings := globalRateLimiter.getSettings()
+		if settings.isExempt(c.ClientIP()) {
+			c.Next()
+			return
+		}
+
+		bucketKey := "ip:" + c.ClientIP()
+		policy := settings.defaultPolicy
+
+		var keyInfo *models.APIKey
+		if cached := rateLimitAPIKey(c, byAPIKey); cached != nil {
+			// 密钥所属套餐的速率限制优先于密钥自身的设置
+			snapshot := *cached
+			keyInfo = &snapshot
+			applyPlan(keyInfo)
+			bucketKey = "key:" + strconv.FormatInt(keyInfo.ID, 10)
+			if keyInfo.RateLimitBurst > 0 {
+				policy.Burst = keyInfo.RateLimitBurst
+			}
+			if keyInfo.RateLimitRate > 0 {
+				policy.Rate = keyInfo.RateLimitRate
+			}
+		}
+
+		// 路径策略按同一调用方在该路径前缀下单独计算，先于默认策略检查，被拒绝时不计入默认策略
+		var routeStatus *rateLimitStatus
+		if prefix, routePolicy, ok := settings.routePolicy(c.Request.URL.Path); ok {
+			status := globalRateLimiter.take("route:"+prefix+":"+bucketKey, routePolicy)
+			routeStatus = &status
+		}
 
-		// 检查是否允许请求
-		if !globalRateLimiter.Allow(clientIP) {
+		// 检查是否允许请求，并在响应头中告知客户端限流状态（同时受路径策略限制时取剩余次数较少的一个）
+		status := rateLimitStatus{}
+		if routeStatus != nil && !routeStatus.allowed {
+			status = *routeStatus
+		} else {
+			status = globalRateLimiter.take(bucketKey, policy)
+			if routeStatus != nil && routeStatus.remaining < status.remaining {
+				status = *routeStatus
+			}
+		}
+		setRateLimitHeaders(c, status)
+		if !status.allowed {
+			// 密钥被频繁限流时通知管理员
+			if keyInfo != nil {
+				notifyRateLimited(keyInfo)
+			}
+			c.Header("Retry-After", strconv.FormatInt(status.retryAfter, 10))
 			ErrorResponse(c, http.StatusTooManyRequests, 429, "请求过于频繁，请稍后再试")
 			c.Abort()
 			return
@@ -308,7 +711,7 @@ func RateLimitMiddleware() gin.HandlerFunc {
 // StatsMiddleware 统计API调用次数的中间件
 func StatsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 记录请求信息
+		// 记录请求信息，只记录路径，不包含可能带有API密钥的查询参数
 		path := c.Request.URL.Path
 		method := c.Request.Method
 		clientIP := c.ClientIP()
@@ -316,12 +719,13 @@ func StatsMiddleware() gin.HandlerFunc {
 		// 处理请求
 		c.Next()
 
-		// 获取响应状态码
+		// 获取响应状态码和调用方
 		statusCode := c.Writer.Status()
+		principal := c.GetString(PrincipalContextKey)
 
 		// 异步记录调用信息，减少对请求响应时间的影响
 		if GlobalStats != nil {
-			go GlobalStats.RecordCall(path, method, clientIP, statusCode)
+			go GlobalStats.RecordCall(path, method, clientIP, principal, statusCode)
 		}
 	}
 }
@@ -0,0 +1,502 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+func TestRateLimitMiddlewareCredentialScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t, nil)
+	ConfigureRateLimiter()
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "limited", MaxUsage: 100, Enabled: true, RateLimitBurst: 3})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+
+	resolved := func(c *gin.Context) {
+		_, exists := c.Get(credentialContextKey)
+		if exists {
+			c.String(http.StatusOK, "resolved")
+		} else {
+			c.String(http.StatusOK, "skipped")
+		}
+	}
+	r := gin.New()
+	r.GET("/api/test", APIRateLimitMiddleware(), resolved)
+	r.GET("/page", RateLimitMiddleware(), resolved)
+
+	cases := []struct {
+		path, remoteAddr string
+		body, limit      string
+	}{
+		// 插件路由验证密钥并使用密钥的令牌桶
+		{"/api/test", "192.0.2.1:1000", "resolved", "3"},
+		// 其他路由不读取认证信息，按客户端IP使用默认策略
+		{"/page", "192.0.2.2:1000", "skipped", "100"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.RemoteAddr = tc.remoteAddr
+		req.Header.Set("X-API-Key", apiKey.Key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Body.String() != tc.body || w.Header().Get("RateLimit-Limit") != tc.limit {
+			t.Errorf("%s: 期望 %s 和限额 %s，得到 %s 和 %s", tc.path, tc.body, tc.limit, w.Body.String(), w.Header().Get("RateLimit-Limit"))
+		}
+	}
+}
+
+// pluginTestRouter 按 RegisterAll 的顺序为插件 ip 注册验证和扣除使用次数的中间件
+func pluginTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/ip/*path", PluginContextMiddleware("ip"), APIKeyMiddleware(), UsageMiddleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	return r
+}
+
+// pluginRequest 携带 headers 中的请求头从 remoteAddr 发送请求，返回响应和响应中的业务错误码
+func pluginRequest(r *gin.Engine, path, remoteAddr string, headers map[string]string) (*httptest.ResponseRecorder, int) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp Response
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp.Code
+}
+
+func TestAPIKeyMiddlewareExpiry(t *testing.T) {
+	setupTestDB(t, nil)
+	r := pluginTestRouter()
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+
+	cases := []struct {
+		name     string
+		key      models.APIKey
+		lastUsed time.Time // 非零时写入最后使用时间
+		status   int
+		code     int
+	}{
+		{"未过期", models.APIKey{ExpiresAt: &future}, time.Time{}, http.StatusOK, 0},
+		{"绝对过期", models.APIKey{ExpiresAt: &past}, time.Time{}, http.StatusUnauthorized, CodeAPIKeyExpired},
+		{"闲置未超时", models.APIKey{IdleTimeout: 3600}, now.Add(-30 * time.Minute), http.StatusOK, 0},
+		{"闲置超时", models.APIKey{IdleTimeout: 3600}, now.Add(-2 * time.Hour), http.StatusUnauthorized, CodeAPIKeyExpired},
+	}
+	for _, tc := range cases {
+		params := tc.key
+		params.Name, params.MaxUsage, params.Enabled = tc.name, 100, true
+		apiKey, err := db.CreateAPIKey(&params)
+		if err != nil {
+			t.Fatalf("%s: 创建API密钥失败: %v", tc.name, err)
+		}
+		if !tc.lastUsed.IsZero() {
+			if _, err := db.DB.Exec("UPDATE api_keys SET last_used_at = ?, created_at = ? WHERE id = ?", tc.lastUsed, tc.lastUsed, apiKey.ID); err != nil {
+				t.Fatalf("%s: 修改最后使用时间失败: %v", tc.name, err)
+			}
+		}
+
+		w, code := pluginRequest(r, "/api/ip/", "192.0.2.1:1000", map[string]string{"X-API-Key": apiKey.Key})
+		if w.Code != tc.status || (tc.code != 0 && code != tc.code) {
+			t.Errorf("%s: 期望 %d/%d，得到 %d/%d", tc.name, tc.status, tc.code, w.Code, code)
+		}
+	}
+}
+
+func TestAPIKeyMiddlewareUpdate(t *testing.T) {
+	setupTestDB(t, nil)
+	r := pluginTestRouter()
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "update", MaxUsage: 2, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	request := func() (int, int) {
+		w, code := pluginRequest(r, "/api/ip/", "192.0.2.1:1000", map[string]string{"X-API-Key": apiKey.Key})
+		return w.Code, code
+	}
+	update := func(u *models.APIKeyUpdate) {
+		t.Helper()
+		if _, err := db.UpdateAPIKey(apiKey.ID, u); err != nil {
+			t.Fatalf("修改API密钥失败: %v", err)
+		}
+		InvalidateAPIKey(apiKey)
+	}
+	enabled, disabled := true, false
+	maxUsage := int64(3)
+
+	if status, _ := request(); status != http.StatusOK {
+		t.Fatalf("期望 200，得到 %d", status)
+	}
+
+	// 禁用后立即拒绝，已使用的次数保留
+	update(&models.APIKeyUpdate{Enabled: &disabled})
+	if status, code := request(); status != http.StatusForbidden || code != CodeAPIKeyDisabled {
+		t.Fatalf("禁用后期望 403/%d，得到 %d/%d", CodeAPIKeyDisabled, status, code)
+	}
+	update(&models.APIKeyUpdate{Enabled: &enabled})
+	if status, _ := request(); status != http.StatusOK {
+		t.Fatalf("重新启用后期望 200，得到 %d", status)
+	}
+
+	// 达到使用上限后提高上限，新的上限立即生效
+	if status, _ := request(); status != http.StatusForbidden {
+		t.Fatalf("达到使用上限期望 403，得到 %d", status)
+	}
+	update(&models.APIKeyUpdate{MaxUsage: &maxUsage})
+	if status, _ := request(); status != http.StatusOK {
+		t.Fatalf("提高使用上限后期望 200，得到 %d", status)
+	}
+	stored, err := db.GetAPIKeyByID(apiKey.ID)
+	if err != nil {
+		t.Fatalf("查询API密钥失败: %v", err)
+	}
+	if stored.MaxUsage != maxUsage || !stored.Enabled {
+		t.Fatalf("修改未保存: %+v", stored)
+	}
+
+	if _, err := db.UpdateAPIKey(apiKey.ID+100, &models.APIKeyUpdate{Enabled: &enabled}); !errors.Is(err, db.ErrAPIKeyNotFound) {
+		t.Fatalf("修改不存在的密钥期望 ErrAPIKeyNotFound，得到 %v", err)
+	}
+}
+
+func TestAPIKeyMiddlewareRotationGrace(t *testing.T) {
+	setupTestDB(t, nil)
+	r := pluginTestRouter()
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "rotate", MaxUsage: 100, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	request := func(key string) (int, int) {
+		w, code := pluginRequest(r, "/api/ip/", "192.0.2.1:1000", map[string]string{"X-API-Key": key})
+		return w.Code, code
+	}
+
+	// 先使用旧密钥，使其进入缓存
+	if status, _ := request(apiKey.Key); status != http.StatusOK {
+		t.Fatalf("轮换前期望 200，得到 %d", status)
+	}
+	rotated, err := db.RotateAPIKey(apiKey.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("轮换失败: %v", err)
+	}
+	InvalidateAPIKey(apiKey)
+
+	for _, key := range []string{rotated.Key, apiKey.Key} {
+		if status, _ := request(key); status != http.StatusOK {
+			t.Fatalf("宽限期内新旧密钥期望 200，得到 %d", status)
+		}
+	}
+
+	// 宽限期结束后旧密钥被拒绝，新密钥不受影响
+	if _, err := db.DB.Exec("UPDATE api_keys SET prev_key_expires_at = ? WHERE id = ?", time.Now().Add(-time.Second), apiKey.ID); err != nil {
+		t.Fatalf("修改宽限期失败: %v", err)
+	}
+	InvalidateAPIKey(rotated)
+	if status, code := request(apiKey.Key); status != http.StatusUnauthorized || code != CodeAPIKeyExpired {
+		t.Fatalf("宽限期结束后旧密钥期望 401/%d，得到 %d/%d", CodeAPIKeyExpired, status, code)
+	}
+	if status, _ := request(rotated.Key); status != http.StatusOK {
+		t.Fatalf("新密钥期望 200，得到 %d", status)
+	}
+}
+
+func TestAPIKeyMiddlewareAllowedCIDRs(t *testing.T) {
+	setupTestDB(t, nil)
+	r := pluginTestRouter()
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "cidr", MaxUsage: 100, Enabled: true, AllowedCIDRs: []string{"192.0.2.0/24"}})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+
+	cases := []struct {
+		remoteAddr string
+		status     int
+	}{
+		{"192.0.2.10:1000", http.StatusOK},
+		{"198.51.100.1:1000", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		w, code := pluginRequest(r, "/api/ip/", tc.remoteAddr, map[string]string{"X-API-Key": apiKey.Key})
+		if w.Code != tc.status {
+			t.Errorf("%s: 期望 %d，得到 %d", tc.remoteAddr, tc.status, w.Code)
+		}
+		if tc.status == http.StatusForbidden && code != CodeIPError {
+			t.Errorf("%s: 期望错误码 %d，得到 %d", tc.remoteAddr, CodeIPError, code)
+		}
+	}
+
+	// 被IP限制拒绝的请求不消耗使用次数
+	snapshot := *apiKey
+	globalUsage.apply(&snapshot)
+	if snapshot.CurrentUsage != 1 {
+		t.Fatalf("期望只计入允许的请求，得到 %d", snapshot.CurrentUsage)
+	}
+}
+
+func TestAPIKeyMiddlewareAccountQuota(t *testing.T) {
+	setupTestDB(t, nil)
+	r := pluginTestRouter()
+	account, err := db.CreateAccount(&models.Account{Name: "customer", MaxUsage: 3})
+	if err != nil {
+		t.Fatalf("创建账户失败: %v", err)
+	}
+	keyA, err := db.CreateAPIKey(&models.APIKey{Name: "a", MaxUsage: 1, Enabled: true, AccountID: account.ID})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	keyB, err := db.CreateAPIKey(&models.APIKey{Name: "b", MaxUsage: 100, Enabled: true, AccountID: account.ID})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+
+	steps := []struct {
+		name   string
+		key    *models.APIKey
+		status int
+	}{
+		{"密钥A第一次", keyA, http.StatusOK},
+		{"密钥A超出自身上限，不计入账户", keyA, http.StatusForbidden},
+		{"密钥B第一次", keyB, http.StatusOK},
+		{"密钥B第二次，账户合计达到上限", keyB, http.StatusOK},
+		{"账户合计已用完", keyB, http.StatusForbidden},
+	}
+	for _, step := range steps {
+		w, _ := pluginRequest(r, "/api/ip/", "192.0.2.1:1000", map[string]string{"X-API-Key": step.key.Key})
+		if w.Code != step.status {
+			t.Fatalf("%s: 期望 %d，得到 %d", step.name, step.status, w.Code)
+		}
+	}
+
+	// 合计使用次数写入账户
+	if err := globalAccountUsage.flush(); err != nil {
+		t.Fatalf("写入账户使用次数失败: %v", err)
+	}
+	stored, err := db.GetAccountByID(account.ID)
+	if err != nil {
+		t.Fatalf("查询账户失败: %v", err)
+	}
+	if stored.CurrentUsage != 3 {
+		t.Fatalf("期望账户合计使用 3 次，得到 %d", stored.CurrentUsage)
+	}
+}
+
+func TestAPIKeyMiddlewareAnonymous(t *testing.T) {
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.Anonymous.Routes = map[string]config.AnonymousLimit{"/api/ip/free": {RateLimitBurst: 2, RateLimitRate: 0.001}}
+	})
+	ConfigureRateLimiter()
+	r := pluginTestRouter()
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "anonymous", MaxUsage: 100, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+
+	cases := []struct {
+		name       string
+		path       string
+		remoteAddr string
+		key        string
+		status     int
+	}{
+		{"匿名第一次", "/api/ip/free", "198.51.100.1:1000", "", http.StatusOK},
+		{"匿名第二次", "/api/ip/free/sub", "198.51.100.1:1000", "", http.StatusOK},
+		{"超出匿名限额", "/api/ip/free", "198.51.100.1:1000", "", http.StatusTooManyRequests},
+		{"其他IP单独计数", "/api/ip/free", "198.51.100.2:1000", "", http.StatusOK},
+		{"未允许匿名访问的路径", "/api/ip/other", "198.51.100.3:1000", "", http.StatusUnauthorized},
+		{"无效密钥不按匿名处理", "/api/ip/free", "198.51.100.4:1000", "invalid", http.StatusUnauthorized},
+		{"携带密钥不受匿名限额限制", "/api/ip/free", "198.51.100.1:1000", apiKey.Key, http.StatusOK},
+	}
+	for _, tc := range cases {
+		headers := map[string]string{}
+		if tc.key != "" {
+			headers["X-API-Key"] = tc.key
+		}
+		w, _ := pluginRequest(r, tc.path, tc.remoteAddr, headers)
+		if w.Code != tc.status {
+			t.Errorf("%s: 期望 %d，得到 %d", tc.name, tc.status, w.Code)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: 期望返回 Retry-After", tc.name)
+		}
+	}
+
+	// 匿名请求不消耗任何密钥的使用次数
+	snapshot := *apiKey
+	globalUsage.apply(&snapshot)
+	if snapshot.CurrentUsage != 1 {
+		t.Fatalf("期望只计入携带密钥的请求，得到 %d", snapshot.CurrentUsage)
+	}
+}
+
+func TestAPIKeyMiddlewareCredentialSources(t *testing.T) {
+	setupTestDB(t, nil)
+	r := pluginTestRouter()
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "sources", MaxUsage: 100, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		status  int
+	}{
+		{"X-API-Key请求头", "/api/ip/", map[string]string{"X-API-Key": apiKey.Key}, http.StatusOK},
+		{"Bearer", "/api/ip/", map[string]string{"Authorization": "Bearer " + apiKey.Key}, http.StatusOK},
+		{"Bearer不区分大小写", "/api/ip/", map[string]string{"Authorization": "bearer " + apiKey.Key}, http.StatusOK},
+		{"Authorization直接传递密钥", "/api/ip/", map[string]string{"Authorization": apiKey.Key}, http.StatusOK},
+		{"查询参数", "/api/ip/?api_key=" + apiKey.Key, nil, http.StatusOK},
+		{"缺少密钥", "/api/ip/", nil, http.StatusUnauthorized},
+		{"其他认证方式", "/api/ip/", map[string]string{"Authorization": "Basic " + apiKey.Key}, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		if w, _ := pluginRequest(r, tc.path, "192.0.2.1:1000", tc.headers); w.Code != tc.status {
+			t.Errorf("%s: 期望 %d，得到 %d", tc.name, tc.status, w.Code)
+		}
+	}
+
+	// 禁止查询参数后只接受请求头中的密钥
+	config.GetInstance().GetConfig().APIKey.DisableQueryParam = true
+	if w, _ := pluginRequest(r, "/api/ip/?api_key="+apiKey.Key, "192.0.2.1:1000", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("禁止查询参数后期望 401，得到 %d", w.Code)
+	}
+	if w, _ := pluginRequest(r, "/api/ip/", "192.0.2.1:1000", map[string]string{"X-API-Key": apiKey.Key}); w.Code != http.StatusOK {
+		t.Fatalf("请求头中的密钥期望 200，得到 %d", w.Code)
+	}
+}
+
+func TestRateLimitAndQuotaHeaders(t *testing.T) {
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.RateLimit.Default = config.RateLimitPolicy{Algorithm: "token_bucket", Burst: 2, Rate: 0.01}
+	})
+	ConfigureRateLimiter()
+
+	// 速率限制：剩余次数递减，用尽后返回 429 和 Retry-After
+	r := gin.New()
+	r.GET("/page", RateLimitMiddleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	for i, expected := range []struct {
+		status    int
+		remaining string
+	}{
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+		{http.StatusTooManyRequests, "0"},
+	} {
+		w, _ := pluginRequest(r, "/page", "198.51.100.10:1000", nil)
+		if w.Code != expected.status || w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != expected.remaining {
+			t.Fatalf("第 %d 次: 期望 %d 剩余 %s，得到 %d 限额 %s 剩余 %s", i+1, expected.status, expected.remaining,
+				w.Code, w.Header().Get("RateLimit-Limit"), w.Header().Get("RateLimit-Remaining"))
+		}
+		if w.Header().Get("RateLimit-Reset") == "" {
+			t.Fatalf("第 %d 次: 期望返回 RateLimit-Reset", i+1)
+		}
+		if expected.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Fatal("限流时期望返回 Retry-After")
+		}
+	}
+
+	// 使用次数：周期配额用尽时通过 Retry-After 告知周期结束时间，终身配额用尽时不返回
+	r = pluginTestRouter()
+	for _, tc := range []struct {
+		period     string
+		retryAfter bool
+	}{
+		{models.QuotaPeriodDaily, true},
+		{models.QuotaPeriodLifetime, false},
+	} {
+		apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "quota", MaxUsage: 2, Enabled: true, QuotaPeriod: tc.period})
+		if err != nil {
+			t.Fatalf("创建API密钥失败: %v", err)
+		}
+		for i, expected := range []struct {
+			status    int
+			remaining string
+		}{
+			{http.StatusOK, "1"},
+			{http.StatusOK, "0"},
+			{http.StatusForbidden, "0"},
+		} {
+			w, _ := pluginRequest(r, "/api/ip/", "198.51.100.11:1000", map[string]string{"X-API-Key": apiKey.Key})
+			if w.Code != expected.status || w.Header().Get("X-Quota-Limit") != "2" || w.Header().Get("X-Quota-Remaining") != expected.remaining {
+				t.Fatalf("周期 %q 第 %d 次: 期望 %d 剩余 %s，得到 %d 限额 %s 剩余 %s", tc.period, i+1, expected.status, expected.remaining,
+					w.Code, w.Header().Get("X-Quota-Limit"), w.Header().Get("X-Quota-Remaining"))
+			}
+			if expected.status == http.StatusForbidden && (w.Header().Get("Retry-After") != "") != tc.retryAfter {
+				t.Fatalf("周期 %q: 期望返回 Retry-After=%v，得到 %q", tc.period, tc.retryAfter, w.Header().Get("Retry-After"))
+			}
+		}
+	}
+}
+
+func TestRateLimitMiddlewareRoutePolicies(t *testing.T) {
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.RateLimit.Default = config.RateLimitPolicy{Algorithm: config.RateLimitTokenBucket, Burst: 5, Rate: 0.01}
+		cfg.RateLimit.Routes = map[string]config.RateLimitPolicy{
+			"/api/ping": {Algorithm: config.RateLimitTokenBucket, Burst: 1, Rate: 0.01},
+		}
+		cfg.RateLimit.ExemptCIDRs = []string{"10.0.0.0/8"}
+	})
+	ConfigureRateLimiter()
+	r := gin.New()
+	r.GET("/*path", RateLimitMiddleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	const addr = "198.51.100.20:1000"
+
+	cases := []struct {
+		name       string
+		path       string
+		remoteAddr string
+		status     int
+		limit      string
+	}{
+		{"路径策略", "/api/ping", addr, http.StatusOK, "1"},
+		{"路径策略的子路径共用限额", "/api/ping/1.1.1.1", addr, http.StatusTooManyRequests, "1"},
+		{"路径前缀按段匹配", "/api/pingx", addr, http.StatusOK, "5"},
+		{"其他路径使用默认策略", "/api/ip", addr, http.StatusOK, "5"},
+		{"其他IP单独计数", "/api/ping", "198.51.100.21:1000", http.StatusOK, "1"},
+	}
+	for _, tc := range cases {
+		w, _ := pluginRequest(r, tc.path, tc.remoteAddr, nil)
+		if w.Code != tc.status || w.Header().Get("RateLimit-Limit") != tc.limit {
+			t.Errorf("%s: 期望 %d 限额 %s，得到 %d 限额 %s", tc.name, tc.status, tc.limit, w.Code, w.Header().Get("RateLimit-Limit"))
+		}
+	}
+
+	// 豁免网段内的IP不受限制，也不返回限流响应头
+	for i := 0; i < 3; i++ {
+		w, _ := pluginRequest(r, "/api/ping", "10.1.2.3:1000", nil)
+		if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("豁免网段第 %d 次: 期望 200 且没有限流响应头，得到 %d %q", i+1, w.Code, w.Header().Get("RateLimit-Limit"))
+		}
+	}
+
+	// 重新加载配置后使用新的限额，已有的令牌桶保留当前令牌数
+	config.GetInstance().GetConfig().RateLimit.Routes["/api/ping"] = config.RateLimitPolicy{Algorithm: config.RateLimitTokenBucket, Burst: 3, Rate: 0.01}
+	ConfigureRateLimiter()
+	if w, _ := pluginRequest(r, "/api/ping", addr, nil); w.Code != http.StatusTooManyRequests || w.Header().Get("RateLimit-Limit") != "3" {
+		t.Fatalf("重新加载后期望保留已用完的令牌桶，得到 %d 限额 %s", w.Code, w.Header().Get("RateLimit-Limit"))
+	}
+	if w, _ := pluginRequest(r, "/api/ping", "198.51.100.22:1000", nil); w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "2" {
+		t.Fatalf("重新加载后新的调用方期望剩余 2 次，得到 %d 剩余 %s", w.Code, w.Header().Get("RateLimit-Remaining"))
+	}
+}
@@ -0,0 +1,43 @@
+package common
+
+import (
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// applyPlan 用密钥所属套餐的设置覆盖密钥自身的配额、配额周期和速率限制
+// apiKey 应为当前请求的副本，不能是缓存中共享的实例；套餐不存在时保持密钥自身的设置
+func applyPlan(apiKey *models.APIKey) {
+	plan, ok := config.GetPlan(apiKey.Plan)
+	if !ok {
+		return
+	}
+
+	if plan.MaxUsage != nil {
+		apiKey.MaxUsage = *plan.MaxUsage
+		apiKey.IsPermanent = *plan.MaxUsage == 0
+	}
+	if plan.QuotaPeriod != nil {
+		apiKey.QuotaPeriod = *plan.QuotaPeriod
+	}
+	if plan.RateLimitBurst > 0 {
+		apiKey.RateLimitBurst = plan.RateLimitBurst
+	}
+	if plan.RateLimitRate > 0 {
+		apiKey.RateLimitRate = plan.RateLimitRate
+	}
+}
+
+// planAllowsPlugin 检查密钥所属套餐是否允许访问指定插件，未使用套餐或套餐未限制插件时允许访问
+func planAllowsPlugin(apiKey *models.APIKey, plugin string) bool {
+	plan, ok := config.GetPlan(apiKey.Plan)
+	if !ok || len(plan.Plugins) == 0 {
+		return true
+	}
+	for _, name := range plan.Plugins {
+		if name == plugin {
+			return true
+		}
+	}
+	return false
+}
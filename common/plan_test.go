@@ -0,0 +1,135 @@
+package common
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+func TestApplyPlan(t *testing.T) {
+	unlimited, daily := int64(0), models.QuotaPeriodDaily
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.Plans = map[string]config.Plan{
+			"free":      {RateLimitBurst: 5, Plugins: []string{"ip"}},
+			"unlimited": {MaxUsage: &unlimited, QuotaPeriod: &daily, RateLimitRate: 50},
+		}
+	})
+
+	cases := []struct {
+		name     string
+		key      models.APIKey
+		expected models.APIKey
+		plugins  map[string]bool
+	}{
+		{
+			"未使用套餐",
+			models.APIKey{MaxUsage: 10, RateLimitBurst: 1},
+			models.APIKey{MaxUsage: 10, RateLimitBurst: 1},
+			map[string]bool{"ip": true, "ping": true},
+		},
+		{
+			"套餐只覆盖设置的字段",
+			models.APIKey{Plan: "free", MaxUsage: 10, RateLimitBurst: 1, RateLimitRate: 2},
+			models.APIKey{Plan: "free", MaxUsage: 10, RateLimitBurst: 5, RateLimitRate: 2},
+			map[string]bool{"ip": true, "ping": false},
+		},
+		{
+			"套餐不限制使用次数",
+			models.APIKey{Plan: "unlimited", MaxUsage: 10},
+			models.APIKey{Plan: "unlimited", IsPermanent: true, QuotaPeriod: daily, RateLimitRate: 50},
+			map[string]bool{"ip": true, "ping": true},
+		},
+		{
+			"套餐已从配置中删除",
+			models.APIKey{Plan: "removed", MaxUsage: 10},
+			models.APIKey{Plan: "removed", MaxUsage: 10},
+			map[string]bool{"ip": true},
+		},
+	}
+	for _, tc := range cases {
+		key := tc.key
+		applyPlan(&key)
+		if key.MaxUsage != tc.expected.MaxUsage || key.IsPermanent != tc.expected.IsPermanent || key.QuotaPeriod != tc.expected.QuotaPeriod ||
+			key.RateLimitBurst != tc.expected.RateLimitBurst || key.RateLimitRate != tc.expected.RateLimitRate {
+			t.Errorf("%s: 期望 %+v，得到 %+v", tc.name, tc.expected, key)
+		}
+		for plugin, allowed := range tc.plugins {
+			if planAllowsPlugin(&key, plugin) != allowed {
+				t.Errorf("%s: 插件 %s 期望允许=%v", tc.name, plugin, allowed)
+			}
+		}
+	}
+}
+
+func TestRequestCost(t *testing.T) {
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.Costs.Default = 2
+		cfg.Costs.Plugins = map[string]int64{"ping": 5, "ip": 3}
+		cfg.Costs.Routes = map[string]int64{"/api/ip/batch": 10, "/api/ip/batch/free": 0}
+	})
+
+	cases := []struct {
+		plugin, path string
+		cost         int64
+	}{
+		{"random", "/api/random", 2},
+		{"ping", "/api/ping", 5},
+		{"ip", "/api/ip/lookup", 3},
+		{"ip", "/api/ip/batch", 10},
+		{"ip", "/api/ip/batch/1", 10},
+		{"ip", "/api/ip/batchx", 3}, // 路径前缀按段匹配
+		{"ip", "/api/ip/batch/free", 0},
+	}
+	for _, tc := range cases {
+		if cost := config.GetRequestCost(tc.plugin, tc.path); cost != tc.cost {
+			t.Errorf("%s %s: 期望 %d，得到 %d", tc.plugin, tc.path, tc.cost, cost)
+		}
+	}
+}
+
+func TestAPIKeyMiddlewarePlan(t *testing.T) {
+	tenUnits := int64(10)
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.Plans = map[string]config.Plan{
+			"basic": {MaxUsage: &tenUnits, Plugins: []string{"ip"}},
+			"other": {Plugins: []string{"ping"}},
+		}
+		cfg.Costs.Plugins = map[string]int64{"ip": 4}
+	})
+	r := pluginTestRouter()
+	basic, err := db.CreateAPIKey(&models.APIKey{Name: "basic", MaxUsage: 1000, Enabled: true, Plan: "basic"})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	other, err := db.CreateAPIKey(&models.APIKey{Name: "other", MaxUsage: 1000, Enabled: true, Plan: "other"})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+
+	// 套餐不包含的插件被拒绝
+	if w, _ := pluginRequest(r, "/api/ip/", "192.0.2.1:1000", map[string]string{"X-API-Key": other.Key}); w.Code != http.StatusForbidden {
+		t.Fatalf("套餐不包含的插件期望 403，得到 %d", w.Code)
+	}
+
+	// 套餐上限10个单位，每次调用4个单位：两次成功，第三次剩余不足
+	steps := []struct {
+		status    int
+		remaining string
+	}{
+		{http.StatusOK, "6"},
+		{http.StatusOK, "2"},
+		{http.StatusForbidden, "0"},
+	}
+	for i, step := range steps {
+		w, _ := pluginRequest(r, "/api/ip/", "192.0.2.1:1000", map[string]string{"X-API-Key": basic.Key})
+		if w.Code != step.status || w.Header().Get("X-Quota-Remaining") != step.remaining {
+			t.Fatalf("第 %d 次: 期望 %d 剩余 %s，得到 %d 剩余 %s", i+1, step.status, step.remaining, w.Code, w.Header().Get("X-Quota-Remaining"))
+		}
+		if step.status == http.StatusOK && w.Header().Get("X-Quota-Cost") != "4" {
+			t.Fatalf("第 %d 次: 期望扣除 4 个单位，得到 %s", i+1, w.Header().Get("X-Quota-Cost"))
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"net"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// ProxyProtocolListener 按配置为监听器启用PROXY协议（v1/v2），未启用时原样返回
+// 来自可信代理的连接可以发送PROXY协议头，请求的来源地址使用协议头中的客户端地址；
+// 其他来源的连接发送PROXY协议头时拒绝请求，未发送时按普通连接处理
+func ProxyProtocolListener(listener net.Listener) net.Listener {
+	if !config.IsProxyProtocolEnabled() {
+		return listener
+	}
+
+	cidrs, err := models.NormalizeCIDRs(config.GetTrustedProxies())
+	if err != nil {
+		logrus.Errorf("解析可信代理失败: %v", err)
+	}
+	var trusted []*net.IPNet
+	for _, cidr := range cidrs {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			trusted = append(trusted, ipNet)
+		}
+	}
+	logrus.Infof("监听端口已启用PROXY协议，可信来源: %v", cidrs)
+	return newProxyProtocolListener(listener, trusted, config.GetProxyProtocolHeaderTimeout())
+}
+
+// newProxyProtocolListener 创建只接受指定网段发送PROXY协议头的监听器
+func newProxyProtocolListener(listener net.Listener, trusted []*net.IPNet, headerTimeout time.Duration) net.Listener {
+	return &proxyproto.Listener{
+		Listener: listener,
+		ConnPolicy: func(options proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+			if addr, ok := options.Upstream.(*net.TCPAddr); ok {
+				for _, ipNet := range trusted {
+					if ipNet.Contains(addr.IP) {
+						return proxyproto.USE, nil
+					}
+				}
+			}
+			return proxyproto.REJECT, nil
+		},
+		ReadHeaderTimeout: headerTimeout,
+	}
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pires/go-proxyproto"
+)
+
+// startProxyProtocolServer 启动启用PROXY协议的测试服务，响应内容为请求的来源IP
+func startProxyProtocolServer(t *testing.T, trustedCIDR string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	_, trusted, _ := net.ParseCIDR(trustedCIDR)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		io.WriteString(w, host)
+	})}
+	go server.Serve(newProxyProtocolListener(listener, []*net.IPNet{trusted}, time.Second))
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+// requestWithHeader 发送可选的PROXY协议头和一次HTTP请求，返回响应内容，连接被断开时返回错误
+func requestWithHeader(t *testing.T, addr string, header []byte) (string, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	conn.Write(header)
+	io.WriteString(conn, "GET / HTTP/1.0\r\nHost: test\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestProxyProtocolFromTrustedSource(t *testing.T) {
+	addr := startProxyProtocolServer(t, "127.0.0.0/8")
+
+	v1 := []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51000 8080\r\n")
+	if ip, err := requestWithHeader(t, addr, v1); err != nil || ip != "203.0.113.7" {
+		t.Fatalf("PROXY协议v1期望来源IP 203.0.113.7，得到 %q（%v）", ip, err)
+	}
+
+	header := &proxyproto.Header{
+		Version:           2,
+		Command:           proxyproto.PROXY,
+		TransportProtocol: proxyproto.TCPv6,
+		SourceAddr:        &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51000},
+		DestinationAddr:   &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 8080},
+	}
+	v2, err := header.Format()
+	if err != nil {
+		t.Fatalf("生成PROXY协议v2头失败: %v", err)
+	}
+	if ip, err := requestWithHeader(t, addr, v2); err != nil || ip != "2001:db8::7" {
+		t.Fatalf("PROXY协议v2期望来源IP 2001:db8::7，得到 %q（%v）", ip, err)
+	}
+
+	// 可信来源不发送协议头时（如健康检查）按普通连接处理
+	if ip, err := requestWithHeader(t, addr, nil); err != nil || ip != "127.0.0.1" {
+		t.Fatalf("未发送协议头期望来源IP 127.0.0.1，得到 %q（%v）", ip, err)
+	}
+}
+
+func TestProxyProtocolFromUntrustedSource(t *testing.T) {
+	addr := startProxyProtocolServer(t, "10.0.0.0/8")
+
+	// 不可信来源发送PROXY协议头时请求被拒绝，不能修改来源IP
+	if ip, err := requestWithHeader(t, addr, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51000 8080\r\n")); err == nil && ip == "203.0.113.7" {
+		t.Fatal("不可信来源发送的PROXY协议头修改了来源IP")
+	}
+	if ip, err := requestWithHeader(t, addr, nil); err != nil || ip != "127.0.0.1" {
+		t.Fatalf("不可信来源的普通连接期望来源IP 127.0.0.1，得到 %q（%v）", ip, err)
+	}
+}
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	if err := r.SetTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+		t.Fatalf("设置可信代理失败: %v", err)
+	}
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+	cases := []struct {
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		// 非可信来源伪造的转发请求头被忽略
+		{"198.51.100.9:1234", map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "1.2.3.4"}, "198.51.100.9"},
+		// 可信代理转发时，从右向左跳过可信代理后的第一个地址为客户端IP，客户端自己添加的地址被忽略
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.7, 10.0.0.2"}, "203.0.113.7"},
+		{"10.0.0.1:1234", map[string]string{"X-Real-IP": "203.0.113.8"}, "203.0.113.8"},
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		for name, value := range tc.headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if ip := strings.TrimSpace(w.Body.String()); ip != tc.expected {
+			t.Fatalf("来源 %s 请求头 %v: 期望客户端IP %s，得到 %s", tc.remoteAddr, tc.headers, tc.expected, ip)
+		}
+	}
+}
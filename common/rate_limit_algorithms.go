@@ -0,0 +1,215 @@
+package common
+
+import (
+	"math"
+	"time"
+)
+
+// ceilSeconds 将时长向上取整为秒数，不足一秒按一秒计算
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// windowDuration 滑动窗口的长度，窗口内最多允许 capacity 次请求，平均速率为 rate
+func windowDuration(capacity, rate float64) time.Duration {
+	return time.Duration(capacity / rate * float64(time.Second))
+}
+
+// noCapacityStatus 容量不足一次（未经配置校验的路径、匿名或套餐策略）时的限流状态
+// 滑动窗口算法按容量计算窗口内的记录和等待时间，容量小于1时直接拒绝所有请求，至少等待一个窗口
+func noCapacityStatus(window time.Duration) rateLimitStatus {
+	return rateLimitStatus{retryAfter: max(ceilSeconds(window), 1)}
+}
+
+// tokenBucket 令牌桶，允许 capacity 次突发请求，之后按 rate 生成令牌
+type tokenBucket struct {
+	capacity       float64   // 令牌桶容量
+	rate           float64   // 令牌生成速率（每秒）
+	tokens         float64   // 当前令牌数量
+	lastRefillTime time.Time // 上次填充令牌的时间
+}
+
+// take 更新令牌桶参数后尝试获取一个令牌，并返回获取后的限流状态
+func (tb *tokenBucket) take(now time.Time, capacity, rate float64) rateLimitStatus {
+	if tb.lastRefillTime.IsZero() {
+		// 初始时填满令牌桶
+		tb.tokens = capacity
+		tb.lastRefillTime = now
+	}
+	if tb.capacity != capacity || tb.rate != rate {
+		tb.capacity = capacity
+		tb.rate = rate
+		tb.tokens = math.Min(tb.tokens, capacity)
+	}
+
+	// 填充令牌
+	newTokens := now.Sub(tb.lastRefillTime).Seconds() * tb.rate
+	if newTokens > 0 {
+		tb.tokens = math.Min(tb.tokens+newTokens, tb.capacity)
+		tb.lastRefillTime = now
+	}
+
+	// 尝试获取令牌
+	allowed := tb.tokens >= 1
+	if allowed {
+		tb.tokens--
+	}
+
+	status := rateLimitStatus{
+		allowed:   allowed,
+		limit:     int64(tb.capacity),
+		remaining: int64(math.Floor(tb.tokens)),
+	}
+	if tb.rate > 0 {
+		status.reset = int64(math.Ceil((tb.capacity - tb.tokens) / tb.rate))
+		if !allowed {
+			status.retryAfter = int64(math.Ceil((1 - tb.tokens) / tb.rate))
+		}
+	}
+	return status
+}
+
+// slidingLog 滑动窗口日志，记录窗口内每次通过的请求时间，任意一段窗口长度内最多通过 capacity 次请求
+// 计数精确，但需要保存窗口内的所有请求时间，适合容量较小的策略
+type slidingLog struct {
+	times []time.Time // 窗口内通过的请求时间，按时间先后排列
+}
+
+// take 移除窗口外的记录后尝试通过一次请求
+func (sl *slidingLog) take(now time.Time, capacity, rate float64) rateLimitStatus {
+	window := windowDuration(capacity, rate)
+	limit := int(capacity)
+	if limit < 1 {
+		return noCapacityStatus(window)
+	}
+
+	// 移除已经滑出窗口的记录
+	expired := 0
+	for expired < len(sl.times) && !now.Before(sl.times[expired].Add(window)) {
+		expired++
+	}
+	sl.times = sl.times[expired:]
+
+	allowed := len(sl.times) < limit
+	if allowed {
+		sl.times = append(sl.times, now)
+	}
+
+	status := rateLimitStatus{
+		allowed:   allowed,
+		limit:     int64(limit),
+		remaining: int64(max(limit-len(sl.times), 0)),
+	}
+	if len(sl.times) > 0 {
+		status.reset = ceilSeconds(sl.times[len(sl.times)-1].Add(window).Sub(now))
+	}
+	if !allowed {
+		// 窗口内的记录减少到 limit-1 条时可以通过，容量调小后可能需要等待多条记录滑出窗口
+		status.retryAfter = ceilSeconds(sl.times[len(sl.times)-limit].Add(window).Sub(now))
+	}
+	return status
+}
+
+// slidingCounter 滑动窗口计数，只保存当前和上一个固定窗口的请求数，
+// 按上一个窗口与滑动窗口重叠的比例估算滑动窗口内的请求数，内存占用固定
+type slidingCounter struct {
+	windowStart time.Time // 当前固定窗口的开始时间
+	previous    float64   // 上一个固定窗口通过的请求数
+	current     float64   // 当前固定窗口通过的请求数
+}
+
+// take 滚动固定窗口后按估算的请求数尝试通过一次请求
+func (sc *slidingCounter) take(now time.Time, capacity, rate float64) rateLimitStatus {
+	window := windowDuration(capacity, rate)
+	if capacity < 1 {
+		return noCapacityStatus(window)
+	}
+	if sc.windowStart.IsZero() {
+		sc.windowStart = now
+	}
+
+	// 滚动到当前时间所在的固定窗口，跨过多个窗口时上一个窗口的计数为0
+	if elapsed := now.Sub(sc.windowStart); elapsed >= window {
+		windows := elapsed / window
+		if windows == 1 {
+			sc.previous = sc.current
+		} else {
+			sc.previous = 0
+		}
+		sc.current = 0
+		sc.windowStart = sc.windowStart.Add(windows * window)
+	}
+
+	elapsed := now.Sub(sc.windowStart)
+	progress := float64(elapsed) / float64(window)
+	estimated := sc.previous*(1-progress) + sc.current
+
+	allowed := estimated+1 <= capacity
+	if allowed {
+		sc.current++
+		estimated++
+	}
+
+	status := rateLimitStatus{
+		allowed:   allowed,
+		limit:     int64(capacity),
+		remaining: int64(math.Max(math.Floor(capacity-estimated), 0)),
+	}
+
+	// 上一个窗口的计数在当前窗口结束时完全滑出，当前窗口的计数还需要再经过一个窗口
+	restore := window - elapsed
+	if sc.current > 0 {
+		restore += window
+	}
+	status.reset = ceilSeconds(restore)
+
+	if !allowed {
+		// 估算的请求数下降到 capacity-1 时可以通过
+		target := capacity - 1
+		var wait time.Duration
+		if sc.current <= target && sc.previous > 0 {
+			// 在当前窗口内，上一个窗口的计数按比例减少到足够小
+			wait = time.Duration((1-(target-sc.current)/sc.previous)*float64(window)) - elapsed
+		} else {
+			// 需要等到下一个窗口，当前窗口的计数作为上一个窗口按比例减少
+			wait = window - elapsed + time.Duration((1-target/sc.current)*float64(window))
+		}
+		status.retryAfter = ceilSeconds(wait)
+	}
+	return status
+}
+
+// gcra 通用信元速率算法（Generic Cell Rate Algorithm），请求按 1/rate 的间隔均匀排布，
+// 最多允许 capacity 次突发请求；只保存理论到达时间，内存占用固定
+type gcra struct {
+	tat time.Time // 理论到达时间，早于当前时间表示已恢复满额
+}
+
+// take 按理论到达时间判断是否允许请求
+func (g *gcra) take(now time.Time, capacity, rate float64) rateLimitStatus {
+	interval := time.Duration(float64(time.Second) / rate)
+	burstOffset := time.Duration(capacity * float64(interval))
+
+	tat := g.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	newTAT := tat.Add(interval)
+	allowAt := newTAT.Add(-burstOffset)
+
+	status := rateLimitStatus{
+		allowed: !now.Before(allowAt),
+		limit:   int64(capacity),
+	}
+	if status.allowed {
+		g.tat = newTAT
+		status.remaining = int64(now.Sub(newTAT.Add(-burstOffset)) / interval)
+	} else {
+		status.retryAfter = ceilSeconds(allowAt.Sub(now))
+	}
+	status.reset = ceilSeconds(g.tat.Sub(now))
+	return status
+}
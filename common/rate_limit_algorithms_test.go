@@ -0,0 +1,245 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xrcuo/xrcuo-api/config"
+)
+
+// fakeClock 测试用的时钟，只在调用 advance 时前进
+type fakeClock struct {
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// limiterStep 一次请求及期望的限流结果，at 为相对起始时间的偏移
+type limiterStep struct {
+	at         time.Duration
+	allowed    bool
+	remaining  int64
+	retryAfter int64
+}
+
+// runLimiterSteps 按时间顺序执行请求并检查每次的限流结果
+func runLimiterSteps(t *testing.T, l limiter, capacity, rate float64, steps []limiterStep) {
+	t.Helper()
+	start := newFakeClock().Now()
+	for i, step := range steps {
+		status := l.take(start.Add(step.at), capacity, rate)
+		if status.allowed != step.allowed || status.remaining != step.remaining || status.retryAfter != step.retryAfter {
+			t.Fatalf("第 %d 次请求（%v）: 得到 allowed=%v remaining=%d retryAfter=%d，期望 allowed=%v remaining=%d retryAfter=%d",
+				i+1, step.at, status.allowed, status.remaining, status.retryAfter, step.allowed, step.remaining, step.retryAfter)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	// 容量3，每秒1个令牌：允许3次突发，之后每秒1次
+	runLimiterSteps(t, &tokenBucket{}, 3, 1, []limiterStep{
+		{at: 0, allowed: true, remaining: 2},
+		{at: 0, allowed: true, remaining: 1},
+		{at: 0, allowed: true, remaining: 0},
+		{at: 0, allowed: false, remaining: 0, retryAfter: 1},
+		{at: 500 * time.Millisecond, allowed: false, remaining: 0, retryAfter: 1},
+		{at: time.Second, allowed: true, remaining: 0},
+		{at: 10 * time.Second, allowed: true, remaining: 2},
+	})
+}
+
+func TestSlidingLog(t *testing.T) {
+	// 每分钟3次：任意60秒内最多3次，最早的请求滑出窗口后才能再次通过
+	runLimiterSteps(t, &slidingLog{}, 3, 3.0/60, []limiterStep{
+		{at: 0, allowed: true, remaining: 2},
+		{at: 10 * time.Second, allowed: true, remaining: 1},
+		{at: 20 * time.Second, allowed: true, remaining: 0},
+		{at: 30 * time.Second, allowed: false, remaining: 0, retryAfter: 30},
+		{at: 60 * time.Second, allowed: true, remaining: 0},
+		{at: 61 * time.Second, allowed: false, remaining: 0, retryAfter: 9},
+		{at: 70 * time.Second, allowed: true, remaining: 0},
+		{at: 200 * time.Second, allowed: true, remaining: 2},
+	})
+}
+
+func TestSlidingLogNoBurstAtWindowBoundary(t *testing.T) {
+	// 令牌桶在窗口边界前后可以连续通过两倍容量的请求，滑动窗口不会
+	l := &slidingLog{}
+	start := newFakeClock().Now()
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		if l.take(start.Add(59*time.Second), 10, 10.0/60).allowed {
+			allowed++
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if l.take(start.Add(61*time.Second), 10, 10.0/60).allowed {
+			allowed++
+		}
+	}
+	if allowed != 10 {
+		t.Fatalf("60秒内通过了 %d 次请求，期望 10 次", allowed)
+	}
+}
+
+func TestLimitersWithoutCapacity(t *testing.T) {
+	// 容量不足一次的策略拒绝所有请求，不会越界
+	algorithms := []string{config.RateLimitTokenBucket, config.RateLimitSlidingLog, config.RateLimitSlidingCounter, config.RateLimitGCRA}
+	start := newFakeClock().Now()
+	for _, algorithm := range algorithms {
+		for _, capacity := range []float64{0, 0.5} {
+			l := newLimiter(algorithm)
+			if status := l.take(start, capacity, 1); status.allowed {
+				t.Errorf("%s 容量 %v: 期望拒绝，得到 %+v", algorithm, capacity, status)
+			}
+		}
+
+		// 已有请求记录后容量被调小
+		l := newLimiter(algorithm)
+		l.take(start, 3, 1)
+		if status := l.take(start.Add(time.Second), 0, 1); status.allowed {
+			t.Errorf("%s 容量调为0: 期望拒绝，得到 %+v", algorithm, status)
+		}
+	}
+
+	runLimiterSteps(t, &slidingLog{}, 0.5, 0.1, []limiterStep{
+		{at: 0, allowed: false, retryAfter: 5},
+		{at: time.Minute, allowed: false, retryAfter: 5},
+	})
+	runLimiterSteps(t, &slidingLog{}, 0, 1, []limiterStep{
+		{at: 0, allowed: false, retryAfter: 1},
+	})
+}
+
+func TestSlidingCounter(t *testing.T) {
+	// 每10秒10次：当前窗口用完后，需要等上一个窗口的计数按比例滑出
+	runLimiterSteps(t, &slidingCounter{}, 10, 1, []limiterStep{
+		{at: 0, allowed: true, remaining: 9},
+		{at: 0, allowed: true, remaining: 8},
+		{at: 0, allowed: true, remaining: 7},
+		{at: 0, allowed: true, remaining: 6},
+		{at: 0, allowed: true, remaining: 5},
+		{at: 0, allowed: true, remaining: 4},
+		{at: 0, allowed: true, remaining: 3},
+		{at: 0, allowed: true, remaining: 2},
+		{at: 0, allowed: true, remaining: 1},
+		{at: 0, allowed: true, remaining: 0},
+		{at: 0, allowed: false, remaining: 0, retryAfter: 11},
+		// 新窗口开始时上一个窗口的10次仍然全部计入
+		{at: 10 * time.Second, allowed: false, remaining: 0, retryAfter: 1},
+		// 1秒后上一个窗口计入9次
+		{at: 11 * time.Second, allowed: true, remaining: 0},
+		{at: 11 * time.Second, allowed: false, remaining: 0, retryAfter: 1},
+		// 跨过两个窗口后计数清零
+		{at: 40 * time.Second, allowed: true, remaining: 9},
+	})
+}
+
+func TestGCRA(t *testing.T) {
+	// 容量3，每秒1次：允许3次突发，之后请求按1秒的间隔均匀通过
+	runLimiterSteps(t, &gcra{}, 3, 1, []limiterStep{
+		{at: 0, allowed: true, remaining: 2},
+		{at: 0, allowed: true, remaining: 1},
+		{at: 0, allowed: true, remaining: 0},
+		{at: 0, allowed: false, remaining: 0, retryAfter: 1},
+		{at: time.Second, allowed: true, remaining: 0},
+		{at: time.Second, allowed: false, remaining: 0, retryAfter: 1},
+		{at: 2500 * time.Millisecond, allowed: true, remaining: 0},
+		{at: 10 * time.Second, allowed: true, remaining: 2},
+	})
+}
+
+func TestLimiterCapacityChange(t *testing.T) {
+	// 按已通过请求计数的算法，调小容量后已通过的请求仍然计入，调回后按原容量继续计算
+	// （令牌桶只保存剩余令牌数，调小容量时剩余令牌数不超过新容量，见 TestRateLimiterKeepsStateAcrossPolicyChange）
+	for _, algorithm := range []string{config.RateLimitSlidingLog, config.RateLimitSlidingCounter, config.RateLimitGCRA} {
+		t.Run(algorithm, func(t *testing.T) {
+			l := newLimiter(algorithm)
+			now := newFakeClock().Now()
+			for i := 0; i < 5; i++ {
+				if !l.take(now, 10, 1).allowed {
+					t.Fatalf("第 %d 次请求被拒绝", i+1)
+				}
+			}
+			if status := l.take(now, 5, 1); status.allowed {
+				t.Fatalf("容量调小到5后仍然允许请求: %+v", status)
+			}
+			if status := l.take(now, 10, 1); !status.allowed || status.remaining != 4 {
+				t.Fatalf("容量恢复为10后期望剩余4次，得到 %+v", status)
+			}
+		})
+	}
+}
+
+func TestRateLimiterKeepsStateAcrossPolicyChange(t *testing.T) {
+	clock := newFakeClock()
+	rl := newRateLimiter(clock.Now)
+	rl.setSettings(&rateLimitSettings{inactiveTimeout: time.Minute})
+
+	policy := config.RateLimitPolicy{Algorithm: config.RateLimitTokenBucket, Burst: 3, Rate: 1}
+	for i := 0; i < 3; i++ {
+		rl.take("ip:1.2.3.4", policy)
+	}
+	if rl.AllowWithLimit("ip:1.2.3.4", policy) {
+		t.Fatal("令牌用完后仍然允许请求")
+	}
+
+	// 只修改容量和速率时保留已有令牌数
+	policy.Burst, policy.Rate = 10, 2
+	if rl.AllowWithLimit("ip:1.2.3.4", policy) {
+		t.Fatal("修改容量后令牌数被重置")
+	}
+
+	// 修改算法时重新计数
+	policy.Algorithm = config.RateLimitGCRA
+	if status := rl.take("ip:1.2.3.4", policy); !status.allowed || status.remaining != 9 {
+		t.Fatalf("切换算法后期望重新计数，得到 %+v", status)
+	}
+
+	// 不同的键互不影响
+	if status := rl.take("ip:5.6.7.8", policy); status.remaining != 9 {
+		t.Fatalf("其他键期望剩余9次，得到 %+v", status)
+	}
+}
+
+func TestRateLimiterCleanup(t *testing.T) {
+	clock := newFakeClock()
+	rl := newRateLimiter(clock.Now)
+	rl.setSettings(&rateLimitSettings{inactiveTimeout: 30 * time.Minute})
+
+	// 每小时10次的滑动窗口，窗口比不活动超时时间长
+	policy := config.RateLimitPolicy{Algorithm: config.RateLimitSlidingLog, Burst: 10, Rate: 10.0 / 3600}
+	for i := 0; i < 10; i++ {
+		rl.take("key:1", policy)
+	}
+	rl.take("key:2", config.RateLimitPolicy{Algorithm: config.RateLimitTokenBucket, Burst: 10, Rate: 1})
+
+	// 超过不活动超时时间，但滑动窗口内的记录尚未过期，不能清理
+	clock.advance(40 * time.Minute)
+	rl.cleanupInactiveBuckets()
+	if _, exists := rl.buckets["key:1"]; !exists {
+		t.Fatal("窗口内仍有记录的限流器被清理")
+	}
+	if _, exists := rl.buckets["key:2"]; exists {
+		t.Fatal("已恢复满额且不活动的限流器没有被清理")
+	}
+	if rl.AllowWithLimit("key:1", policy) {
+		t.Fatal("滑动窗口内已用完的次数被重置")
+	}
+
+	clock.advance(time.Hour)
+	rl.cleanupInactiveBuckets()
+	if _, exists := rl.buckets["key:1"]; exists {
+		t.Fatal("窗口结束且不活动的限流器没有被清理")
+	}
+}
@@ -0,0 +1,252 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+)
+
+// errRateLimitStoreUnavailable 共享存储最近一次操作失败，在重试间隔内不再访问
+var errRateLimitStoreUnavailable = errors.New("共享限流存储暂时不可用")
+
+// rateLimitStore 多实例共享的限流状态存储
+// 操作失败时返回错误，由 rateLimiter 改用进程内的限流器
+type rateLimitStore interface {
+	// take 按指定策略尝试通过一次请求，返回限流状态
+	take(key string, policy config.RateLimitPolicy, now time.Time) (rateLimitStatus, error)
+	// close 关闭存储的连接
+	close() error
+}
+
+// 各算法的Lua脚本，在Redis中原子地读取、更新限流状态
+// 参数：ARGV[1] 容量，ARGV[2] 速率（每秒），ARGV[3] 当前时间（毫秒），ARGV[4] 本次请求的唯一标识（滑动窗口日志使用）
+// 返回：{是否允许, 剩余次数, 恢复满额所需的毫秒数, 被拒绝时距离下一次请求可以通过的毫秒数}
+// 计算方式与进程内的实现（rate_limit_algorithms.go）保持一致
+var rateLimitScripts = map[string]*redis.Script{
+	config.RateLimitTokenBucket: redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = capacity
+  ts = now
+end
+if tokens > capacity then
+  tokens = capacity
+end
+if now > ts then
+  tokens = math.min(tokens + (now - ts) * rate / 1000, capacity)
+  ts = now
+end
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry = math.ceil((1 - tokens) / rate * 1000)
+end
+local reset = math.ceil((capacity - tokens) / rate * 1000)
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(ts))
+redis.call('PEXPIRE', KEYS[1], reset + 1000)
+return {allowed, math.floor(tokens), reset, retry}
+`),
+	config.RateLimitSlidingLog: redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local window = capacity / rate * 1000
+local limit = math.floor(capacity)
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', tostring(now - window))
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+  redis.call('ZADD', KEYS[1], tostring(now), ARGV[4])
+  count = count + 1
+  allowed = 1
+end
+local reset = 0
+local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if #newest > 0 then
+  reset = math.ceil(tonumber(newest[2]) + window - now)
+end
+local retry = 0
+if allowed == 0 then
+  local oldest = redis.call('ZRANGE', KEYS[1], count - limit, count - limit, 'WITHSCORES')
+  retry = math.ceil(tonumber(oldest[2]) + window - now)
+end
+redis.call('PEXPIRE', KEYS[1], math.ceil(window) + 1000)
+return {allowed, math.max(limit - count, 0), reset, retry}
+`),
+	config.RateLimitSlidingCounter: redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local window = capacity / rate * 1000
+local state = redis.call('HMGET', KEYS[1], 'start', 'previous', 'current')
+local start = tonumber(state[1]) or now
+local previous = tonumber(state[2]) or 0
+local current = tonumber(state[3]) or 0
+local elapsed = now - start
+if elapsed >= window then
+  local windows = math.floor(elapsed / window)
+  if windows == 1 then
+    previous = current
+  else
+    previous = 0
+  end
+  current = 0
+  start = start + windows * window
+  elapsed = now - start
+end
+local estimated = previous * (1 - elapsed / window) + current
+local allowed = 0
+if estimated + 1 <= capacity then
+  current = current + 1
+  estimated = estimated + 1
+  allowed = 1
+end
+local reset = window - elapsed
+if current > 0 then
+  reset = reset + window
+end
+local retry = 0
+if allowed == 0 then
+  local target = capacity - 1
+  if current <= target and previous > 0 then
+    retry = (1 - (target - current) / previous) * window - elapsed
+  else
+    retry = window - elapsed + (1 - target / current) * window
+  end
+end
+redis.call('HSET', KEYS[1], 'start', tostring(start), 'previous', tostring(previous), 'current', tostring(current))
+redis.call('PEXPIRE', KEYS[1], math.ceil(reset) + 1000)
+return {allowed, math.max(math.floor(capacity - estimated), 0), math.ceil(reset), math.ceil(retry)}
+`),
+	config.RateLimitGCRA: redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local interval = 1000 / rate
+local burst_offset = capacity * interval
+local stored = tonumber(redis.call('GET', KEYS[1])) or now
+local tat = math.max(stored, now)
+local new_tat = tat + interval
+local allow_at = new_tat - burst_offset
+if now < allow_at then
+  return {0, 0, math.ceil(math.max(stored - now, 0)), math.ceil(allow_at - now)}
+end
+redis.call('SET', KEYS[1], tostring(new_tat), 'PX', math.ceil(new_tat - now) + 1000)
+return {1, math.floor((now - allow_at) / interval), math.ceil(new_tat - now), 0}
+`),
+}
+
+// redisRateLimitStore 使用Redis保存限流状态，多个实例共享计数
+// 各实例的时钟需要同步（如使用NTP），限流计算使用调用方传入的当前时间
+type redisRateLimitStore struct {
+	client        *redis.Client
+	options       config.RateLimitRedis
+	instanceID    string       // 本实例的随机标识，与序号一起保证滑动窗口日志中的记录唯一
+	sequence      atomic.Int64 // 本实例的请求序号
+	retryAt       atomic.Int64 // 操作失败后，下一次尝试访问Redis的时间（Unix纳秒）
+	unavailable   atomic.Bool  // 最近一次操作是否失败，用于只在状态变化时输出日志
+	lastErrorTime atomic.Int64 // 最近一次输出错误日志的时间（Unix纳秒）
+}
+
+// newRedisRateLimitStore 创建Redis限流存储，连接在第一次使用时建立
+func newRedisRateLimitStore(options config.RateLimitRedis) *redisRateLimitStore {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return &redisRateLimitStore{
+		client: redis.NewClient(&redis.Options{
+			Addr:          options.Addr,
+			Password:      options.Password,
+			DB:            options.DB,
+			DialTimeout:   options.Timeout,
+			ReadTimeout:   options.Timeout,
+			WriteTimeout:  options.Timeout,
+			MaxRetries:    -1, // 失败时直接使用本地限流，不重试
+			DialerRetries: 1,
+		}),
+		options:    options,
+		instanceID: hex.EncodeToString(id),
+	}
+}
+
+// take 执行对应算法的Lua脚本，Redis不可用时返回错误
+func (s *redisRateLimitStore) take(key string, policy config.RateLimitPolicy, now time.Time) (rateLimitStatus, error) {
+	if now.UnixNano() < s.retryAt.Load() {
+		return rateLimitStatus{}, errRateLimitStoreUnavailable
+	}
+
+	algorithm := policy.Algorithm
+	script, exists := rateLimitScripts[algorithm]
+	if !exists {
+		algorithm = config.RateLimitTokenBucket
+		script = rateLimitScripts[algorithm]
+	}
+	member := s.instanceID + ":" + strconv.FormatInt(s.sequence.Add(1), 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.Timeout)
+	defer cancel()
+	result, err := script.Run(ctx, s.client, []string{s.options.KeyPrefix + algorithm + ":" + key},
+		policy.Burst, policy.Rate, now.UnixMilli(), member).Int64Slice()
+	if err == nil && len(result) != 4 {
+		err = errors.New("限流脚本返回值无效")
+	}
+	if err != nil {
+		s.markUnavailable(err, now)
+		return rateLimitStatus{}, err
+	}
+
+	if s.unavailable.CompareAndSwap(true, false) {
+		logrus.Info("共享限流存储已恢复，多个实例重新共享限流计数")
+	}
+	return rateLimitStatus{
+		allowed:    result[0] == 1,
+		limit:      policy.Burst,
+		remaining:  result[1],
+		reset:      ceilMilliseconds(result[2]),
+		retryAfter: ceilMilliseconds(result[3]),
+	}, nil
+}
+
+// markUnavailable 记录操作失败，重试间隔内改用本地限流
+func (s *redisRateLimitStore) markUnavailable(err error, now time.Time) {
+	s.retryAt.Store(now.Add(s.options.RetryInterval).UnixNano())
+	if s.unavailable.CompareAndSwap(false, true) {
+		logrus.Warnf("共享限流存储不可用，暂时使用本实例的限流计数: %v", err)
+		s.lastErrorTime.Store(now.UnixNano())
+		return
+	}
+	// 持续不可用时每分钟最多输出一次日志
+	if last := s.lastErrorTime.Load(); now.UnixNano()-last >= int64(time.Minute) && s.lastErrorTime.CompareAndSwap(last, now.UnixNano()) {
+		logrus.Warnf("共享限流存储仍不可用: %v", err)
+	}
+}
+
+// close 关闭Redis连接
+func (s *redisRateLimitStore) close() error {
+	return s.client.Close()
+}
+
+// ceilMilliseconds 将毫秒数向上取整为秒数
+func ceilMilliseconds(ms int64) int64 {
+	if ms <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(float64(ms) / 1000))
+}
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+)
+
+// newTestRedisStore 启动进程内的Redis替身并创建连接到它的限流存储
+func newTestRedisStore(t *testing.T) (*miniredis.Miniredis, *redisRateLimitStore) {
+	t.Helper()
+	logrus.SetLevel(logrus.ErrorLevel)
+
+	server := miniredis.RunT(t)
+	store := newRedisRateLimitStore(config.RateLimitRedis{
+		Addr:          server.Addr(),
+		KeyPrefix:     "test:",
+		Timeout:       time.Second,
+		RetryInterval: 5 * time.Second,
+	})
+	t.Cleanup(func() { store.close() })
+	return server, store
+}
+
+func TestRedisStoreMatchesLocalLimiters(t *testing.T) {
+	_, store := newTestRedisStore(t)
+
+	// 每个算法使用相同的请求序列，共享存储的结果需要与进程内的实现一致
+	offsets := []time.Duration{0, 0, 0, 0, 0, 500 * time.Millisecond, time.Second, time.Second, 2500 * time.Millisecond,
+		4 * time.Second, 4 * time.Second, 4 * time.Second, 11 * time.Second, 12 * time.Second, 40 * time.Second}
+	for _, algorithm := range []string{config.RateLimitTokenBucket, config.RateLimitSlidingLog, config.RateLimitSlidingCounter, config.RateLimitGCRA} {
+		t.Run(algorithm, func(t *testing.T) {
+			policy := config.RateLimitPolicy{Algorithm: algorithm, Burst: 4, Rate: 0.5}
+			local := newLimiter(algorithm)
+			start := newFakeClock().Now()
+			for i, offset := range offsets {
+				now := start.Add(offset)
+				expected := local.take(now, float64(policy.Burst), policy.Rate)
+				actual, err := store.take("ip:1.2.3.4", policy, now)
+				if err != nil {
+					t.Fatalf("第 %d 次请求失败: %v", i+1, err)
+				}
+				if actual != expected {
+					t.Fatalf("第 %d 次请求（%v）: 共享存储得到 %+v，进程内得到 %+v", i+1, offset, actual, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisStoreSharedAcrossInstances(t *testing.T) {
+	server, store := newTestRedisStore(t)
+	other := newRedisRateLimitStore(store.options)
+	t.Cleanup(func() { other.close() })
+
+	// 两个实例使用同一个Redis，合计只能通过 burst 次请求
+	clock := newFakeClock()
+	first := newRateLimiter(clock.Now)
+	first.setSettings(&rateLimitSettings{store: store})
+	second := newRateLimiter(clock.Now)
+	second.setSettings(&rateLimitSettings{store: other})
+
+	policy := config.RateLimitPolicy{Algorithm: config.RateLimitGCRA, Burst: 10, Rate: 1}
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if first.AllowWithLimit("key:1", policy) {
+			allowed++
+		}
+		if second.AllowWithLimit("key:1", policy) {
+			allowed++
+		}
+	}
+	if allowed != 10 {
+		t.Fatalf("两个实例合计通过了 %d 次请求，期望 10 次", allowed)
+	}
+	if !server.Exists("test:gcra:key:1") {
+		t.Fatal("限流状态没有保存到Redis")
+	}
+	if len(first.buckets) != 0 || len(second.buckets) != 0 {
+		t.Fatal("共享存储可用时不应使用进程内的限流器")
+	}
+}
+
+func TestRedisStoreFallbackToLocal(t *testing.T) {
+	server, store := newTestRedisStore(t)
+	clock := newFakeClock()
+	rl := newRateLimiter(clock.Now)
+	rl.setSettings(&rateLimitSettings{store: store})
+	policy := config.RateLimitPolicy{Algorithm: config.RateLimitTokenBucket, Burst: 2, Rate: 1}
+
+	if status := rl.take("ip:1.2.3.4", policy); !status.allowed || status.remaining != 1 {
+		t.Fatalf("期望通过共享存储计数，得到 %+v", status)
+	}
+
+	// Redis不可用时使用进程内的限流器，限流仍然生效
+	server.Close()
+	for i, expected := range []bool{true, true, false} {
+		if status := rl.take("ip:1.2.3.4", policy); status.allowed != expected {
+			t.Fatalf("Redis不可用时第 %d 次请求: 得到 %+v，期望 allowed=%v", i+1, status, expected)
+		}
+	}
+	if len(rl.buckets) != 1 {
+		t.Fatal("Redis不可用时没有使用进程内的限流器")
+	}
+
+	// 重试间隔内不再访问Redis
+	if err := server.Restart(); err != nil {
+		t.Fatalf("重启Redis替身失败: %v", err)
+	}
+	if _, err := store.take("ip:1.2.3.4", policy, clock.Now()); err != errRateLimitStoreUnavailable {
+		t.Fatalf("重试间隔内期望直接返回不可用，得到 %v", err)
+	}
+
+	// 经过重试间隔后恢复使用Redis中的计数
+	clock.advance(5 * time.Second)
+	if status := rl.take("ip:1.2.3.4", policy); !status.allowed || status.remaining != 1 {
+		t.Fatalf("Redis恢复后期望继续使用共享存储的计数，得到 %+v", status)
+	}
+}
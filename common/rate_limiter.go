@@ -0,0 +1,291 @@
+package common
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// rateLimitStatus 一次限流检查的结果，用于生成限流响应头
+type rateLimitStatus struct {
+	allowed    bool  // 是否允许本次请求
+	limit      int64 // 令牌桶容量（滑动窗口为窗口内最多请求数）
+	remaining  int64 // 剩余可用次数
+	reset      int64 // 恢复满额所需的秒数
+	retryAfter int64 // 被拒绝时，距离下一次请求可以通过的秒数
+}
+
+// limiter 限流算法，每个调用方在每条限流规则上使用一个实例
+// 实现只保存状态，时间由调用方传入，并发访问由 rateLimiter 加锁保护
+type limiter interface {
+	// take 使用指定的容量和速率（每秒）尝试通过一次请求，返回限流状态
+	// 参数发生变化时（如配置重载、管理员修改了密钥的速率限制）按新参数继续计算，保留已有状态
+	take(now time.Time, capacity, rate float64) rateLimitStatus
+}
+
+// newLimiter 创建指定算法的限流器，未知的算法使用令牌桶
+func newLimiter(algorithm string) limiter {
+	switch algorithm {
+	case config.RateLimitSlidingLog:
+		return &slidingLog{}
+	case config.RateLimitSlidingCounter:
+		return &slidingCounter{}
+	case config.RateLimitGCRA:
+		return &gcra{}
+	default:
+		return &tokenBucket{}
+	}
+}
+
+// limiterEntry 速率限制器中保存的一个限流器
+type limiterEntry struct {
+	mutex      sync.Mutex
+	algorithm  string
+	limiter    limiter
+	lastUsed   time.Time // 最近一次请求的时间
+	restoredAt time.Time // 按最近一次请求后的状态，恢复满额的时间
+}
+
+// rateLimitSettings 速率限制器的可配置参数，配置重载时整体替换
+type rateLimitSettings struct {
+	defaultPolicy   config.RateLimitPolicy            // 默认策略
+	routes          map[string]config.RateLimitPolicy // 按路径前缀配置的额外策略
+	exemptNets      []*net.IPNet                      // 不受速率限制的网段
+	cleanupInterval time.Duration                     // 清理过期项的时间间隔
+	inactiveTimeout time.Duration                     // 限流器的不活动超时时间
+	store           rateLimitStore                    // 多实例共享的限流状态存储，为nil时只使用进程内的限流器
+}
+
+// rateLimiter 速率限制器，按键保存各调用方的限流器
+// 配置了共享存储时优先使用共享存储，共享存储不可用时使用进程内的限流器
+type rateLimiter struct {
+	buckets       map[string]*limiterEntry
+	mutex         sync.RWMutex
+	settings      *rateLimitSettings
+	settingsMutex sync.RWMutex
+	now           func() time.Time // 时钟，测试中可替换为固定时间
+}
+
+// 全局速率限制器实例
+var globalRateLimiter *rateLimiter
+
+// init 初始化速率限制器，加载配置前使用默认参数
+func init() {
+	globalRateLimiter = newRateLimiter(time.Now)
+	ConfigureRateLimiter()
+
+	// 启动定期清理过期项的任务，每次等待前读取最新的清理间隔
+	go func() {
+		for {
+			time.Sleep(globalRateLimiter.getSettings().cleanupInterval)
+			globalRateLimiter.cleanupInactiveBuckets()
+		}
+	}()
+}
+
+// newRateLimiter 创建使用指定时钟的速率限制器
+func newRateLimiter(now func() time.Time) *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*limiterEntry),
+		now:     now,
+	}
+}
+
+// ConfigureRateLimiter 根据配置更新速率限制的默认策略、路径策略、豁免网段、清理参数和存储后端
+// 启动时和配置重载后调用；已有的限流器保留，下一次请求时按新的容量和速率就地更新，算法发生变化的限流器重新计数
+func ConfigureRateLimiter() {
+	settings := &rateLimitSettings{
+		defaultPolicy:   config.GetRateLimitDefault(),
+		routes:          make(map[string]config.RateLimitPolicy),
+		cleanupInterval: config.GetRateLimitCleanupInterval(),
+		inactiveTimeout: config.GetRateLimitInactiveTimeout(),
+	}
+	for prefix, policy := range config.GetRateLimitRoutes() {
+		settings.routes[prefix] = policy
+	}
+	cidrs, err := models.NormalizeCIDRs(config.GetRateLimitExemptCIDRs())
+	if err != nil {
+		logrus.Errorf("解析速率限制豁免网段失败: %v", err)
+	}
+	for _, cidr := range cidrs {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			settings.exemptNets = append(settings.exemptNets, ipNet)
+		}
+	}
+
+	globalRateLimiter.settingsMutex.Lock()
+	defer globalRateLimiter.settingsMutex.Unlock()
+	settings.store = globalRateLimiter.reuseStore(config.GetRateLimitRedis())
+	globalRateLimiter.settings = settings
+}
+
+// reuseStore 返回与配置对应的共享存储，Redis配置未变化时沿用已有的连接，调用方需持有 settingsMutex
+func (rl *rateLimiter) reuseStore(options *config.RateLimitRedis) rateLimitStore {
+	var current *redisRateLimitStore
+	if rl.settings != nil {
+		current, _ = rl.settings.store.(*redisRateLimitStore)
+	}
+	if current != nil && options != nil && current.options == *options {
+		return current
+	}
+
+	if current != nil {
+		if err := current.close(); err != nil {
+			logrus.Errorf("关闭共享限流存储失败: %v", err)
+		}
+	}
+	if options == nil {
+		return nil
+	}
+	logrus.Infof("速率限制使用Redis共享存储: %s", options.Addr)
+	return newRedisRateLimitStore(*options)
+}
+
+// CloseRateLimitStore 关闭共享限流存储的连接
+func CloseRateLimitStore() {
+	globalRateLimiter.settingsMutex.Lock()
+	defer globalRateLimiter.settingsMutex.Unlock()
+	settings := globalRateLimiter.settings
+	if settings == nil || settings.store == nil {
+		return
+	}
+	if err := settings.store.close(); err != nil {
+		logrus.Errorf("关闭共享限流存储失败: %v", err)
+	}
+
+	// 参数在请求间共享，只读，替换为不使用共享存储的副本
+	closed := *settings
+	closed.store = nil
+	globalRateLimiter.settings = &closed
+}
+
+// setSettings 替换速率限制参数
+func (rl *rateLimiter) setSettings(settings *rateLimitSettings) {
+	rl.settingsMutex.Lock()
+	defer rl.settingsMutex.Unlock()
+	rl.settings = settings
+}
+
+// getSettings 获取当前的速率限制参数，返回的参数只读
+func (rl *rateLimiter) getSettings() *rateLimitSettings {
+	rl.settingsMutex.RLock()
+	defer rl.settingsMutex.RUnlock()
+	return rl.settings
+}
+
+// isExempt 判断客户端IP是否在豁免网段内
+func (s *rateLimitSettings) isExempt(clientIP string) bool {
+	if len(s.exemptNets) == 0 {
+		return false
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range s.exemptNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// routePolicy 获取请求路径匹配的路径策略，最长前缀优先
+func (s *rateLimitSettings) routePolicy(path string) (prefix string, policy config.RateLimitPolicy, ok bool) {
+	for candidate, value := range s.routes {
+		if len(candidate) > len(prefix) && (path == candidate || strings.HasPrefix(path, strings.TrimSuffix(candidate, "/")+"/")) {
+			prefix, policy = candidate, value
+		}
+	}
+	return prefix, policy, prefix != ""
+}
+
+// Allow 使用默认策略检查是否允许请求
+func (rl *rateLimiter) Allow(key string) bool {
+	return rl.AllowWithLimit(key, rl.getSettings().defaultPolicy)
+}
+
+// AllowWithLimit 使用指定的策略检查是否允许请求
+func (rl *rateLimiter) AllowWithLimit(key string, policy config.RateLimitPolicy) bool {
+	return rl.take(key, policy).allowed
+}
+
+// take 按指定策略尝试通过一次请求，返回限流状态
+// 配置了共享存储时在共享存储中计数，共享存储不可用时使用进程内的限流器
+func (rl *rateLimiter) take(key string, policy config.RateLimitPolicy) rateLimitStatus {
+	now := rl.now()
+	if store := rl.getSettings().store; store != nil {
+		if status, err := store.take(key, policy, now); err == nil {
+			return status
+		}
+	}
+	return rl.takeLocal(key, policy, now)
+}
+
+// takeLocal 使用进程内的限流器尝试通过一次请求
+// 限流器已存在但参数发生变化时（如管理员修改了密钥的速率限制），就地更新参数，保留已有状态；算法发生变化时重新计数
+func (rl *rateLimiter) takeLocal(key string, policy config.RateLimitPolicy, now time.Time) rateLimitStatus {
+	// 先获取读锁，检查限流器是否存在
+	rl.mutex.RLock()
+	entry, exists := rl.buckets[key]
+	rl.mutex.RUnlock()
+
+	// 如果不存在，创建一个新的限流器
+	if !exists {
+		rl.mutex.Lock()
+		// 双重检查，避免并发创建
+		if entry, exists = rl.buckets[key]; !exists {
+			entry = &limiterEntry{
+				algorithm: policy.Algorithm,
+				limiter:   newLimiter(policy.Algorithm),
+			}
+			rl.buckets[key] = entry
+		}
+		rl.mutex.Unlock()
+	}
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	if entry.algorithm != policy.Algorithm {
+		entry.algorithm = policy.Algorithm
+		entry.limiter = newLimiter(policy.Algorithm)
+	}
+
+	status := entry.limiter.take(now, float64(policy.Burst), policy.Rate)
+	entry.lastUsed = now
+	entry.restoredAt = now.Add(time.Duration(status.reset) * time.Second)
+	return status
+}
+
+// reset 清空所有进程内的限流器，保留当前配置和共享存储
+func (rl *rateLimiter) reset() {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	rl.buckets = make(map[string]*limiterEntry)
+}
+
+// cleanupInactiveBuckets 清理不活动且已恢复满额的限流器
+// 滑动窗口的窗口可能比不活动超时时间更长，未恢复满额时保留，避免清理后重新计数
+func (rl *rateLimiter) cleanupInactiveBuckets() {
+	inactiveTimeout := rl.getSettings().inactiveTimeout
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.now()
+	for key, entry := range rl.buckets {
+		entry.mutex.Lock()
+		inactive := now.Sub(entry.lastUsed) > inactiveTimeout && !now.Before(entry.restoredAt)
+		entry.mutex.Unlock()
+
+		if inactive {
+			delete(rl.buckets, key)
+		}
+	}
+}
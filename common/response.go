@@ -34,6 +34,10 @@ const (
 	CodeAPIKeyError     = 1001 // API密钥错误
 	CodeIPError         = 1002 // IP相关错误
 	CodeValidationError = 1003 // 数据验证错误
+	CodeAPIKeyExpired   = 1004 // API密钥已过期
+	CodeAPIKeyDisabled  = 1005 // API密钥已禁用
+	CodePluginDisabled  = 1006 // 插件已停用
+	CodeServerBusy      = 1007 // 服务繁忙，并发请求数已达上限
 )
 
 // ErrorType 错误类型
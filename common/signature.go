@@ -0,0 +1,183 @@
+package common
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// 签名请求使用的请求头
+const (
+	signatureKeyIDHeader     = "X-Key-Id"    // API密钥ID
+	signatureTimestampHeader = "X-Timestamp" // 请求时间（Unix秒）
+	signatureNonceHeader     = "X-Nonce"     // 随机字符串，同一密钥在时间窗口内不能重复
+	signatureHeader          = "X-Signature" // 十六进制HMAC-SHA256签名
+)
+
+// maxNonceLength nonce的最大长度
+const maxNonceLength = 128
+
+// 签名验证失败的原因，错误信息直接返回给客户端
+var (
+	errSignatureHeaders   = errors.New("签名请求缺少必要的请求头: X-Key-Id、X-Timestamp、X-Nonce、X-Signature")
+	errSignatureTimestamp = errors.New("签名请求的时间戳无效或超出允许的时间范围")
+	errSignatureNonce     = errors.New("签名请求的nonce无效")
+	errSignatureReplay    = errors.New("签名请求的nonce已被使用")
+	errSignatureInvalid   = errors.New("请求签名无效")
+	errSignatureBodySize  = errors.New("签名请求的请求体超出允许的大小")
+	errSignatureDisabled  = errors.New("服务器未配置签名密钥，不支持签名请求")
+)
+
+// signatureNonceCache 已使用的nonce，有效期为允许的时钟偏差的两倍，超出时间窗口的请求本身会因时间戳被拒绝
+var signatureNonceCache = cache.New(10*time.Minute, time.Minute)
+
+// resetSignatureNonces 清空已使用的nonce
+// nonce按密钥ID记录，更换数据库（如测试之间）后ID会重新分配，需要一并清空
+func resetSignatureNonces() {
+	signatureNonceCache.Flush()
+}
+
+// isSignedRequest 判断请求是否使用签名方式认证
+func isSignedRequest(c *gin.Context) bool {
+	return c.GetHeader(signatureHeader) != "" || c.GetHeader(signatureKeyIDHeader) != ""
+}
+
+// signatureStringToSign 生成待签名字符串，各部分以换行符分隔：
+// 请求方法、路径、排序后的查询字符串、时间戳、nonce、请求体SHA-256摘要（十六进制）
+// 查询参数按参数名排序，同名参数按值排序，使用 url.QueryEscape 编码
+func signatureStringToSign(method, path string, query url.Values, timestamp, nonce string, body []byte) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var params []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			params = append(params, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		strings.Join(params, "&"),
+		timestamp,
+		nonce,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+}
+
+// signRequest 计算请求签名，secret 为创建或轮换密钥时返回的签名密钥（十六进制）
+func signRequest(secret, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignedRequest 验证签名请求，返回签名对应的密钥信息和签名所用密钥的摘要
+// 签名密钥由 api_key.signing_pepper 和密钥摘要派生，不保存在数据库中；未配置时签名请求一律拒绝
+// 轮换宽限期内也接受旧签名密钥的签名，返回旧密钥的摘要
+// 请求体超过 api_key.signature_max_body 时直接拒绝，不读入内存
+// 每个请求只能验证一次（nonce验证通过后即被记录），调用方应通过 resolveCredential 获取结果
+func verifySignedRequest(c *gin.Context) (*models.APIKey, string, error) {
+	keyIDHeader := c.GetHeader(signatureKeyIDHeader)
+	timestamp := c.GetHeader(signatureTimestampHeader)
+	nonce := c.GetHeader(signatureNonceHeader)
+	signature := c.GetHeader(signatureHeader)
+	if keyIDHeader == "" || timestamp == "" || nonce == "" || signature == "" {
+		return nil, "", errSignatureHeaders
+	}
+
+	keyID, err := strconv.ParseInt(keyIDHeader, 10, 64)
+	if err != nil {
+		return nil, "", errSignatureInvalid
+	}
+
+	// 检查时间戳是否在允许的时钟偏差范围内
+	maxSkew := config.GetAPIKeySignatureMaxSkew()
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, "", errSignatureTimestamp
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > maxSkew || skew < -maxSkew {
+		return nil, "", errSignatureTimestamp
+	}
+	if len(nonce) > maxNonceLength {
+		return nil, "", errSignatureNonce
+	}
+	if config.GetAPIKeySigningPepper() == "" {
+		return nil, "", errSignatureDisabled
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return nil, "", errSignatureInvalid
+	}
+
+	keyInfo, err := lookupAPIKeyByID(keyID)
+	if err != nil {
+		return nil, "", errSignatureInvalid
+	}
+
+	// 读取请求体计算摘要后放回，后续处理函数仍可读取
+	var body []byte
+	if c.Request.Body != nil {
+		body, err = io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, config.GetAPIKeySignatureMaxBody()))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, "", errSignatureBodySize
+			}
+			return nil, "", errSignatureInvalid
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	stringToSign := signatureStringToSign(c.Request.Method, c.Request.URL.Path, c.Request.URL.Query(), timestamp, nonce, body)
+	keyHash := ""
+	for _, candidate := range []string{keyInfo.KeyHash, keyInfo.PrevKeyHash} {
+		if candidate == "" {
+			continue
+		}
+		secret, err := db.DeriveSigningSecret(candidate)
+		if err != nil {
+			return nil, "", errSignatureDisabled
+		}
+		actual, _ := hex.DecodeString(signRequest(secret, stringToSign))
+		if hmac.Equal(actual, expected) {
+			keyHash = candidate
+			break
+		}
+	}
+	if keyHash == "" {
+		return nil, "", errSignatureInvalid
+	}
+
+	// 签名有效后才记录nonce，避免伪造的请求占用nonce
+	nonceKey := strconv.FormatInt(keyID, 10) + ":" + nonce
+	if err := signatureNonceCache.Add(nonceKey, struct{}{}, 2*maxSkew); err != nil {
+		return nil, "", errSignatureReplay
+	}
+
+	return keyInfo, keyHash, nil
+}
@@ -0,0 +1,192 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// signedContext 构造一个签名请求的上下文，secret 为签名使用的密钥
+func signedContext(keyID int64, secret, nonce string, ts time.Time, body []byte) *gin.Context {
+	req := httptest.NewRequest(http.MethodPost, "/api/test?b=2&a=1", bytes.NewReader(body))
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	stringToSign := signatureStringToSign(req.Method, req.URL.Path, req.URL.Query(), timestamp, nonce, body)
+	req.Header.Set(signatureKeyIDHeader, strconv.FormatInt(keyID, 10))
+	req.Header.Set(signatureTimestampHeader, timestamp)
+	req.Header.Set(signatureNonceHeader, nonce)
+	req.Header.Set(signatureHeader, signRequest(secret, stringToSign))
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+	return c
+}
+
+func TestVerifySignedRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.APIKey.SigningPepper = "test-pepper"
+		cfg.APIKey.SignatureMaxBody = 64
+	})
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "signed", MaxUsage: 100, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	if apiKey.IssuedSigningSecret == "" || apiKey.IssuedSigningSecret == apiKey.KeyHash {
+		t.Fatalf("签名密钥期望独立于密钥摘要，得到 %q", apiKey.IssuedSigningSecret)
+	}
+
+	now := time.Now()
+	nonce := 0
+	nextNonce := func() string {
+		nonce++
+		return fmt.Sprintf("%s-%d", t.Name(), nonce)
+	}
+
+	cases := []struct {
+		name   string
+		secret string
+		ts     time.Time
+		body   []byte
+		err    error
+	}{
+		{"有效签名", apiKey.IssuedSigningSecret, now, []byte(`{"a":1}`), nil},
+		{"使用密钥摘要签名", apiKey.KeyHash, now, nil, errSignatureInvalid},
+		{"使用明文密钥签名", apiKey.Key, now, nil, errSignatureInvalid},
+		{"时间戳超出范围", apiKey.IssuedSigningSecret, now.Add(-10 * time.Minute), nil, errSignatureTimestamp},
+		{"请求体超出大小", apiKey.IssuedSigningSecret, now, bytes.Repeat([]byte("x"), 65), errSignatureBodySize},
+	}
+	for _, tc := range cases {
+		c := signedContext(apiKey.ID, tc.secret, nextNonce(), tc.ts, tc.body)
+		keyInfo, keyHash, err := verifySignedRequest(c)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: 期望错误 %v，得到 %v", tc.name, tc.err, err)
+			continue
+		}
+		if tc.err != nil {
+			continue
+		}
+		if keyInfo.ID != apiKey.ID || keyHash != apiKey.KeyHash {
+			t.Errorf("%s: 期望返回当前密钥，得到 %d %s", tc.name, keyInfo.ID, keyHash)
+		}
+		// 验证后请求体仍可被后续处理函数读取
+		var buf bytes.Buffer
+		buf.ReadFrom(c.Request.Body)
+		if !bytes.Equal(buf.Bytes(), tc.body) {
+			t.Errorf("%s: 请求体期望保留 %q，得到 %q", tc.name, tc.body, buf.String())
+		}
+	}
+}
+
+// setSigningPepper 设置派生签名密钥使用的服务器密钥
+func setSigningPepper(cfg *config.Config) {
+	cfg.APIKey.SigningPepper = "test-pepper"
+}
+
+func TestVerifySignedRequestReplay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t, setSigningPepper)
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "replay", MaxUsage: 100, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+
+	now := time.Now()
+	nonce := t.Name()
+
+	// 伪造的签名不会占用nonce
+	forged := signedContext(apiKey.ID, strings.Repeat("0", 64), nonce, now, nil)
+	if _, _, err := verifySignedRequest(forged); !errors.Is(err, errSignatureInvalid) {
+		t.Fatalf("伪造签名期望 %v，得到 %v", errSignatureInvalid, err)
+	}
+	if _, _, err := verifySignedRequest(signedContext(apiKey.ID, apiKey.IssuedSigningSecret, nonce, now, nil)); err != nil {
+		t.Fatalf("首次请求期望通过，得到 %v", err)
+	}
+	if _, _, err := verifySignedRequest(signedContext(apiKey.ID, apiKey.IssuedSigningSecret, nonce, now, nil)); !errors.Is(err, errSignatureReplay) {
+		t.Fatalf("重放请求期望 %v，得到 %v", errSignatureReplay, err)
+	}
+}
+
+func TestVerifySignedRequestRotation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t, setSigningPepper)
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "rotate", MaxUsage: 100, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	rotated, err := db.RotateAPIKey(apiKey.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("轮换API密钥失败: %v", err)
+	}
+	if rotated.IssuedSigningSecret == "" || rotated.IssuedSigningSecret == apiKey.IssuedSigningSecret {
+		t.Fatalf("轮换后期望返回新的签名密钥，得到 %q", rotated.IssuedSigningSecret)
+	}
+
+	// 宽限期内旧签名密钥仍然有效，返回旧密钥的摘要由 AcceptsHash 判断
+	now := time.Now()
+	cases := []struct {
+		name, secret, keyHash string
+	}{
+		{"新签名密钥", rotated.IssuedSigningSecret, rotated.KeyHash},
+		{"宽限期内的旧签名密钥", apiKey.IssuedSigningSecret, apiKey.KeyHash},
+	}
+	for _, tc := range cases {
+		_, keyHash, err := verifySignedRequest(signedContext(apiKey.ID, tc.secret, t.Name()+tc.name, now, nil))
+		if err != nil || keyHash != tc.keyHash {
+			t.Errorf("%s: 期望通过并返回 %s，得到 %s %v", tc.name, tc.keyHash, keyHash, err)
+		}
+	}
+}
+
+func TestVerifySignedRequestPepper(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t, setSigningPepper)
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "pepper", MaxUsage: 100, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+
+	// 数据库中只有密钥摘要，按旧方式使用摘要签名不被接受
+	sum := sha256.Sum256([]byte(apiKey.Key))
+	c := signedContext(apiKey.ID, hex.EncodeToString(sum[:]), t.Name()+"-hash", time.Now(), nil)
+	if _, _, err := verifySignedRequest(c); !errors.Is(err, errSignatureInvalid) {
+		t.Fatalf("使用密钥摘要签名期望 %v，得到 %v", errSignatureInvalid, err)
+	}
+
+	// 签名密钥每次验证时重新派生，缓存清空（如服务重启）后仍然有效
+	apiKeyCacheInstance.Flush()
+	if _, _, err := verifySignedRequest(signedContext(apiKey.ID, apiKey.IssuedSigningSecret, t.Name()+"-flush", time.Now(), nil)); err != nil {
+		t.Fatalf("清空缓存后期望签名有效，得到 %v", err)
+	}
+
+	// 修改服务器密钥后已签发的签名密钥失效
+	config.GetInstance().GetConfig().APIKey.SigningPepper = "other-pepper"
+	if _, _, err := verifySignedRequest(signedContext(apiKey.ID, apiKey.IssuedSigningSecret, t.Name()+"-changed", time.Now(), nil)); !errors.Is(err, errSignatureInvalid) {
+		t.Fatalf("修改服务器密钥后期望 %v，得到 %v", errSignatureInvalid, err)
+	}
+
+	// 未配置服务器密钥时签名请求一律拒绝，也不签发签名密钥
+	config.GetInstance().GetConfig().APIKey.SigningPepper = ""
+	if _, _, err := verifySignedRequest(signedContext(apiKey.ID, apiKey.IssuedSigningSecret, t.Name()+"-unset", time.Now(), nil)); !errors.Is(err, errSignatureDisabled) {
+		t.Fatalf("未配置服务器密钥期望 %v，得到 %v", errSignatureDisabled, err)
+	}
+	unsigned, err := db.CreateAPIKey(&models.APIKey{Name: "unsigned", MaxUsage: 100, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	if unsigned.IssuedSigningSecret != "" {
+		t.Fatalf("未配置服务器密钥时不应返回签名密钥，得到 %q", unsigned.IssuedSigningSecret)
+	}
+}
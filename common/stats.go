@@ -39,6 +39,7 @@ func InitStats() {
 			MethodCalls:     make(map[string]int64),
 			PathCalls:       make(map[string]int64),
 			IPCalls:         make(map[string]int64),
+			PrincipalCalls:  make(map[string]int64),
 			LastResetTime:   time.Now(),
 			LastCallDetails: make([]*models.CallDetail, 0, 100), // 保留最近100条记录
 		}
@@ -61,7 +62,8 @@ func InitStats() {
 }
 
 // RecordCall 记录API调用
-func (s *Stats) RecordCall(path, method, ip string, statusCode int) {
+// principal 为调用方（api_key:<ID> 或 anonymous），未通过认证的请求为空，不计入调用方统计
+func (s *Stats) RecordCall(path, method, ip, principal string, statusCode int) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -77,6 +79,11 @@ func (s *Stats) RecordCall(path, method, ip string, statusCode int) {
 	// 增加IP调用次数
 	s.IPCalls[ip]++
 
+	// 增加调用方调用次数
+	if principal != "" {
+		s.PrincipalCalls[principal]++
+	}
+
 	// 记录当前时间
 	now := time.Now()
 
@@ -95,6 +102,7 @@ func (s *Stats) RecordCall(path, method, ip string, statusCode int) {
 		IP:         ip,
 		Timestamp:  now,
 		StatusCode: statusCode,
+		Principal:  principal,
 	}
 
 	// 保持最多100条记录
@@ -202,6 +210,7 @@ func (s *Stats) GetStats() *models.Stats {
 		MethodCalls:     make(map[string]int64),
 		PathCalls:       make(map[string]int64),
 		IPCalls:         make(map[string]int64),
+		PrincipalCalls:  make(map[string]int64),
 		LastResetTime:   s.LastResetTime,
 		LastCallDetails: make([]*models.CallDetail, len(s.LastCallDetails)),
 	}
@@ -216,6 +225,9 @@ func (s *Stats) GetStats() *models.Stats {
 	for k, v := range s.IPCalls {
 		copy.IPCalls[k] = v
 	}
+	for k, v := range s.PrincipalCalls {
+		copy.PrincipalCalls[k] = v
+	}
 
 	// 复制调用详情
 	for i, detail := range s.LastCallDetails {
@@ -225,6 +237,7 @@ func (s *Stats) GetStats() *models.Stats {
 			IP:         detail.IP,
 			Timestamp:  detail.Timestamp,
 			StatusCode: detail.StatusCode,
+			Principal:  detail.Principal,
 		}
 	}
 
@@ -42,3 +42,8 @@ func StatsAPIHandler(c *gin.Context) {
 func APIKeyHandler(c *gin.Context) {
 	c.HTML(http.StatusOK, "api_key.html", gin.H{})
 }
+
+// AuditPageHandler 处理审计日志页面
+func AuditPageHandler(c *gin.Context) {
+	c.HTML(http.StatusOK, "audit.html", gin.H{})
+}
@@ -0,0 +1,254 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// accessTokenIssuer 访问令牌的签发者
+const accessTokenIssuer = "xrcuo-api"
+
+// errAccessTokenInvalid 访问令牌格式、签名或有效期无效
+var errAccessTokenInvalid = errors.New("访问令牌无效或已过期")
+
+// accessTokenHeader JWT头部
+type accessTokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+}
+
+// accessTokenClaims 访问令牌携带的声明
+type accessTokenClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`             // 签发令牌的API密钥ID
+	Scopes    []string `json:"scopes"`          // 签发时密钥的插件权限范围，为空表示不限制
+	Paths     []string `json:"paths,omitempty"` // 签发时密钥允许访问的路径模式，为空表示不限制
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+	ID        string   `json:"jti"`
+}
+
+// tokenKeys 访问令牌签名密钥，未配置时使用进程级随机密钥
+var tokenKeys struct {
+	mutex          sync.Mutex
+	fallbackSecret []byte             // 未配置 token.secret 时使用的随机HS256密钥
+	ed25519Source  string             // 已解析的 token.ed25519_key 配置值
+	ed25519Key     ed25519.PrivateKey // 当前使用的EdDSA私钥
+}
+
+// tokenHMACSecret 获取HS256签名密钥
+func tokenHMACSecret() []byte {
+	if secret := config.GetTokenSecret(); secret != "" {
+		return []byte(secret)
+	}
+
+	tokenKeys.mutex.Lock()
+	defer tokenKeys.mutex.Unlock()
+	if tokenKeys.fallbackSecret == nil {
+		tokenKeys.fallbackSecret = make([]byte, 32)
+		if _, err := rand.Read(tokenKeys.fallbackSecret); err != nil {
+			logrus.Fatalf("生成访问令牌签名密钥失败: %v", err)
+		}
+		logrus.Warn("未配置 token.secret，已使用随机密钥，服务重启后已签发的访问令牌将失效")
+	}
+	return tokenKeys.fallbackSecret
+}
+
+// tokenEd25519Key 获取EdDSA签名私钥，配置变化后重新解析
+func tokenEd25519Key() (ed25519.PrivateKey, error) {
+	source := config.GetTokenEd25519Key()
+
+	tokenKeys.mutex.Lock()
+	defer tokenKeys.mutex.Unlock()
+	if tokenKeys.ed25519Key != nil && tokenKeys.ed25519Source == source {
+		return tokenKeys.ed25519Key, nil
+	}
+
+	if source == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("生成访问令牌签名私钥失败: %v", err)
+		}
+		logrus.Warn("未配置 token.ed25519_key，已使用随机私钥，服务重启后已签发的访问令牌将失效")
+		tokenKeys.ed25519Source, tokenKeys.ed25519Key = source, key
+		return key, nil
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(source)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("token.ed25519_key 应为base64编码的%d字节种子", ed25519.SeedSize)
+	}
+	tokenKeys.ed25519Source, tokenKeys.ed25519Key = source, ed25519.NewKeyFromSeed(seed)
+	return tokenKeys.ed25519Key, nil
+}
+
+// signAccessToken 按指定算法对 header.payload 签名
+func signAccessToken(algorithm, signingInput string) ([]byte, error) {
+	switch algorithm {
+	case "HS256":
+		mac := hmac.New(sha256.New, tokenHMACSecret())
+		mac.Write([]byte(signingInput))
+		return mac.Sum(nil), nil
+	case "EdDSA":
+		key, err := tokenEd25519Key()
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.Sign(key, []byte(signingInput)), nil
+	default:
+		return nil, fmt.Errorf("不支持的访问令牌签名算法: %s", algorithm)
+	}
+}
+
+// issueAccessToken 为API密钥签发访问令牌
+// 令牌有效期不超过密钥自身的过期时间
+func issueAccessToken(apiKey *models.APIKey, now time.Time) (string, time.Time, error) {
+	expiresAt := now.Add(config.GetTokenTTL())
+	if expiry, ok := apiKey.EffectiveExpiry(); ok && expiry.Before(expiresAt) {
+		expiresAt = expiry
+	}
+
+	jti, err := randomHex(16)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("生成令牌ID失败: %v", err)
+	}
+
+	scopes := apiKey.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	algorithm := config.GetTokenAlgorithm()
+	header, err := json.Marshal(accessTokenHeader{Algorithm: algorithm, Type: "JWT"})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	payload, err := json.Marshal(accessTokenClaims{
+		Issuer:    accessTokenIssuer,
+		Subject:   strconv.FormatInt(apiKey.ID, 10),
+		Scopes:    scopes,
+		Paths:     apiKey.AllowedPaths,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+		ID:        jti,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := signAccessToken(algorithm, signingInput)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), expiresAt, nil
+}
+
+// isAccessToken 判断请求中携带的凭据是否为访问令牌（JWT），API密钥为十六进制字符串，不包含点号
+func isAccessToken(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+// parseAccessToken 验证访问令牌的签名和有效期，返回令牌声明
+// 只接受当前配置的签名算法，避免算法混淆攻击
+func parseAccessToken(token string, now time.Time) (*accessTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errAccessTokenInvalid
+	}
+
+	var header accessTokenHeader
+	if data, err := base64.RawURLEncoding.DecodeString(parts[0]); err != nil || json.Unmarshal(data, &header) != nil {
+		return nil, errAccessTokenInvalid
+	}
+	algorithm := config.GetTokenAlgorithm()
+	if header.Algorithm != algorithm {
+		return nil, errAccessTokenInvalid
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errAccessTokenInvalid
+	}
+	signingInput := parts[0] + "." + parts[1]
+	switch algorithm {
+	case "HS256":
+		expected, err := signAccessToken(algorithm, signingInput)
+		if err != nil || !hmac.Equal(signature, expected) {
+			return nil, errAccessTokenInvalid
+		}
+	case "EdDSA":
+		key, err := tokenEd25519Key()
+		if err != nil || !ed25519.Verify(key.Public().(ed25519.PublicKey), []byte(signingInput), signature) {
+			return nil, errAccessTokenInvalid
+		}
+	default:
+		return nil, errAccessTokenInvalid
+	}
+
+	var claims accessTokenClaims
+	if data, err := base64.RawURLEncoding.DecodeString(parts[1]); err != nil || json.Unmarshal(data, &claims) != nil {
+		return nil, errAccessTokenInvalid
+	}
+	if claims.Issuer != accessTokenIssuer || now.Unix() >= claims.ExpiresAt {
+		return nil, errAccessTokenInvalid
+	}
+	return &claims, nil
+}
+
+// missingScope 检查令牌声明的权限范围是否包含当前插件和路径，返回缺少的权限，满足时返回空字符串
+func (t *accessTokenClaims) missingScope(plugin, requestPath string) string {
+	granted := models.APIKey{Scopes: t.Scopes, AllowedPaths: t.Paths}
+	return granted.MissingScope(plugin, requestPath)
+}
+
+// keyID 获取签发令牌的API密钥ID
+func (t *accessTokenClaims) keyID() (int64, error) {
+	return strconv.ParseInt(t.Subject, 10, 64)
+}
+
+// AccessTokenHandler 使用API密钥换取短期访问令牌
+// 密钥的传递方式与调用接口时相同（请求头、签名请求或查询参数），换取令牌不计入使用次数；
+// 不能使用访问令牌换取新的令牌
+func AccessTokenHandler(c *gin.Context) {
+	cred := resolveCredential(c)
+	if cred.err == nil && cred.token != nil {
+		ErrorResponse(c, http.StatusUnauthorized, CodeUnauthorized, "请使用API密钥换取访问令牌")
+		return
+	}
+
+	now := time.Now()
+	keyInfo, ok := checkCredential(c, cred, now)
+	if !ok {
+		return
+	}
+
+	token, expiresAt, err := issueAccessToken(keyInfo, now)
+	if err != nil {
+		logrus.Errorf("签发访问令牌失败: %v", err)
+		ErrorResponse(c, http.StatusInternalServerError, CodeInternalServerError, "签发访问令牌失败")
+		return
+	}
+
+	SuccessResponse(c, gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int64(expiresAt.Sub(now).Seconds()),
+		"expires_at":   expiresAt,
+	}, "访问令牌签发成功")
+}
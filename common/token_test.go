@@ -0,0 +1,146 @@
+package common
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// setTokenConfig 切换访问令牌的签名配置
+func setTokenConfig(algorithm, secret string) {
+	cfg := config.GetInstance().GetConfig()
+	cfg.Token.Algorithm = algorithm
+	cfg.Token.Secret = secret
+}
+
+func TestParseAccessToken(t *testing.T) {
+	setupTestDB(t, nil)
+	apiKey := &models.APIKey{ID: 42, Scopes: []string{"ip"}, AllowedPaths: []string{"/api/ip/*"}}
+	now := time.Now()
+
+	for _, algorithm := range []string{"HS256", "EdDSA"} {
+		setTokenConfig(algorithm, "test-token-secret")
+		token, expiresAt, err := issueAccessToken(apiKey, now)
+		if err != nil {
+			t.Fatalf("%s: 签发令牌失败: %v", algorithm, err)
+		}
+
+		claims, err := parseAccessToken(token, now)
+		if err != nil {
+			t.Fatalf("%s: 期望令牌有效，得到 %v", algorithm, err)
+		}
+		if id, _ := claims.keyID(); id != apiKey.ID || claims.Scopes[0] != "ip" || claims.Paths[0] != "/api/ip/*" {
+			t.Fatalf("%s: 令牌声明不一致: %+v", algorithm, claims)
+		}
+
+		// 替换声明但保留原签名
+		parts := strings.Split(token, ".")
+		forgedPayload := base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"xrcuo-api","sub":"1","scopes":[],"exp":` + "9999999999" + `}`))
+		noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+		invalid := []struct {
+			name  string
+			token string
+			now   time.Time
+		}{
+			{"已过期", token, expiresAt},
+			{"篡改声明", parts[0] + "." + forgedPayload + "." + parts[2], now},
+			{"篡改签名", parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString([]byte("forged")), now},
+			{"不签名的算法", noneHeader + "." + parts[1] + ".", now},
+			{"格式错误", parts[0] + "." + parts[1], now},
+		}
+		for _, tc := range invalid {
+			if _, err := parseAccessToken(tc.token, tc.now); err != errAccessTokenInvalid {
+				t.Errorf("%s %s: 期望 %v，得到 %v", algorithm, tc.name, errAccessTokenInvalid, err)
+			}
+		}
+	}
+
+	// 只接受当前配置的算法，切换算法后之前签发的令牌失效
+	setTokenConfig("HS256", "test-token-secret")
+	token, _, err := issueAccessToken(apiKey, now)
+	if err != nil {
+		t.Fatalf("签发令牌失败: %v", err)
+	}
+	setTokenConfig("EdDSA", "")
+	if _, err := parseAccessToken(token, now); err != errAccessTokenInvalid {
+		t.Fatalf("算法不一致期望 %v，得到 %v", errAccessTokenInvalid, err)
+	}
+
+	// 签名密钥不同的令牌无效
+	setTokenConfig("HS256", "other-secret")
+	if _, err := parseAccessToken(token, now); err != errAccessTokenInvalid {
+		t.Fatalf("签名密钥不一致期望 %v，得到 %v", errAccessTokenInvalid, err)
+	}
+}
+
+func TestAccessTokenScopeIntersection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.Token.Secret = "test-token-secret"
+	})
+	apiKey, err := db.CreateAPIKey(&models.APIKey{
+		Name: "token", MaxUsage: 100, Enabled: true,
+		Scopes: []string{"ip", "ipify"}, AllowedPaths: []string{"/api/ip", "/api/ipify"},
+	})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	token, _, err := issueAccessToken(apiKey, time.Now())
+	if err != nil {
+		t.Fatalf("签发令牌失败: %v", err)
+	}
+
+	r := gin.New()
+	for _, plugin := range []string{"ip", "ipify", "ping"} {
+		pluginName := plugin
+		r.GET("/api/"+pluginName, func(c *gin.Context) {
+			c.Set(PluginContextKey, pluginName)
+		}, APIKeyMiddleware(), func(c *gin.Context) {
+			c.String(http.StatusOK, "ok")
+		})
+	}
+	request := func(path, credential string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+credential)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := request("/api/ip", token); code != http.StatusOK {
+		t.Fatalf("签发时的权限范围内期望 200，得到 %d", code)
+	}
+
+	// 收窄密钥的插件权限后，已签发的令牌立即受限
+	scopes, paths := []string{"ipify", "ping"}, []string{}
+	if _, err := db.UpdateAPIKey(apiKey.ID, &models.APIKeyUpdate{Scopes: &scopes, AllowedPaths: &paths}); err != nil {
+		t.Fatalf("修改API密钥失败: %v", err)
+	}
+	InvalidateAPIKey(apiKey)
+
+	cases := []struct {
+		path       string
+		credential string
+		code       int
+	}{
+		{"/api/ip", token, http.StatusForbidden},      // 密钥当前不允许
+		{"/api/ipify", token, http.StatusOK},          // 令牌和密钥都允许
+		{"/api/ping", token, http.StatusForbidden},    // 放宽的权限不扩大已签发的令牌
+		{"/api/ping", apiKey.Key, http.StatusOK},      // 密钥本身使用新的权限范围
+		{"/api/ip", apiKey.Key, http.StatusForbidden}, // 密钥本身使用新的权限范围
+	}
+	for _, tc := range cases {
+		if code := request(tc.path, tc.credential); code != tc.code {
+			t.Errorf("%s: 期望 %d，得到 %d", tc.path, tc.code, code)
+		}
+	}
+}
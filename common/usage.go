@@ -0,0 +1,355 @@
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// usageCounter 单个API密钥或账户在内存中的使用计数，以计费单位计
+type usageCounter struct {
+	mutex     sync.Mutex
+	used      int64           // 当前周期已用单位（包含尚未写入数据库的部分）
+	pending   int64           // 尚未写入数据库的单位，撤销已写入的使用时可能为负数
+	windowEnd int64           // 当前配额周期的结束时间（Unix秒），终身配额为0
+	lastUsed  time.Time       // 最后一次调用时间
+	carried   []db.UsageDelta // 进入新周期前尚未写入的上一周期增量，下一次写入时先于当前周期写入
+	detached  bool            // 已被 forget 移除，持有该计数器的调用方需要重新获取
+}
+
+// usageAccumulator 使用次数累加器，分别用于API密钥和账户
+// 请求路径上只在内存中计数并检查上限，由后台任务定期将增量批量写入数据库，
+// 避免每个请求都同步写SQLite。同一进程内的配额检查是精确的
+type usageAccumulator struct {
+	counters sync.Map                    // map[int64]*usageCounter，按密钥或账户ID保存
+	store    func([]db.UsageDelta) error // 批量写入增量的函数
+	started  bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// 全局API密钥使用次数累加器
+var globalUsage = newUsageAccumulator(db.FlushAPIKeyUsage)
+
+// 全局账户使用次数累加器，账户下所有密钥的调用合计计数
+var globalAccountUsage = newUsageAccumulator(db.FlushAccountUsage)
+
+// newUsageAccumulator 创建使用次数累加器
+// store: 批量写入增量的函数
+func newUsageAccumulator(store func([]db.UsageDelta) error) *usageAccumulator {
+	return &usageAccumulator{
+		store: store,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// windowEndUnix 将配额周期结束时间转换为Unix秒，终身配额为0
+func windowEndUnix(end time.Time) int64 {
+	if end.IsZero() {
+		return 0
+	}
+	return end.Unix()
+}
+
+// quotaWindowEndUnix 获取密钥在指定时间所在配额周期的结束时间（Unix秒），终身配额为0
+func quotaWindowEndUnix(apiKey *models.APIKey, now time.Time) int64 {
+	return windowEndUnix(apiKey.QuotaWindowEnd(now))
+}
+
+// lockCounter 获取并锁定ID对应的计数器，调用方负责解锁
+// 计数器不存在时调用 initial 以数据库中的使用情况初始化，initial 为nil时返回nil；
+// 获取后计数器已被 forget 移除时重新获取，保证记录的使用不会留在已移除的计数器中
+func (a *usageAccumulator) lockCounter(id int64, initial func() *usageCounter) *usageCounter {
+	for {
+		val, ok := a.counters.Load(id)
+		if !ok {
+			if initial == nil {
+				return nil
+			}
+			val, _ = a.counters.LoadOrStore(id, initial())
+		}
+		counter := val.(*usageCounter)
+		counter.mutex.Lock()
+		if !counter.detached {
+			return counter
+		}
+		counter.mutex.Unlock()
+	}
+}
+
+// take 在配额周期 windowEnd 内记录一次使用，扣除 cost 个计费单位，limit < 0 表示不限次数，调用方需持有 counter.mutex
+// 剩余单位不足时返回 false；成功时返回记录后的已用单位
+func (a *usageAccumulator) take(counter *usageCounter, cost, limit, windowEnd int64, now time.Time) (int64, bool) {
+	// 进入新的配额周期，上一周期的次数不再影响配额，尚未写入的增量保留到下一次写入
+	if windowEnd != counter.windowEnd {
+		if counter.pending != 0 {
+			counter.carried = append(counter.carried, counter.delta())
+		}
+		counter.used = 0
+		counter.pending = 0
+		counter.windowEnd = windowEnd
+	}
+
+	if limit >= 0 && counter.used+cost > limit {
+		return counter.used, false
+	}
+
+	counter.used += cost
+	counter.pending += cost
+	counter.lastUsed = now
+	return counter.used, true
+}
+
+// delta 当前周期尚未写入的增量，调用方需持有 counter.mutex
+func (counter *usageCounter) delta() db.UsageDelta {
+	return db.UsageDelta{
+		Count:      counter.pending,
+		WindowEnd:  counter.windowEnd,
+		LastUsedAt: counter.lastUsed,
+	}
+}
+
+// release 撤销一次已记录但最终未完成的使用（如账户配额已扣除，但密钥配额不足），cost 为记录时扣除的单位
+// 记录的使用已经写入数据库时，撤销的单位作为负增量在下一次写入时抵消
+func (a *usageAccumulator) release(id, cost, windowEnd int64) {
+	counter := a.lockCounter(id, nil)
+	if counter == nil {
+		// 计数器已被移除，记录的使用已随移除写入数据库，直接写入负增量
+		if err := a.store([]db.UsageDelta{{ID: id, Count: -cost, WindowEnd: windowEnd}}); err != nil {
+			logrus.Errorf("撤销使用次数失败: %v", err)
+		}
+		return
+	}
+	defer counter.mutex.Unlock()
+	if counter.windowEnd == windowEnd {
+		counter.used -= cost
+		counter.pending -= cost
+	}
+}
+
+// keyCounter 获取并锁定API密钥的计数器，调用方负责解锁
+func (a *usageAccumulator) keyCounter(apiKey *models.APIKey, now time.Time) *usageCounter {
+	return a.lockCounter(apiKey.ID, func() *usageCounter {
+		counter := &usageCounter{
+			used:      apiKey.EffectiveUsage(now),
+			windowEnd: quotaWindowEndUnix(apiKey, now),
+		}
+		if apiKey.LastUsedAt != nil {
+			counter.lastUsed = *apiKey.LastUsedAt
+		}
+		return counter
+	})
+}
+
+// apply 将内存中的最新使用情况写入密钥信息
+// apiKey 应为当前请求的副本，不能是缓存中共享的实例
+func (a *usageAccumulator) apply(apiKey *models.APIKey) {
+	val, ok := a.counters.Load(apiKey.ID)
+	if !ok {
+		return
+	}
+	counter := val.(*usageCounter)
+
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	apiKey.CurrentUsage = counter.used
+	if counter.windowEnd > 0 {
+		resetAt := time.Unix(counter.windowEnd, 0)
+		apiKey.QuotaResetAt = &resetAt
+	}
+	if !counter.lastUsed.IsZero() {
+		lastUsed := counter.lastUsed
+		apiKey.LastUsedAt = &lastUsed
+	}
+}
+
+// consume 为密钥记录一次使用并扣除 cost 个计费单位，剩余单位不足时返回 db.ErrAPIKeyQuotaExceeded
+// 成功后将最新的使用次数、周期结束时间和最后使用时间写入 apiKey（应为当前请求的副本）
+func (a *usageAccumulator) consume(apiKey *models.APIKey, cost int64, now time.Time) error {
+	windowEnd := quotaWindowEndUnix(apiKey, now)
+	limit := apiKey.MaxUsage
+	if apiKey.IsPermanent {
+		limit = -1
+	}
+
+	counter := a.keyCounter(apiKey, now)
+	used, ok := a.take(counter, cost, limit, windowEnd, now)
+	counter.mutex.Unlock()
+	if !ok {
+		return db.ErrAPIKeyQuotaExceeded
+	}
+
+	apiKey.CurrentUsage = used
+	if windowEnd > 0 {
+		resetAt := time.Unix(windowEnd, 0)
+		apiKey.QuotaResetAt = &resetAt
+	}
+	apiKey.LastUsedAt = &now
+	return nil
+}
+
+// consumeAccount 为账户记录一次使用并扣除 cost 个计费单位，剩余单位不足时返回 db.ErrAccountQuotaExceeded
+// 成功后将最新的合计使用次数和周期结束时间写入 account（应为当前请求的副本）
+func (a *usageAccumulator) consumeAccount(account *models.Account, cost int64, now time.Time) error {
+	windowEnd := windowEndUnix(account.QuotaWindowEnd(now))
+	limit := account.MaxUsage
+	if limit <= 0 {
+		limit = -1
+	}
+
+	counter := a.lockCounter(account.ID, func() *usageCounter {
+		return &usageCounter{used: account.EffectiveUsage(now), windowEnd: windowEnd}
+	})
+	used, ok := a.take(counter, cost, limit, windowEnd, now)
+	counter.mutex.Unlock()
+	if !ok {
+		return db.ErrAccountQuotaExceeded
+	}
+
+	account.CurrentUsage = used
+	if windowEnd > 0 {
+		resetAt := time.Unix(windowEnd, 0)
+		account.QuotaResetAt = &resetAt
+	}
+	return nil
+}
+
+// flush 将所有计数器的使用增量在一个事务中写入数据库，上一周期的增量先于当前周期写入
+// 写入失败时增量放回计数器，等待下一次写入
+func (a *usageAccumulator) flush() error {
+	var deltas []db.UsageDelta
+	a.counters.Range(func(key, val interface{}) bool {
+		counter := val.(*usageCounter)
+		counter.mutex.Lock()
+		deltas = append(deltas, counter.detach(key.(int64))...)
+		counter.mutex.Unlock()
+		return true
+	})
+
+	if err := a.store(deltas); err != nil {
+		// 倒序放回，上一周期的增量插入到 carried 开头后仍保持原来的先后顺序
+		for i := len(deltas) - 1; i >= 0; i-- {
+			d := deltas[i]
+			counter := a.lockCounter(d.ID, nil)
+			if counter == nil {
+				logrus.Errorf("ID为 %d 的 %d 个单位未能写入数据库，计数器已被移除", d.ID, d.Count)
+				continue
+			}
+			if counter.windowEnd == d.WindowEnd {
+				counter.pending += d.Count
+			} else {
+				counter.carried = append([]db.UsageDelta{d}, counter.carried...)
+			}
+			counter.mutex.Unlock()
+		}
+		return err
+	}
+
+	return nil
+}
+
+// detach 取出计数器中所有尚未写入的增量，调用方需持有 counter.mutex
+func (counter *usageCounter) detach(id int64) []db.UsageDelta {
+	deltas := counter.carried
+	counter.carried = nil
+	if counter.pending != 0 {
+		deltas = append(deltas, counter.delta())
+		counter.pending = 0
+	}
+	for i := range deltas {
+		deltas[i].ID = id
+	}
+	return deltas
+}
+
+// forget 移除计数器并写入其尚未保存的增量，下一次请求时重新从数据库加载
+// 已获取该计数器的并发请求会发现计数器已移除并重新获取，不会把使用记录到不再写入的计数器中
+func (a *usageAccumulator) forget(id int64) {
+	val, ok := a.counters.Load(id)
+	if !ok {
+		return
+	}
+	counter := val.(*usageCounter)
+
+	counter.mutex.Lock()
+	counter.detached = true
+	a.counters.CompareAndDelete(id, counter)
+	deltas := counter.detach(id)
+	counter.mutex.Unlock()
+
+	if len(deltas) > 0 {
+		if err := a.store(deltas); err != nil {
+			logrus.Errorf("写入使用次数失败: %v", err)
+		}
+	}
+}
+
+// run 定期将使用增量写入数据库，每次等待前重新读取写入间隔以支持配置热重载
+func (a *usageAccumulator) run() {
+	defer close(a.done)
+
+	for {
+		timer := time.NewTimer(config.GetAPIKeyUsageFlushInterval())
+		select {
+		case <-timer.C:
+			if err := a.flush(); err != nil {
+				logrus.Errorf("批量写入使用次数失败: %v", err)
+			}
+		case <-a.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// start 启动定期写入任务
+func (a *usageAccumulator) start() {
+	a.started = true
+	go a.run()
+}
+
+// shutdown 停止定期写入任务，并立即写入所有尚未保存的使用次数
+func (a *usageAccumulator) shutdown() error {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+		if a.started {
+			<-a.done
+		}
+	})
+	return a.flush()
+}
+
+// StartUsageFlusher 启动API密钥和账户使用次数的定期写入任务
+func StartUsageFlusher() {
+	globalUsage.start()
+	globalAccountUsage.start()
+	logrus.Debug("API密钥使用次数写入任务已启动")
+}
+
+// StopUsageFlusher 停止定期写入任务，并立即写入所有尚未保存的使用次数
+// 需要在关闭数据库连接之前调用
+func StopUsageFlusher() {
+	if err := globalUsage.shutdown(); err != nil {
+		logrus.Errorf("写入API密钥使用次数失败: %v", err)
+		return
+	}
+	if err := globalAccountUsage.shutdown(); err != nil {
+		logrus.Errorf("写入账户使用次数失败: %v", err)
+		return
+	}
+	logrus.Info("API密钥使用次数已写入数据库")
+}
+
+// FlushAPIKeyUsage 立即写入所有尚未保存的使用次数（包括账户合计），用于管理接口展示最新数据
+func FlushAPIKeyUsage() error {
+	if err := globalUsage.flush(); err != nil {
+		return err
+	}
+	return globalAccountUsage.flush()
+}
@@ -0,0 +1,308 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// setupUsageBenchDB 初始化临时数据库并创建一个不会用完配额的密钥
+func setupUsageBenchDB(b *testing.B) *models.APIKey {
+	b.Helper()
+	setupTestDB(b, func(cfg *config.Config) {
+		cfg.APIKey.UsageFlushInterval = 100 * time.Millisecond
+	})
+
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "bench", MaxUsage: 1 << 62})
+	if err != nil {
+		b.Fatalf("创建API密钥失败: %v", err)
+	}
+	return apiKey
+}
+
+// BenchmarkFlushAPIKeyUsage 每个请求同步写入一次数据库（批量写入之前的做法）
+func BenchmarkFlushAPIKeyUsage(b *testing.B) {
+	apiKey := setupUsageBenchDB(b)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := db.FlushAPIKeyUsage([]db.UsageDelta{{ID: apiKey.ID, Count: 1, LastUsedAt: time.Now()}}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkUsageAccumulator 内存计数，后台定期批量写入
+func BenchmarkUsageAccumulator(b *testing.B) {
+	apiKey := setupUsageBenchDB(b)
+
+	acc := newUsageAccumulator(db.FlushAPIKeyUsage)
+	acc.started = true
+	go acc.run()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			snapshot := *apiKey
+			if err := acc.consume(&snapshot, 1, time.Now()); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+
+	close(acc.stop)
+	<-acc.done
+	if err := acc.flush(); err != nil {
+		b.Fatalf("写入使用次数失败: %v", err)
+	}
+
+	// 确认所有调用都已写入数据库
+	stored, err := db.GetAPIKeyByID(apiKey.ID)
+	if err != nil {
+		b.Fatalf("查询API密钥失败: %v", err)
+	}
+	if stored.CurrentUsage != int64(b.N) {
+		b.Fatalf("使用次数不一致: 期望 %d, 实际 %d", b.N, stored.CurrentUsage)
+	}
+}
+
+// recordingStore 记录写入的增量，fail 不为nil时写入失败
+type recordingStore struct {
+	deltas []db.UsageDelta
+	fail   error
+}
+
+func (s *recordingStore) store(deltas []db.UsageDelta) error {
+	if s.fail != nil {
+		return s.fail
+	}
+	s.deltas = append(s.deltas, deltas...)
+	return nil
+}
+
+// total 返回写入的指定周期的增量合计
+func (s *recordingStore) total(windowEnd int64) int64 {
+	var total int64
+	for _, d := range s.deltas {
+		if d.WindowEnd == windowEnd {
+			total += d.Count
+		}
+	}
+	return total
+}
+
+func TestUsageAccumulatorQuotaBoundary(t *testing.T) {
+	store := &recordingStore{}
+	acc := newUsageAccumulator(store.store)
+	now := time.Now()
+	apiKey := &models.APIKey{ID: 1, MaxUsage: 10, CurrentUsage: 4}
+
+	// 已用4次，剩余6个单位：扣除5后剩1，再扣2被拒绝，扣1恰好用完
+	steps := []struct {
+		cost int64
+		ok   bool
+		used int64
+	}{
+		{5, true, 9},
+		{2, false, 9},
+		{1, true, 10},
+		{1, false, 10},
+	}
+	for i, step := range steps {
+		snapshot := *apiKey
+		err := acc.consume(&snapshot, step.cost, now)
+		if (err == nil) != step.ok {
+			t.Fatalf("第 %d 步扣除 %d: 期望通过=%v，得到 %v", i+1, step.cost, step.ok, err)
+		}
+		if err == nil && snapshot.CurrentUsage != step.used {
+			t.Fatalf("第 %d 步: 期望已用 %d，得到 %d", i+1, step.used, snapshot.CurrentUsage)
+		}
+	}
+
+	if err := acc.flush(); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	if total := store.total(0); total != 6 {
+		t.Fatalf("期望写入6个单位，得到 %d", total)
+	}
+}
+
+func TestUsageAccumulatorWindowChangeKeepsPending(t *testing.T) {
+	store := &recordingStore{}
+	acc := newUsageAccumulator(store.store)
+	anchor := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	apiKey := &models.APIKey{ID: 1, MaxUsage: 3, QuotaPeriod: models.QuotaPeriodDaily, QuotaAnchor: &anchor}
+
+	// 第一天用完配额，尚未写入数据库时进入第二天
+	day1 := anchor.Add(23 * time.Hour)
+	for i := 0; i < 3; i++ {
+		snapshot := *apiKey
+		if err := acc.consume(&snapshot, 1, day1); err != nil {
+			t.Fatalf("第一天第 %d 次调用失败: %v", i+1, err)
+		}
+	}
+	day2 := anchor.Add(25 * time.Hour)
+	snapshot := *apiKey
+	if err := acc.consume(&snapshot, 1, day2); err != nil {
+		t.Fatalf("进入新周期后期望重新计数，得到 %v", err)
+	}
+
+	if err := acc.flush(); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	end1, end2 := anchor.AddDate(0, 0, 1).Unix(), anchor.AddDate(0, 0, 2).Unix()
+	if len(store.deltas) != 2 || store.deltas[0].WindowEnd != end1 || store.deltas[1].WindowEnd != end2 {
+		t.Fatalf("期望先写入第一天再写入第二天的增量，得到 %+v", store.deltas)
+	}
+	if store.total(end1) != 3 || store.total(end2) != 1 {
+		t.Fatalf("期望两个周期分别写入3和1，得到 %d 和 %d", store.total(end1), store.total(end2))
+	}
+	if !store.deltas[0].LastUsedAt.Equal(day1) {
+		t.Fatalf("上一周期的增量期望保留最后使用时间 %v，得到 %v", day1, store.deltas[0].LastUsedAt)
+	}
+}
+
+func TestUsageAccumulatorFlushFailureRestoresDeltas(t *testing.T) {
+	store := &recordingStore{fail: errors.New("数据库不可用")}
+	acc := newUsageAccumulator(store.store)
+	now := time.Now()
+	apiKey := &models.APIKey{ID: 1, MaxUsage: 100}
+
+	snapshot := *apiKey
+	acc.consume(&snapshot, 2, now)
+	if err := acc.flush(); err == nil {
+		t.Fatal("期望写入失败")
+	}
+	snapshot = *apiKey
+	acc.consume(&snapshot, 3, now)
+
+	store.fail = nil
+	if err := acc.flush(); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	if total := store.total(0); total != 5 {
+		t.Fatalf("写入失败的增量期望在下一次写入，合计5，得到 %d", total)
+	}
+}
+
+func TestUsageAccumulatorReleaseAfterFlush(t *testing.T) {
+	store := &recordingStore{}
+	acc := newUsageAccumulator(store.store)
+	now := time.Now()
+	account := &models.Account{ID: 7, MaxUsage: 10}
+
+	snapshot := *account
+	if err := acc.consumeAccount(&snapshot, 4, now); err != nil {
+		t.Fatalf("扣除失败: %v", err)
+	}
+	// 扣除后先被写入数据库，之后请求未完成需要撤销
+	if err := acc.flush(); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	acc.release(account.ID, 4, 0)
+	if err := acc.flush(); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	if total := store.total(0); total != 0 {
+		t.Fatalf("撤销后写入的合计期望为0，得到 %d", total)
+	}
+
+	// 撤销的单位重新可用
+	snapshot = *account
+	if err := acc.consumeAccount(&snapshot, 10, now); err != nil {
+		t.Fatalf("撤销后期望可以用满配额，得到 %v", err)
+	}
+
+	// 计数器已被移除时直接写入负增量
+	acc.forget(account.ID)
+	acc.release(account.ID, 10, 0)
+	if total := store.total(0); total != 0 {
+		t.Fatalf("移除计数器后撤销，写入的合计期望为0，得到 %d", total)
+	}
+}
+
+func TestUsageAccumulatorForgetWithConcurrentHolder(t *testing.T) {
+	store := &recordingStore{}
+	acc := newUsageAccumulator(store.store)
+	now := time.Now()
+	apiKey := &models.APIKey{ID: 1, MaxUsage: 100}
+
+	snapshot := *apiKey
+	acc.consume(&snapshot, 1, now)
+
+	// 并发请求已取得计数器后，管理员修改密钥导致计数器被移除
+	val, _ := acc.counters.Load(apiKey.ID)
+	held := val.(*usageCounter)
+	acc.forget(apiKey.ID)
+	if !held.detached {
+		t.Fatal("移除后计数器期望标记为已移除")
+	}
+
+	// 持有旧计数器的请求重新获取到新的计数器，不会记录到已移除的计数器中
+	snapshot = *apiKey
+	snapshot.CurrentUsage = 1
+	if err := acc.consume(&snapshot, 1, now); err != nil {
+		t.Fatalf("移除计数器后调用失败: %v", err)
+	}
+	if held.pending != 0 {
+		t.Fatalf("已移除的计数器不应再记录使用，得到 %d", held.pending)
+	}
+	if err := acc.flush(); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	if total := store.total(0); total != 2 {
+		t.Fatalf("期望两次调用都被写入，得到 %d", total)
+	}
+}
+
+func TestFlushAPIKeyUsage(t *testing.T) {
+	setupTestDB(t, nil)
+	apiKey, err := db.CreateAPIKey(&models.APIKey{Name: "flush", MaxUsage: 100, Enabled: true, QuotaPeriod: models.QuotaPeriodDaily})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	now := time.Now()
+	window1 := now.Add(time.Hour).Unix()
+	window2 := now.Add(25 * time.Hour).Unix()
+
+	steps := []struct {
+		name     string
+		delta    db.UsageDelta
+		expected int64
+	}{
+		{"首次写入", db.UsageDelta{Count: 3, WindowEnd: window1, LastUsedAt: now}, 3},
+		{"同一周期累加", db.UsageDelta{Count: 2, WindowEnd: window1, LastUsedAt: now}, 5},
+		{"撤销", db.UsageDelta{Count: -1, WindowEnd: window1}, 4},
+		{"进入新周期重新计数", db.UsageDelta{Count: 1, WindowEnd: window2, LastUsedAt: now}, 1},
+		{"上一周期的增量不再计入", db.UsageDelta{Count: 5, WindowEnd: window1, LastUsedAt: now}, 1},
+	}
+	for _, step := range steps {
+		step.delta.ID = apiKey.ID
+		if err := db.FlushAPIKeyUsage([]db.UsageDelta{step.delta}); err != nil {
+			t.Fatalf("%s: 写入失败: %v", step.name, err)
+		}
+		stored, err := db.GetAPIKeyByID(apiKey.ID)
+		if err != nil {
+			t.Fatalf("查询API密钥失败: %v", err)
+		}
+		if stored.CurrentUsage != step.expected {
+			t.Fatalf("%s: 期望已用 %d，得到 %d", step.name, step.expected, stored.CurrentUsage)
+		}
+		// 写入使用次数不是对密钥的修改
+		if !stored.UpdatedAt.Equal(apiKey.UpdatedAt) {
+			t.Fatalf("%s: 写入使用次数修改了 updated_at: %v -> %v", step.name, apiKey.UpdatedAt, stored.UpdatedAt)
+		}
+		if stored.LastUsedAt == nil {
+			t.Fatalf("%s: 期望记录最后使用时间", step.name)
+		}
+	}
+}
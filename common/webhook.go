@@ -0,0 +1,408 @@
+package common
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// Webhook事件类型
+const (
+	WebhookEventQuotaThreshold = "api_key.quota_threshold" // 使用量达到使用上限的指定百分比
+	WebhookEventQuotaExceeded  = "api_key.quota_exceeded"  // 配额用尽，请求被拒绝
+	WebhookEventRateLimited    = "api_key.rate_limited"    // 时间窗口内被限流达到指定次数
+	WebhookEventKeyExpired     = "api_key.expired"         // 使用已过期的密钥调用接口
+	WebhookEventKeyCreated     = "api_key.created"         // 创建密钥
+	WebhookEventKeyDeleted     = "api_key.deleted"         // 删除密钥
+	WebhookEventTest           = "webhook.test"            // 管理员发送的测试事件
+)
+
+// Webhook请求头
+const (
+	webhookIDHeader        = "X-Webhook-Id"        // 事件ID，接收方可用于去重
+	webhookEventHeader     = "X-Webhook-Event"     // 事件类型
+	webhookTimestampHeader = "X-Webhook-Timestamp" // 投递时间（Unix秒）
+	webhookSignatureHeader = "X-Webhook-Signature" // sha256=十六进制HMAC-SHA256签名
+)
+
+// webhookBatchSize 每轮最多投递的记录数
+const webhookBatchSize = 20
+
+// webhookPollInterval 检查待重试记录的间隔
+const webhookPollInterval = time.Second
+
+// webhookMaxRetryDelay 两次重试之间的最长等待时间
+const webhookMaxRetryDelay = 24 * time.Hour
+
+// webhookEvent Webhook请求体
+type webhookEvent struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// webhookState 用于事件去重和限流次数统计
+var webhookState = cache.New(time.Hour, 10*time.Minute)
+
+// webhookDispatcher 后台投递任务
+// 事件先写入投递记录表，再由后台任务投递，失败的记录按指数退避重试，进程重启后继续投递
+type webhookDispatcher struct {
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	started  bool
+	stopOnce sync.Once
+}
+
+// 全局Webhook投递任务
+var globalWebhooks = &webhookDispatcher{
+	wake: make(chan struct{}, 1),
+	stop: make(chan struct{}),
+	done: make(chan struct{}),
+}
+
+// webhookSubscribers 获取订阅了指定事件的接收地址
+func webhookSubscribers(event string) []config.WebhookEndpoint {
+	var subscribers []config.WebhookEndpoint
+	for _, endpoint := range config.GetWebhookEndpoints() {
+		if len(endpoint.Events) == 0 || containsEvent(endpoint.Events, event) {
+			subscribers = append(subscribers, endpoint)
+		}
+	}
+	return subscribers
+}
+
+// containsEvent 检查事件列表是否包含指定事件
+func containsEvent(events []string, event string) bool {
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// EmitWebhook 发送Webhook事件，未配置订阅该事件的地址时不做任何处理
+// 投递记录同步写入数据库后返回，由后台任务投递，服务关闭时不会丢失已产生的事件
+func EmitWebhook(event string, data interface{}) {
+	subscribers := webhookSubscribers(event)
+	if len(subscribers) == 0 {
+		return
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		logrus.Errorf("生成Webhook事件ID失败: %v", err)
+		return
+	}
+	payload, err := json.Marshal(webhookEvent{
+		ID:        "evt_" + id,
+		Event:     event,
+		CreatedAt: time.Now(),
+		Data:      data,
+	})
+	if err != nil {
+		logrus.Errorf("序列化Webhook事件失败: %v", err)
+		return
+	}
+
+	deliveries := make([]*models.WebhookDelivery, 0, len(subscribers))
+	for _, endpoint := range subscribers {
+		deliveries = append(deliveries, &models.WebhookDelivery{
+			EventID: "evt_" + id,
+			Event:   event,
+			URL:     endpoint.URL,
+			Payload: string(payload),
+		})
+	}
+
+	if err := db.CreateWebhookDeliveries(deliveries); err != nil {
+		logrus.Errorf("保存Webhook投递记录失败: %v", err)
+		return
+	}
+	WakeWebhookDispatcher()
+}
+
+// EmitAPIKeyWebhook 发送与API密钥相关的Webhook事件，事件数据包含密钥的基本信息（不包含密钥本身）
+// extra: 事件的附加数据，可以为nil
+func EmitAPIKeyWebhook(event string, apiKey *models.APIKey, extra gin.H) {
+	data := gin.H{
+		"api_key": gin.H{
+			"id":         apiKey.ID,
+			"name":       apiKey.Name,
+			"key_prefix": apiKey.KeyPrefix,
+			"account_id": apiKey.AccountID,
+			"plan":       apiKey.Plan,
+		},
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	EmitWebhook(event, data)
+}
+
+// emitWebhookOnce 在 ttl 内对同一个 dedupKey 只发送一次事件
+func emitWebhookOnce(dedupKey string, ttl time.Duration, emit func()) {
+	if len(config.GetWebhookEndpoints()) == 0 {
+		return
+	}
+	if err := webhookState.Add(dedupKey, struct{}{}, ttl); err != nil {
+		return
+	}
+	emit()
+}
+
+// notifyQuotaThresholds 本次调用使使用量跨过配置的百分比阈值时发送通知
+// apiKey 为记录本次使用后的密钥信息，cost 为本次扣除的计费单位；同一配额周期内每个阈值只会跨过一次
+func notifyQuotaThresholds(apiKey *models.APIKey, cost int64) {
+	if apiKey.IsPermanent || apiKey.MaxUsage <= 0 || len(config.GetWebhookEndpoints()) == 0 {
+		return
+	}
+
+	before := apiKey.CurrentUsage - cost
+	for _, threshold := range config.GetWebhookQuotaThresholds() {
+		mark := apiKey.MaxUsage * int64(threshold)
+		if before*100 < mark && apiKey.CurrentUsage*100 >= mark {
+			EmitAPIKeyWebhook(WebhookEventQuotaThreshold, apiKey, gin.H{
+				"threshold":      threshold,
+				"current_usage":  apiKey.CurrentUsage,
+				"max_usage":      apiKey.MaxUsage,
+				"quota_period":   apiKey.QuotaPeriod,
+				"quota_reset_at": apiKey.QuotaResetAt,
+			})
+		}
+	}
+}
+
+// notifyQuotaExceeded 配额用尽拒绝请求时发送通知，同一配额周期内只发送一次，终身配额每天最多发送一次
+func notifyQuotaExceeded(apiKey *models.APIKey, now time.Time) {
+	ttl := 24 * time.Hour
+	windowEnd := apiKey.QuotaWindowEnd(now)
+	if !windowEnd.IsZero() {
+		ttl = windowEnd.Sub(now)
+	}
+
+	dedupKey := fmt.Sprintf("quota_exceeded:%d:%d", apiKey.ID, windowEndUnix(windowEnd))
+	emitWebhookOnce(dedupKey, ttl, func() {
+		EmitAPIKeyWebhook(WebhookEventQuotaExceeded, apiKey, gin.H{
+			"current_usage":  apiKey.EffectiveUsage(now),
+			"max_usage":      apiKey.MaxUsage,
+			"quota_period":   apiKey.QuotaPeriod,
+			"quota_reset_at": apiKey.QuotaResetAt,
+		})
+	})
+}
+
+// notifyKeyExpired 使用已过期的密钥调用接口时发送通知，每个密钥每天最多发送一次
+func notifyKeyExpired(apiKey *models.APIKey) {
+	emitWebhookOnce("expired:"+strconv.FormatInt(apiKey.ID, 10), 24*time.Hour, func() {
+		expiry, _ := apiKey.EffectiveExpiry()
+		EmitAPIKeyWebhook(WebhookEventKeyExpired, apiKey, gin.H{
+			"expired_at": expiry,
+		})
+	})
+}
+
+// notifyRateLimited 记录一次密钥被限流，时间窗口内被限流次数达到配置值时发送通知
+// 时间窗口从第一次被限流开始计算，窗口内只通知一次
+func notifyRateLimited(apiKey *models.APIKey) {
+	if len(config.GetWebhookEndpoints()) == 0 {
+		return
+	}
+
+	alert := config.GetWebhookRateLimitAlert()
+	window := config.GetWebhookRateLimitWindow()
+	counterKey := "rate_limited:" + strconv.FormatInt(apiKey.ID, 10)
+	count := 1
+	if err := webhookState.Add(counterKey, 1, window); err != nil {
+		if count, err = webhookState.IncrementInt(counterKey, 1); err != nil {
+			return
+		}
+	}
+
+	if count == alert {
+		EmitAPIKeyWebhook(WebhookEventRateLimited, apiKey, gin.H{
+			"count":  count,
+			"window": window.String(),
+		})
+	}
+}
+
+// signWebhook 计算Webhook签名，签名内容为 "时间戳.请求体"
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookEndpoint 按URL查找当前配置中的接收地址
+func webhookEndpoint(url string) (config.WebhookEndpoint, bool) {
+	for _, endpoint := range config.GetWebhookEndpoints() {
+		if endpoint.URL == url {
+			return endpoint, true
+		}
+	}
+	return config.WebhookEndpoint{}, false
+}
+
+// webhookRetryDelay 计算第 attempts 次投递失败后的等待时间：backoff * 2^(attempts-1)，最长不超过 webhookMaxRetryDelay
+func webhookRetryDelay(backoff time.Duration, attempts int) time.Duration {
+	delay := backoff
+	for i := 1; i < attempts && delay < webhookMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxRetryDelay {
+		return webhookMaxRetryDelay
+	}
+	return delay
+}
+
+// deliver 投递一条记录并更新投递结果
+// 接收方返回2xx视为成功；失败时按 webhookRetryDelay 安排下一次重试，达到最多投递次数后标记为失败
+func (d *webhookDispatcher) deliver(delivery *models.WebhookDelivery) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.ResponseCode = 0
+	delivery.LastError = ""
+
+	endpoint, ok := webhookEndpoint(delivery.URL)
+	if !ok {
+		// 接收地址已从配置中移除，不再重试
+		delivery.Status = models.WebhookStatusFailed
+		delivery.LastError = "接收地址已从配置中移除"
+		delivery.NextAttemptAt = nil
+		if err := db.UpdateWebhookDeliveryResult(delivery); err != nil {
+			logrus.Errorf("保存Webhook投递结果失败: %v", err)
+		}
+		return
+	}
+
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(payload))
+	if err == nil {
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "xrcuo-api-webhook")
+		req.Header.Set(webhookIDHeader, delivery.EventID)
+		req.Header.Set(webhookEventHeader, delivery.Event)
+		req.Header.Set(webhookTimestampHeader, timestamp)
+		if endpoint.Secret != "" {
+			req.Header.Set(webhookSignatureHeader, signWebhook(endpoint.Secret, timestamp, payload))
+		}
+
+		client := &http.Client{Timeout: config.GetWebhookTimeout()}
+		var resp *http.Response
+		if resp, err = client.Do(req); err == nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			delivery.ResponseCode = resp.StatusCode
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				err = fmt.Errorf("接收方返回HTTP %d", resp.StatusCode)
+			}
+		}
+	}
+
+	switch {
+	case err == nil:
+		delivery.Status = models.WebhookStatusSuccess
+		delivery.NextAttemptAt = nil
+	case delivery.Attempts >= config.GetWebhookMaxAttempts():
+		delivery.Status = models.WebhookStatusFailed
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = nil
+		logrus.Warnf("Webhook投递失败，已达到最多投递次数: %s %s: %v", delivery.Event, delivery.URL, err)
+	default:
+		delivery.Status = models.WebhookStatusPending
+		delivery.LastError = err.Error()
+		next := now.Add(webhookRetryDelay(config.GetWebhookRetryBackoff(), delivery.Attempts))
+		delivery.NextAttemptAt = &next
+		logrus.Debugf("Webhook投递失败，将于 %s 重试: %s %s: %v", next.Format(time.RFC3339), delivery.Event, delivery.URL, err)
+	}
+
+	if err := db.UpdateWebhookDeliveryResult(delivery); err != nil {
+		logrus.Errorf("保存Webhook投递结果失败: %v", err)
+	}
+}
+
+// deliverDue 投递所有已到投递时间的记录，收到停止信号时在当前记录投递完成后返回
+func (d *webhookDispatcher) deliverDue() {
+	for {
+		deliveries, err := db.GetDueWebhookDeliveries(time.Now(), webhookBatchSize)
+		if err != nil {
+			logrus.Errorf("查询待投递的Webhook失败: %v", err)
+			return
+		}
+		for _, delivery := range deliveries {
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+			d.deliver(delivery)
+		}
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// run 定期投递待投递的记录，有新事件时立即投递
+func (d *webhookDispatcher) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		case <-d.stop:
+			return
+		}
+		d.deliverDue()
+	}
+}
+
+// StartWebhookDispatcher 启动Webhook后台投递任务，继续投递上次退出时尚未完成的记录
+func StartWebhookDispatcher() {
+	globalWebhooks.started = true
+	go globalWebhooks.run()
+	logrus.Debug("Webhook投递任务已启动")
+}
+
+// StopWebhookDispatcher 停止Webhook后台投递任务，尚未投递的记录保留在数据库中，下次启动后继续投递
+// 需要在关闭数据库连接之前调用
+func StopWebhookDispatcher() {
+	globalWebhooks.stopOnce.Do(func() {
+		close(globalWebhooks.stop)
+		if globalWebhooks.started {
+			<-globalWebhooks.done
+		}
+	})
+}
+
+// WakeWebhookDispatcher 通知后台任务立即投递，不等待下一次定期检查
+func WakeWebhookDispatcher() {
+	select {
+	case globalWebhooks.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+func TestWebhookRetryDelay(t *testing.T) {
+	cases := []struct {
+		backoff  time.Duration
+		attempts int
+		expected time.Duration
+	}{
+		{30 * time.Second, 1, 30 * time.Second},
+		{30 * time.Second, 2, time.Minute},
+		{30 * time.Second, 4, 4 * time.Minute},
+		// 次数很多时不会溢出，最长等待 webhookMaxRetryDelay
+		{30 * time.Second, 100, webhookMaxRetryDelay},
+		{30 * time.Second, 1 << 20, webhookMaxRetryDelay},
+		{48 * time.Hour, 1, webhookMaxRetryDelay},
+	}
+	for _, tc := range cases {
+		if delay := webhookRetryDelay(tc.backoff, tc.attempts); delay != tc.expected {
+			t.Errorf("backoff=%v attempts=%d: 期望 %v，得到 %v", tc.backoff, tc.attempts, tc.expected, delay)
+		}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	received := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(webhookSignatureHeader) != signWebhook("hook-secret", r.Header.Get(webhookTimestampHeader), body) {
+			t.Errorf("Webhook签名无效")
+		}
+		received <- r
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	setupTestDB(t, func(cfg *config.Config) {
+		cfg.Webhook.Endpoints = []config.WebhookEndpoint{
+			{URL: server.URL, Secret: "hook-secret", Events: []string{WebhookEventKeyCreated}},
+		}
+		cfg.Webhook.MaxAttempts = 2
+		cfg.Webhook.RetryBackoff = time.Minute
+	})
+
+	// 未订阅的事件不产生投递记录，订阅的事件返回前已写入数据库
+	EmitWebhook(WebhookEventKeyDeleted, gin.H{})
+	EmitAPIKeyWebhook(WebhookEventKeyCreated, &models.APIKey{ID: 1, Name: "hook"}, nil)
+	deliveries, err := db.GetDueWebhookDeliveries(time.Now(), webhookBatchSize)
+	if err != nil {
+		t.Fatalf("查询投递记录失败: %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Event != WebhookEventKeyCreated {
+		t.Fatalf("期望一条 %s 投递记录，得到 %+v", WebhookEventKeyCreated, deliveries)
+	}
+	delivery := deliveries[0]
+
+	// 第一次投递失败，按退避时间安排重试
+	before := time.Now()
+	globalWebhooks.deliver(delivery)
+	<-received
+	if delivery.Status != models.WebhookStatusPending || delivery.ResponseCode != http.StatusInternalServerError {
+		t.Fatalf("失败后期望等待重试，得到 %s %d", delivery.Status, delivery.ResponseCode)
+	}
+	if delivery.NextAttemptAt == nil || delivery.NextAttemptAt.Before(before.Add(time.Minute)) {
+		t.Fatalf("期望至少 %v 后重试，得到 %v", time.Minute, delivery.NextAttemptAt)
+	}
+
+	// 达到最多投递次数后标记为失败
+	globalWebhooks.deliver(delivery)
+	<-received
+	if delivery.Status != models.WebhookStatusFailed || delivery.NextAttemptAt != nil {
+		t.Fatalf("达到最多投递次数后期望失败，得到 %s %v", delivery.Status, delivery.NextAttemptAt)
+	}
+
+	// 接收方返回2xx视为成功
+	status.Store(http.StatusNoContent)
+	retried, err := db.RetryWebhookDelivery(delivery.ID)
+	if err != nil {
+		t.Fatalf("重新投递失败: %v", err)
+	}
+	globalWebhooks.deliver(retried)
+	r := <-received
+	if retried.Status != models.WebhookStatusSuccess || r.Header.Get(webhookIDHeader) != retried.EventID {
+		t.Fatalf("期望投递成功，得到 %s %s", retried.Status, r.Header.Get(webhookIDHeader))
+	}
+}
@@ -3,7 +3,10 @@ package config
 import (
 	_ "embed"
 	"log"
+	"net"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,6 +27,11 @@ type Config struct {
 		JSONFormat struct {
 			Enabled bool `yaml:"enabled"` // 是否启用格式化JSON响应
 		} `yaml:"json_format"`
+		TrustedProxies []string `yaml:"trusted_proxies"` // 可信的反向代理或负载均衡的IP或网段，只有来自这些地址的请求才读取转发的客户端IP
+		ProxyProtocol  struct {
+			Enabled       bool          `yaml:"enabled"`        // 是否在监听端口上接受PROXY协议（v1/v2）头
+			HeaderTimeout time.Duration `yaml:"header_timeout"` // 读取PROXY协议头的超时时间
+		} `yaml:"proxy_protocol"`
 	} `yaml:"server"`
 
 	Database struct {
@@ -51,6 +59,161 @@ type Config struct {
 		LocalEnabled bool   `yaml:"local_enabled"` // 是否启用本地图片
 		LocalPath    string `yaml:"local_path"`    // 本地图片目录路径
 	} `yaml:"random_image"`
+
+	Admin struct {
+		Username           string        `yaml:"username"`             // 管理员用户名
+		Password           string        `yaml:"password"`             // 管理员密码（启动时同步到数据库）
+		Token              string        `yaml:"token"`                // 管理API令牌，为空表示禁用令牌认证
+		SessionSecret      string        `yaml:"session_secret"`       // 会话Cookie签名密钥
+		SessionTTL         time.Duration `yaml:"session_ttl"`          // 会话有效期
+		LoginMaxFailures   int           `yaml:"login_max_failures"`   // 时间窗口内同一用户名或IP登录失败达到该次数时暂时禁止登录
+		LoginFailureWindow time.Duration `yaml:"login_failure_window"` // 统计登录失败次数的时间窗口
+		LoginLockout       time.Duration `yaml:"login_lockout"`        // 暂时禁止登录的时长
+	} `yaml:"admin"`
+
+	APIKey struct {
+		UsageFlushInterval  time.Duration `yaml:"usage_flush_interval"`  // 使用次数批量写入数据库的间隔
+		RotationGracePeriod time.Duration `yaml:"rotation_grace_period"` // 轮换密钥后旧密钥的默认宽限期
+		DisableQueryParam   bool          `yaml:"disable_query_param"`   // 是否禁止通过 api_key 查询参数传递密钥
+		SignatureMaxSkew    time.Duration `yaml:"signature_max_skew"`    // 签名请求允许的最大时钟偏差
+		SignatureMaxBody    int64         `yaml:"signature_max_body"`    // 签名请求的请求体最大字节数
+		SigningPepper       string        `yaml:"signing_pepper"`        // 派生签名密钥使用的服务器密钥，未配置时不支持签名请求
+		BanThreshold        int           `yaml:"ban_threshold"`         // 时间窗口内同一IP使用无效密钥达到该次数时封禁
+		BanWindow           time.Duration `yaml:"ban_window"`            // 统计无效密钥次数的时间窗口
+		BanDuration         time.Duration `yaml:"ban_duration"`          // 首次封禁的时长，之后每次封禁翻倍
+		BanMaxDuration      time.Duration `yaml:"ban_max_duration"`      // 单次封禁的最长时长
+	} `yaml:"api_key"`
+
+	Token struct {
+		Algorithm  string        `yaml:"algorithm"`   // 访问令牌签名算法（HS256, EdDSA）
+		TTL        time.Duration `yaml:"ttl"`         // 访问令牌有效期
+		Secret     string        `yaml:"secret"`      // HS256签名密钥
+		Ed25519Key string        `yaml:"ed25519_key"` // EdDSA签名私钥（base64编码的32字节种子）
+	} `yaml:"token"`
+
+	Plans map[string]Plan `yaml:"plans"` // 套餐，密钥通过名称引用
+
+	Costs struct {
+		Default int64            `yaml:"default"` // 未单独配置的接口每次调用扣除的计费单位
+		Plugins map[string]int64 `yaml:"plugins"` // 按插件名称配置的计费单位
+		Routes  map[string]int64 `yaml:"routes"`  // 按路径前缀配置的计费单位，优先于插件，最长前缀优先
+	} `yaml:"costs"`
+
+	RateLimit struct {
+		Default         RateLimitPolicy            `yaml:"default"`          // 默认策略，按API密钥或客户端IP限流
+		Routes          map[string]RateLimitPolicy `yaml:"routes"`           // 按路径前缀配置的额外策略，最长前缀优先
+		ExemptCIDRs     []string                   `yaml:"exempt_cidrs"`     // 不受速率限制的客户端IP或网段
+		CleanupInterval time.Duration              `yaml:"cleanup_interval"` // 清理不活动令牌桶的时间间隔
+		InactiveTimeout time.Duration              `yaml:"inactive_timeout"` // 令牌桶不活动超过该时长后被清理
+		Backend         string                     `yaml:"backend"`          // 限流状态的存储后端（memory, redis）
+		Redis           RateLimitRedis             `yaml:"redis"`            // 多实例共享限流状态的Redis配置
+	} `yaml:"rate_limit"`
+
+	Concurrency struct {
+		Plugins map[string]ConcurrencyLimit `yaml:"plugins"` // 按插件名称限制同时处理的请求数，未配置的插件不限制
+	} `yaml:"concurrency"`
+
+	Anonymous struct {
+		Plugins map[string]AnonymousLimit `yaml:"plugins"` // 允许匿名访问的插件及其速率限制
+		Routes  map[string]AnonymousLimit `yaml:"routes"`  // 允许匿名访问的路径前缀及其速率限制，优先于插件，最长前缀优先
+	} `yaml:"anonymous"`
+
+	Webhook struct {
+		Endpoints       []WebhookEndpoint `yaml:"endpoints"`         // 接收通知的地址
+		Timeout         time.Duration     `yaml:"timeout"`           // 单次投递的超时时间
+		MaxAttempts     int               `yaml:"max_attempts"`      // 最多投递次数（包括首次投递）
+		RetryBackoff    time.Duration     `yaml:"retry_backoff"`     // 首次重试前的等待时间，之后每次翻倍
+		QuotaThresholds []int             `yaml:"quota_thresholds"`  // 使用量达到使用上限的这些百分比时发送通知
+		RateLimitAlert  int               `yaml:"rate_limit_alert"`  // 时间窗口内被限流达到该次数时发送通知
+		RateLimitWindow time.Duration     `yaml:"rate_limit_window"` // 统计被限流次数的时间窗口
+	} `yaml:"webhook"`
+}
+
+// WebhookEndpoint Webhook接收地址
+type WebhookEndpoint struct {
+	URL    string   `yaml:"url"`    // 接收通知的URL
+	Secret string   `yaml:"secret"` // 签名密钥，接收方用于验证请求来源
+	Events []string `yaml:"events"` // 订阅的事件类型，为空表示订阅所有事件
+}
+
+// RateLimitPolicy 速率限制策略
+// 令牌桶和GCRA允许 Burst 次突发请求，之后按 Rate 恢复；滑动窗口在 Burst/Rate 秒的窗口内最多允许 Burst 次请求
+type RateLimitPolicy struct {
+	Algorithm string        `yaml:"algorithm"` // 限流算法（token_bucket, sliding_log, sliding_counter, gcra）
+	Burst     int64         `yaml:"burst"`     // 令牌桶容量（突发请求数），滑动窗口为窗口内最多请求数
+	Rate      float64       `yaml:"rate"`      // 令牌生成速率（每秒）
+	Window    time.Duration `yaml:"window"`    // 时间窗口，设置后速率按 Burst/Window 计算，如 burst: 1000 和 window: 1h 表示每小时1000次
+}
+
+// RateLimitRedis 多实例共享限流状态的Redis配置
+type RateLimitRedis struct {
+	Addr          string        `yaml:"addr"`           // Redis地址（host:port）
+	Password      string        `yaml:"password"`       // Redis密码
+	DB            int           `yaml:"db"`             // Redis数据库编号
+	KeyPrefix     string        `yaml:"key_prefix"`     // 限流状态的键前缀，多个服务共用一个Redis时用于区分
+	Timeout       time.Duration `yaml:"timeout"`        // 单次限流操作的超时时间
+	RetryInterval time.Duration `yaml:"retry_interval"` // Redis不可用时使用本地限流，经过该时长后再次尝试
+}
+
+// 速率限制的存储后端
+const (
+	RateLimitBackendMemory = "memory" // 进程内存储，每个实例单独计数
+	RateLimitBackendRedis  = "redis"  // Redis存储，多个实例共享计数
+)
+
+// 速率限制算法
+const (
+	RateLimitTokenBucket    = "token_bucket"    // 令牌桶，允许突发请求
+	RateLimitSlidingLog     = "sliding_log"     // 滑动窗口日志，精确记录窗口内每次请求的时间
+	RateLimitSlidingCounter = "sliding_counter" // 滑动窗口计数，按前后两个固定窗口的计数加权估算
+	RateLimitGCRA           = "gcra"            // 通用信元速率算法，请求均匀分布，只保存一个时间
+)
+
+// 未配置速率限制时的默认策略
+const (
+	defaultRateLimitAlgorithm = RateLimitTokenBucket
+	defaultRateLimitBurst     = 100
+	defaultRateLimitRate      = 1.666 // 约100次/分钟
+)
+
+// validRateLimitAlgorithm 判断是否为支持的限流算法
+func validRateLimitAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case RateLimitTokenBucket, RateLimitSlidingLog, RateLimitSlidingCounter, RateLimitGCRA:
+		return true
+	}
+	return false
+}
+
+// ConcurrencyLimit 插件的并发限制，超出 MaxInFlight 的请求排队等待，队列已满或等待超时时返回503
+type ConcurrencyLimit struct {
+	MaxInFlight  int           `yaml:"max_in_flight"` // 同时处理的最多请求数
+	MaxQueue     int           `yaml:"max_queue"`     // 排队等待的最多请求数，0表示不排队
+	QueueTimeout time.Duration `yaml:"queue_timeout"` // 排队的最长等待时间
+}
+
+// 并发限制未设置排队等待时间时的默认值
+const defaultConcurrencyQueueTimeout = 10 * time.Second
+
+// AnonymousLimit 匿名访问的速率限制，每个客户端IP在每个插件或路径上单独计算
+type AnonymousLimit struct {
+	RateLimitBurst int64   `yaml:"rate_limit_burst"` // 令牌桶容量（突发请求数）
+	RateLimitRate  float64 `yaml:"rate_limit_rate"`  // 令牌生成速率（每秒）
+}
+
+// 匿名访问未设置速率限制时的默认值，比携带API密钥的请求更严格
+const (
+	defaultAnonymousBurst = 10
+	defaultAnonymousRate  = 10.0 / 60 // 约10次/分钟
+)
+
+// Plan 套餐配置，密钥引用套餐后，套餐中设置的项覆盖密钥自身的设置
+type Plan struct {
+	MaxUsage       *int64   `yaml:"max_usage"`        // 配额周期内可用的计费单位，0表示不限制，不设置时使用密钥自身的上限
+	QuotaPeriod    *string  `yaml:"quota_period"`     // 配额周期（空/daily/monthly），不设置时使用密钥自身的周期
+	RateLimitBurst int64    `yaml:"rate_limit_burst"` // 速率限制的令牌桶容量，0表示使用密钥自身的设置
+	RateLimitRate  float64  `yaml:"rate_limit_rate"`  // 速率限制的令牌生成速率（每秒），0表示使用密钥自身的设置
+	Plugins        []string `yaml:"plugins"`          // 允许访问的插件名称，为空表示不限制
 }
 
 // ConfigUpdateCallback 配置更新回调函数类型
@@ -59,6 +222,7 @@ type ConfigUpdateCallback func(*Config)
 // ConfigManager 配置管理器单例
 type ConfigManager struct {
 	config          *Config
+	previousConfig  *Config // 最近一次更新之前的配置，用于记录配置变更
 	configPath      string
 	mutex           sync.RWMutex
 	watcher         *fsnotify.Watcher
@@ -93,10 +257,18 @@ func (cm *ConfigManager) GetConfig() *Config {
 	return cm.config
 }
 
+// GetPreviousConfig 获取最近一次更新之前的配置，尚未更新过时返回nil
+func (cm *ConfigManager) GetPreviousConfig() *Config {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	return cm.previousConfig
+}
+
 // SetConfig 设置配置
 func (cm *ConfigManager) SetConfig(config *Config) {
 	cm.mutex.Lock()
 	defer cm.mutex.Unlock()
+	cm.previousConfig = cm.config
 	cm.config = config
 }
 
@@ -207,6 +379,24 @@ func (cm *ConfigManager) validateConfig(config *Config) {
 		config.Server.Mode = "debug"
 	}
 
+	// 验证可信代理，无效的项忽略
+	trustedProxies := config.Server.TrustedProxies[:0]
+	for _, proxy := range config.Server.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			logrus.Warnf("无效的可信代理: %s, 已忽略", proxy)
+			continue
+		}
+		trustedProxies = append(trustedProxies, proxy)
+	}
+	config.Server.TrustedProxies = trustedProxies
+	if config.Server.ProxyProtocol.HeaderTimeout <= 0 {
+		config.Server.ProxyProtocol.HeaderTimeout = 5 * time.Second
+	}
+	if config.Server.ProxyProtocol.Enabled && len(config.Server.TrustedProxies) == 0 {
+		logrus.Warn("已启用PROXY协议但未配置可信代理, 所有连接发送的PROXY协议头都会被拒绝")
+	}
+
 	// 向后兼容：处理旧版本配置
 	// 检查是否存在旧的 db_path 配置
 	if config.IP2Region.V4DBPath == "" && config.IP2Region.V6DBPath == "" {
@@ -251,9 +441,279 @@ func (cm *ConfigManager) validateConfig(config *Config) {
 		config.Log.MaxAge = 7
 	}
 
+	// 验证管理员用户名
+	if config.Admin.Username == "" {
+		logrus.Warn("未配置管理员用户名, 使用默认值: admin")
+		config.Admin.Username = "admin"
+	}
+
+	// 验证管理员会话有效期
+	if config.Admin.SessionTTL <= 0 {
+		logrus.Warnf("无效的管理员会话有效期: %v, 使用默认值: 24h", config.Admin.SessionTTL)
+		config.Admin.SessionTTL = 24 * time.Hour
+	}
+
+	// 验证管理员登录失败限制
+	if config.Admin.LoginMaxFailures <= 0 {
+		config.Admin.LoginMaxFailures = 5
+	}
+	if config.Admin.LoginFailureWindow <= 0 {
+		config.Admin.LoginFailureWindow = 15 * time.Minute
+	}
+	if config.Admin.LoginLockout <= 0 {
+		config.Admin.LoginLockout = 15 * time.Minute
+	}
+
+	// 验证API密钥使用次数写入间隔
+	if config.APIKey.UsageFlushInterval <= 0 {
+		logrus.Warnf("无效的使用次数写入间隔: %v, 使用默认值: 1s", config.APIKey.UsageFlushInterval)
+		config.APIKey.UsageFlushInterval = time.Second
+	}
+
+	// 验证密钥轮换宽限期，0表示旧密钥立即失效
+	if config.APIKey.RotationGracePeriod < 0 {
+		logrus.Warnf("无效的密钥轮换宽限期: %v, 使用默认值: 0", config.APIKey.RotationGracePeriod)
+		config.APIKey.RotationGracePeriod = 0
+	}
+
+	// 验证签名请求允许的时钟偏差
+	if config.APIKey.SignatureMaxSkew <= 0 {
+		logrus.Warnf("无效的签名时钟偏差: %v, 使用默认值: 5m", config.APIKey.SignatureMaxSkew)
+		config.APIKey.SignatureMaxSkew = 5 * time.Minute
+	}
+	if config.APIKey.SignatureMaxBody <= 0 {
+		config.APIKey.SignatureMaxBody = 1 << 20
+	}
+	if config.APIKey.SigningPepper == "" {
+		logrus.Warn("未配置 api_key.signing_pepper，创建和轮换密钥时不返回签名密钥，签名请求将被拒绝")
+	}
+	if config.APIKey.BanThreshold <= 0 {
+		config.APIKey.BanThreshold = 10
+	}
+	if config.APIKey.BanWindow <= 0 {
+		config.APIKey.BanWindow = 10 * time.Minute
+	}
+	if config.APIKey.BanDuration <= 0 {
+		config.APIKey.BanDuration = time.Minute
+	}
+	if config.APIKey.BanMaxDuration <= 0 {
+		config.APIKey.BanMaxDuration = 24 * time.Hour
+	}
+	if config.APIKey.BanMaxDuration < config.APIKey.BanDuration {
+		logrus.Warnf("最长封禁时长 %v 小于首次封禁时长, 使用首次封禁时长: %v", config.APIKey.BanMaxDuration, config.APIKey.BanDuration)
+		config.APIKey.BanMaxDuration = config.APIKey.BanDuration
+	}
+
+	// 验证访问令牌签名算法和有效期
+	if config.Token.Algorithm != "HS256" && config.Token.Algorithm != "EdDSA" {
+		logrus.Warnf("无效的访问令牌签名算法: %s, 使用默认值: HS256", config.Token.Algorithm)
+		config.Token.Algorithm = "HS256"
+	}
+	if config.Token.TTL <= 0 {
+		logrus.Warnf("无效的访问令牌有效期: %v, 使用默认值: 15m", config.Token.TTL)
+		config.Token.TTL = 15 * time.Minute
+	}
+
+	// 验证套餐配置，无效的项按未设置处理
+	for name, plan := range config.Plans {
+		if plan.MaxUsage != nil && *plan.MaxUsage < 0 {
+			logrus.Warnf("套餐 %s 的使用上限无效: %d, 使用密钥自身的上限", name, *plan.MaxUsage)
+			plan.MaxUsage = nil
+		}
+		if plan.QuotaPeriod != nil && *plan.QuotaPeriod != "" && *plan.QuotaPeriod != "daily" && *plan.QuotaPeriod != "monthly" {
+			logrus.Warnf("套餐 %s 的配额周期无效: %s, 使用密钥自身的周期", name, *plan.QuotaPeriod)
+			plan.QuotaPeriod = nil
+		}
+		if plan.RateLimitBurst < 0 || plan.RateLimitRate < 0 {
+			logrus.Warnf("套餐 %s 的速率限制无效, 使用密钥自身的设置", name)
+			plan.RateLimitBurst, plan.RateLimitRate = 0, 0
+		}
+		config.Plans[name] = plan
+	}
+
+	// 验证计费单位，至少为1
+	if config.Costs.Default <= 0 {
+		config.Costs.Default = 1
+	}
+	for name, cost := range config.Costs.Plugins {
+		if cost <= 0 {
+			logrus.Warnf("插件 %s 的计费单位无效: %d, 使用默认值", name, cost)
+			delete(config.Costs.Plugins, name)
+		}
+	}
+	for prefix, cost := range config.Costs.Routes {
+		if cost <= 0 || !strings.HasPrefix(prefix, "/") {
+			logrus.Warnf("路径 %s 的计费单位无效: %d, 已忽略", prefix, cost)
+			delete(config.Costs.Routes, prefix)
+		}
+	}
+
+	// 验证速率限制配置，路径策略未设置的项使用默认策略的值
+	defaultPolicy := &config.RateLimit.Default
+	if defaultPolicy.Algorithm == "" {
+		defaultPolicy.Algorithm = defaultRateLimitAlgorithm
+	} else if !validRateLimitAlgorithm(defaultPolicy.Algorithm) {
+		logrus.Warnf("无效的速率限制算法: %s, 使用默认值: %s", defaultPolicy.Algorithm, defaultRateLimitAlgorithm)
+		defaultPolicy.Algorithm = defaultRateLimitAlgorithm
+	}
+	if defaultPolicy.Burst <= 0 {
+		defaultPolicy.Burst = defaultRateLimitBurst
+	}
+	if defaultPolicy.Window > 0 {
+		defaultPolicy.Rate = float64(defaultPolicy.Burst) / defaultPolicy.Window.Seconds()
+	}
+	if defaultPolicy.Rate <= 0 {
+		defaultPolicy.Rate = defaultRateLimitRate
+	}
+	for prefix, policy := range config.RateLimit.Routes {
+		if !strings.HasPrefix(prefix, "/") || policy.Burst < 0 || policy.Rate < 0 || policy.Window < 0 ||
+			(policy.Algorithm != "" && !validRateLimitAlgorithm(policy.Algorithm)) {
+			logrus.Warnf("路径 %s 的速率限制策略无效, 已忽略", prefix)
+			delete(config.RateLimit.Routes, prefix)
+			continue
+		}
+		if policy.Algorithm == "" {
+			policy.Algorithm = defaultPolicy.Algorithm
+		}
+		if policy.Burst == 0 {
+			policy.Burst = defaultPolicy.Burst
+		}
+		if policy.Window > 0 {
+			policy.Rate = float64(policy.Burst) / policy.Window.Seconds()
+		}
+		if policy.Rate == 0 {
+			policy.Rate = defaultPolicy.Rate
+		}
+		config.RateLimit.Routes[prefix] = policy
+	}
+	exemptCIDRs := config.RateLimit.ExemptCIDRs[:0]
+	for _, cidr := range config.RateLimit.ExemptCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+			logrus.Warnf("无效的速率限制豁免网段: %s, 已忽略", cidr)
+			continue
+		}
+		exemptCIDRs = append(exemptCIDRs, cidr)
+	}
+	config.RateLimit.ExemptCIDRs = exemptCIDRs
+	if config.RateLimit.CleanupInterval <= 0 {
+		config.RateLimit.CleanupInterval = 10 * time.Minute
+	}
+	switch config.RateLimit.Backend {
+	case "":
+		config.RateLimit.Backend = RateLimitBackendMemory
+	case RateLimitBackendMemory:
+	case RateLimitBackendRedis:
+		if config.RateLimit.Redis.Addr == "" {
+			logrus.Warn("速率限制使用Redis存储但未配置Redis地址, 使用进程内存储")
+			config.RateLimit.Backend = RateLimitBackendMemory
+		}
+	default:
+		logrus.Warnf("无效的速率限制存储后端: %s, 使用默认值: %s", config.RateLimit.Backend, RateLimitBackendMemory)
+		config.RateLimit.Backend = RateLimitBackendMemory
+	}
+	if config.RateLimit.Redis.KeyPrefix == "" {
+		config.RateLimit.Redis.KeyPrefix = "xrcuo:ratelimit:"
+	}
+	if config.RateLimit.Redis.Timeout <= 0 {
+		config.RateLimit.Redis.Timeout = 100 * time.Millisecond
+	}
+	if config.RateLimit.Redis.RetryInterval <= 0 {
+		config.RateLimit.Redis.RetryInterval = 5 * time.Second
+	}
+	if config.RateLimit.InactiveTimeout <= 0 {
+		config.RateLimit.InactiveTimeout = 30 * time.Minute
+	}
+
+	// 验证并发限制配置，未设置同时处理请求数的插件不限制
+	for name, limit := range config.Concurrency.Plugins {
+		if limit.MaxInFlight <= 0 {
+			logrus.Warnf("插件 %s 的并发限制无效: %d, 已忽略", name, limit.MaxInFlight)
+			delete(config.Concurrency.Plugins, name)
+			continue
+		}
+		if limit.MaxQueue < 0 {
+			logrus.Warnf("插件 %s 的排队请求数无效: %d, 使用默认值: 0", name, limit.MaxQueue)
+			limit.MaxQueue = 0
+		}
+		if limit.QueueTimeout <= 0 {
+			limit.QueueTimeout = defaultConcurrencyQueueTimeout
+		}
+		config.Concurrency.Plugins[name] = limit
+	}
+
+	// 验证匿名访问配置，未设置的速率限制使用默认值
+	for name, limit := range config.Anonymous.Plugins {
+		config.Anonymous.Plugins[name] = validAnonymousLimit("插件 "+name, limit)
+	}
+	for prefix, limit := range config.Anonymous.Routes {
+		if !strings.HasPrefix(prefix, "/") {
+			logrus.Warnf("匿名访问路径 %s 无效, 已忽略", prefix)
+			delete(config.Anonymous.Routes, prefix)
+			continue
+		}
+		config.Anonymous.Routes[prefix] = validAnonymousLimit("路径 "+prefix, limit)
+	}
+
+	// 验证Webhook配置
+	endpoints := config.Webhook.Endpoints[:0]
+	for _, endpoint := range config.Webhook.Endpoints {
+		if !strings.HasPrefix(endpoint.URL, "http://") && !strings.HasPrefix(endpoint.URL, "https://") {
+			logrus.Warnf("无效的Webhook地址: %s, 已忽略", endpoint.URL)
+			continue
+		}
+		if endpoint.Secret == "" {
+			logrus.Warnf("Webhook地址 %s 未配置签名密钥，接收方将无法验证请求来源", endpoint.URL)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	config.Webhook.Endpoints = endpoints
+	if config.Webhook.Timeout <= 0 {
+		config.Webhook.Timeout = 5 * time.Second
+	}
+	if config.Webhook.MaxAttempts <= 0 {
+		config.Webhook.MaxAttempts = 5
+	}
+	if config.Webhook.RetryBackoff <= 0 {
+		config.Webhook.RetryBackoff = 30 * time.Second
+	}
+	if config.Webhook.QuotaThresholds == nil {
+		config.Webhook.QuotaThresholds = []int{80, 100}
+	}
+	thresholds := config.Webhook.QuotaThresholds[:0]
+	for _, threshold := range config.Webhook.QuotaThresholds {
+		if threshold <= 0 || threshold > 100 {
+			logrus.Warnf("无效的配额通知阈值: %d%%, 已忽略", threshold)
+			continue
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	config.Webhook.QuotaThresholds = thresholds
+	if config.Webhook.RateLimitAlert <= 0 {
+		config.Webhook.RateLimitAlert = 10
+	}
+	if config.Webhook.RateLimitWindow <= 0 {
+		config.Webhook.RateLimitWindow = time.Minute
+	}
+
 	logrus.Debug("配置验证完成")
 }
 
+// validAnonymousLimit 检查匿名访问的速率限制，未设置或无效时使用默认值
+func validAnonymousLimit(name string, limit AnonymousLimit) AnonymousLimit {
+	if limit.RateLimitBurst < 0 || limit.RateLimitRate < 0 {
+		logrus.Warnf("%s 的匿名访问速率限制无效, 使用默认值", name)
+		limit.RateLimitBurst, limit.RateLimitRate = 0, 0
+	}
+	if limit.RateLimitBurst == 0 {
+		limit.RateLimitBurst = defaultAnonymousBurst
+	}
+	if limit.RateLimitRate == 0 {
+		limit.RateLimitRate = defaultAnonymousRate
+	}
+	return limit
+}
+
 // WatchConfig 监听配置文件变化
 func (cm *ConfigManager) WatchConfig() {
 	if cm.isWatching {
@@ -299,7 +759,7 @@ func (cm *ConfigManager) WatchConfig() {
 				// 只处理写入和创建事件
 				if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
 					logrus.Info("配置文件发生变化，重新加载配置")
-					
+
 					// 防抖处理：短时间内只处理一次配置更新
 					cm.debounceMutex.Lock()
 					if cm.debounceTimer != nil {
@@ -365,6 +825,36 @@ func IsJSONFormatEnabled() bool {
 	return config.Server.JSONFormat.Enabled
 }
 
+// GetTrustedProxies 获取可信代理的IP或网段
+func GetTrustedProxies() []string {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return nil
+	}
+	return config.Server.TrustedProxies
+}
+
+// IsProxyProtocolEnabled 获取是否接受PROXY协议头
+func IsProxyProtocolEnabled() bool {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return false
+	}
+	return config.Server.ProxyProtocol.Enabled
+}
+
+// GetProxyProtocolHeaderTimeout 获取读取PROXY协议头的超时时间
+func GetProxyProtocolHeaderTimeout() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Server.ProxyProtocol.HeaderTimeout <= 0 {
+		return 5 * time.Second
+	}
+	return config.Server.ProxyProtocol.HeaderTimeout
+}
+
 // GetDatabasePath 获取数据库文件路径
 func GetDatabasePath() string {
 	cm := GetInstance()
@@ -424,3 +914,465 @@ func GetLogLevel() string {
 	}
 	return config.Log.Level
 }
+
+// GetAdminUsername 获取管理员用户名
+func GetAdminUsername() string {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Admin.Username == "" {
+		return "admin"
+	}
+	return config.Admin.Username
+}
+
+// GetAdminPassword 获取管理员密码
+func GetAdminPassword() string {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return ""
+	}
+	return config.Admin.Password
+}
+
+// GetAdminToken 获取管理API令牌
+func GetAdminToken() string {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return ""
+	}
+	return config.Admin.Token
+}
+
+// GetAdminSessionSecret 获取会话Cookie签名密钥
+func GetAdminSessionSecret() string {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return ""
+	}
+	return config.Admin.SessionSecret
+}
+
+// GetAdminSessionTTL 获取管理员会话有效期
+func GetAdminSessionTTL() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Admin.SessionTTL <= 0 {
+		return 24 * time.Hour
+	}
+	return config.Admin.SessionTTL
+}
+
+// GetAdminLoginMaxFailures 获取暂时禁止登录前允许的登录失败次数
+func GetAdminLoginMaxFailures() int {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Admin.LoginMaxFailures <= 0 {
+		return 5
+	}
+	return config.Admin.LoginMaxFailures
+}
+
+// GetAdminLoginFailureWindow 获取统计登录失败次数的时间窗口
+func GetAdminLoginFailureWindow() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Admin.LoginFailureWindow <= 0 {
+		return 15 * time.Minute
+	}
+	return config.Admin.LoginFailureWindow
+}
+
+// GetAdminLoginLockout 获取登录失败次数过多后暂时禁止登录的时长
+func GetAdminLoginLockout() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Admin.LoginLockout <= 0 {
+		return 15 * time.Minute
+	}
+	return config.Admin.LoginLockout
+}
+
+// GetAPIKeyUsageFlushInterval 获取API密钥使用次数批量写入数据库的间隔
+func GetAPIKeyUsageFlushInterval() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.APIKey.UsageFlushInterval <= 0 {
+		return time.Second
+	}
+	return config.APIKey.UsageFlushInterval
+}
+
+// GetAPIKeyRotationGracePeriod 获取轮换密钥后旧密钥的默认宽限期
+func GetAPIKeyRotationGracePeriod() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return 0
+	}
+	return config.APIKey.RotationGracePeriod
+}
+
+// IsAPIKeyQueryParamDisabled 获取是否禁止通过查询参数传递API密钥
+func IsAPIKeyQueryParamDisabled() bool {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return false
+	}
+	return config.APIKey.DisableQueryParam
+}
+
+// GetAPIKeySignatureMaxSkew 获取签名请求的时间戳与服务器时间允许的最大偏差
+func GetAPIKeySignatureMaxSkew() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.APIKey.SignatureMaxSkew <= 0 {
+		return 5 * time.Minute
+	}
+	return config.APIKey.SignatureMaxSkew
+}
+
+// GetAPIKeySignatureMaxBody 获取签名请求的请求体最大字节数
+func GetAPIKeySignatureMaxBody() int64 {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.APIKey.SignatureMaxBody <= 0 {
+		return 1 << 20
+	}
+	return config.APIKey.SignatureMaxBody
+}
+
+// GetAPIKeySigningPepper 获取派生签名密钥使用的服务器密钥
+func GetAPIKeySigningPepper() string {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return ""
+	}
+	return config.APIKey.SigningPepper
+}
+
+// GetAPIKeyBanThreshold 获取触发IP封禁的无效密钥次数
+func GetAPIKeyBanThreshold() int {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.APIKey.BanThreshold <= 0 {
+		return 10
+	}
+	return config.APIKey.BanThreshold
+}
+
+// GetAPIKeyBanWindow 获取统计无效密钥次数的时间窗口
+func GetAPIKeyBanWindow() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.APIKey.BanWindow <= 0 {
+		return 10 * time.Minute
+	}
+	return config.APIKey.BanWindow
+}
+
+// GetAPIKeyBanDuration 获取首次封禁IP的时长
+func GetAPIKeyBanDuration() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.APIKey.BanDuration <= 0 {
+		return time.Minute
+	}
+	return config.APIKey.BanDuration
+}
+
+// GetAPIKeyBanMaxDuration 获取单次封禁IP的最长时长
+func GetAPIKeyBanMaxDuration() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.APIKey.BanMaxDuration <= 0 {
+		return 24 * time.Hour
+	}
+	return config.APIKey.BanMaxDuration
+}
+
+// GetTokenAlgorithm 获取访问令牌签名算法
+func GetTokenAlgorithm() string {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Token.Algorithm == "" {
+		return "HS256"
+	}
+	return config.Token.Algorithm
+}
+
+// GetTokenTTL 获取访问令牌有效期
+func GetTokenTTL() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Token.TTL <= 0 {
+		return 15 * time.Minute
+	}
+	return config.Token.TTL
+}
+
+// GetTokenSecret 获取HS256访问令牌签名密钥
+func GetTokenSecret() string {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return ""
+	}
+	return config.Token.Secret
+}
+
+// GetTokenEd25519Key 获取EdDSA访问令牌签名私钥（base64编码）
+func GetTokenEd25519Key() string {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return ""
+	}
+	return config.Token.Ed25519Key
+}
+
+// GetPlan 获取指定名称的套餐配置
+func GetPlan(name string) (Plan, bool) {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || name == "" {
+		return Plan{}, false
+	}
+	plan, ok := config.Plans[name]
+	return plan, ok
+}
+
+// GetPlanNames 获取所有套餐名称，按名称排序
+func GetPlanNames() []string {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	names := []string{}
+	if config == nil {
+		return names
+	}
+	for name := range config.Plans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetRequestCost 获取一次调用扣除的计费单位
+// 优先使用最长匹配的路径前缀，其次使用插件的配置，都未配置时使用默认值（默认为1）
+func GetRequestCost(plugin, path string) int64 {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return 1
+	}
+
+	matched := ""
+	var cost int64
+	for prefix, value := range config.Costs.Routes {
+		if len(prefix) > len(matched) && (path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")) {
+			matched, cost = prefix, value
+		}
+	}
+	if matched != "" {
+		return cost
+	}
+	if value, ok := config.Costs.Plugins[plugin]; ok {
+		return value
+	}
+	if config.Costs.Default <= 0 {
+		return 1
+	}
+	return config.Costs.Default
+}
+
+// GetAnonymousLimit 获取插件或路径的匿名访问设置
+// 按路径前缀匹配（最长前缀优先），未匹配时按插件名称匹配；返回匹配到的规则名称，未允许匿名访问时ok为false
+func GetAnonymousLimit(plugin, path string) (rule string, limit AnonymousLimit, ok bool) {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return "", AnonymousLimit{}, false
+	}
+
+	matched := ""
+	for prefix, value := range config.Anonymous.Routes {
+		if len(prefix) > len(matched) && (path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")) {
+			matched, limit = prefix, value
+		}
+	}
+	if matched != "" {
+		return "route:" + matched, limit, true
+	}
+	if value, exists := config.Anonymous.Plugins[plugin]; exists {
+		return "plugin:" + plugin, value, true
+	}
+	return "", AnonymousLimit{}, false
+}
+
+// GetRateLimitDefault 获取默认的速率限制策略
+func GetRateLimitDefault() RateLimitPolicy {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.RateLimit.Default.Burst <= 0 || config.RateLimit.Default.Rate <= 0 {
+		return RateLimitPolicy{Algorithm: defaultRateLimitAlgorithm, Burst: defaultRateLimitBurst, Rate: defaultRateLimitRate}
+	}
+	policy := config.RateLimit.Default
+	if !validRateLimitAlgorithm(policy.Algorithm) {
+		policy.Algorithm = defaultRateLimitAlgorithm
+	}
+	return policy
+}
+
+// GetRateLimitRoutes 获取按路径前缀配置的速率限制策略
+func GetRateLimitRoutes() map[string]RateLimitPolicy {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return nil
+	}
+	return config.RateLimit.Routes
+}
+
+// GetRateLimitExemptCIDRs 获取不受速率限制的客户端IP或网段
+func GetRateLimitExemptCIDRs() []string {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return nil
+	}
+	return config.RateLimit.ExemptCIDRs
+}
+
+// GetRateLimitCleanupInterval 获取清理不活动令牌桶的时间间隔
+func GetRateLimitCleanupInterval() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.RateLimit.CleanupInterval <= 0 {
+		return 10 * time.Minute
+	}
+	return config.RateLimit.CleanupInterval
+}
+
+// GetRateLimitInactiveTimeout 获取令牌桶的不活动超时时间
+func GetRateLimitInactiveTimeout() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.RateLimit.InactiveTimeout <= 0 {
+		return 30 * time.Minute
+	}
+	return config.RateLimit.InactiveTimeout
+}
+
+// GetRateLimitRedis 获取共享限流状态的Redis配置，未使用Redis存储时返回nil
+func GetRateLimitRedis() *RateLimitRedis {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.RateLimit.Backend != RateLimitBackendRedis || config.RateLimit.Redis.Addr == "" {
+		return nil
+	}
+	redisConfig := config.RateLimit.Redis
+	if redisConfig.KeyPrefix == "" {
+		redisConfig.KeyPrefix = "xrcuo:ratelimit:"
+	}
+	if redisConfig.Timeout <= 0 {
+		redisConfig.Timeout = 100 * time.Millisecond
+	}
+	if redisConfig.RetryInterval <= 0 {
+		redisConfig.RetryInterval = 5 * time.Second
+	}
+	return &redisConfig
+}
+
+// GetConcurrencyLimit 获取插件的并发限制，未配置时ok为false
+func GetConcurrencyLimit(plugin string) (limit ConcurrencyLimit, ok bool) {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return ConcurrencyLimit{}, false
+	}
+	limit, ok = config.Concurrency.Plugins[plugin]
+	if !ok || limit.MaxInFlight <= 0 {
+		return ConcurrencyLimit{}, false
+	}
+	if limit.MaxQueue < 0 {
+		limit.MaxQueue = 0
+	}
+	if limit.QueueTimeout <= 0 {
+		limit.QueueTimeout = defaultConcurrencyQueueTimeout
+	}
+	return limit, true
+}
+
+// GetWebhookEndpoints 获取Webhook接收地址
+func GetWebhookEndpoints() []WebhookEndpoint {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil {
+		return nil
+	}
+	return config.Webhook.Endpoints
+}
+
+// GetWebhookTimeout 获取Webhook单次投递的超时时间
+func GetWebhookTimeout() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Webhook.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return config.Webhook.Timeout
+}
+
+// GetWebhookMaxAttempts 获取Webhook最多投递次数
+func GetWebhookMaxAttempts() int {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Webhook.MaxAttempts <= 0 {
+		return 5
+	}
+	return config.Webhook.MaxAttempts
+}
+
+// GetWebhookRetryBackoff 获取Webhook首次重试前的等待时间
+func GetWebhookRetryBackoff() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Webhook.RetryBackoff <= 0 {
+		return 30 * time.Second
+	}
+	return config.Webhook.RetryBackoff
+}
+
+// GetWebhookQuotaThresholds 获取触发配额通知的使用百分比
+func GetWebhookQuotaThresholds() []int {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Webhook.QuotaThresholds == nil {
+		return []int{80, 100}
+	}
+	return config.Webhook.QuotaThresholds
+}
+
+// GetWebhookRateLimitAlert 获取时间窗口内触发限流通知的被限流次数
+func GetWebhookRateLimitAlert() int {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Webhook.RateLimitAlert <= 0 {
+		return 10
+	}
+	return config.Webhook.RateLimitAlert
+}
+
+// GetWebhookRateLimitWindow 获取统计被限流次数的时间窗口
+func GetWebhookRateLimitWindow() time.Duration {
+	cm := GetInstance()
+	config := cm.GetConfig()
+	if config == nil || config.Webhook.RateLimitWindow <= 0 {
+		return time.Minute
+	}
+	return config.Webhook.RateLimitWindow
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestValidateConfigRateLimit(t *testing.T) {
+	logrus.SetLevel(logrus.ErrorLevel)
+
+	defaultPolicy := RateLimitPolicy{Algorithm: RateLimitTokenBucket, Burst: defaultRateLimitBurst, Rate: defaultRateLimitRate}
+	cases := []struct {
+		name     string
+		policy   RateLimitPolicy
+		expected RateLimitPolicy
+	}{
+		{"未配置时使用默认策略", RateLimitPolicy{}, defaultPolicy},
+		{"无效的算法使用默认算法", RateLimitPolicy{Algorithm: "leaky_bucket", Burst: 10, Rate: 1}, RateLimitPolicy{Algorithm: RateLimitTokenBucket, Burst: 10, Rate: 1}},
+		{"只设置容量", RateLimitPolicy{Algorithm: RateLimitGCRA, Burst: 10}, RateLimitPolicy{Algorithm: RateLimitGCRA, Burst: 10, Rate: defaultRateLimitRate}},
+		{"容量无效", RateLimitPolicy{Burst: -1, Rate: 2}, RateLimitPolicy{Algorithm: RateLimitTokenBucket, Burst: defaultRateLimitBurst, Rate: 2}},
+		{"按时间窗口计算速率", RateLimitPolicy{Algorithm: RateLimitSlidingCounter, Burst: 1800, Window: time.Hour}, RateLimitPolicy{Algorithm: RateLimitSlidingCounter, Burst: 1800, Rate: 0.5, Window: time.Hour}},
+	}
+	for _, tc := range cases {
+		cfg := &Config{}
+		cfg.RateLimit.Default = tc.policy
+		(&ConfigManager{}).validateConfig(cfg)
+		if cfg.RateLimit.Default != tc.expected {
+			t.Errorf("%s: 期望 %+v，得到 %+v", tc.name, tc.expected, cfg.RateLimit.Default)
+		}
+	}
+}
+
+func TestValidateConfigRateLimitRoutes(t *testing.T) {
+	logrus.SetLevel(logrus.ErrorLevel)
+
+	cfg := &Config{}
+	cfg.RateLimit.Default = RateLimitPolicy{Algorithm: RateLimitSlidingLog, Burst: 50, Rate: 2}
+	cfg.RateLimit.Routes = map[string]RateLimitPolicy{
+		"/api/ping":   {Burst: 10},
+		"/api/random": {Algorithm: RateLimitGCRA, Burst: 60, Window: time.Minute},
+		"/api/ip":     {Rate: 0.5},
+		"api/client":  {Burst: 1},                           // 不是以 / 开头的路径
+		"/api/bad":    {Algorithm: "leaky_bucket"},          // 无效的算法
+		"/api/neg":    {Burst: -1},                          // 无效的容量
+		"/api/window": {Burst: 1, Window: -1 * time.Second}, // 无效的时间窗口
+	}
+	cfg.RateLimit.ExemptCIDRs = []string{" 10.0.0.0/8 ", "127.0.0.1", "not-an-ip"}
+	(&ConfigManager{}).validateConfig(cfg)
+
+	expected := map[string]RateLimitPolicy{
+		"/api/ping":   {Algorithm: RateLimitSlidingLog, Burst: 10, Rate: 2},
+		"/api/random": {Algorithm: RateLimitGCRA, Burst: 60, Rate: 1, Window: time.Minute},
+		"/api/ip":     {Algorithm: RateLimitSlidingLog, Burst: 50, Rate: 0.5},
+	}
+	if len(cfg.RateLimit.Routes) != len(expected) {
+		t.Fatalf("期望 %d 个路径策略，得到 %+v", len(expected), cfg.RateLimit.Routes)
+	}
+	for prefix, policy := range expected {
+		if cfg.RateLimit.Routes[prefix] != policy {
+			t.Errorf("%s: 期望 %+v，得到 %+v", prefix, policy, cfg.RateLimit.Routes[prefix])
+		}
+	}
+	if len(cfg.RateLimit.ExemptCIDRs) != 2 || cfg.RateLimit.ExemptCIDRs[0] != "10.0.0.0/8" || cfg.RateLimit.ExemptCIDRs[1] != "127.0.0.1" {
+		t.Errorf("期望忽略无效的豁免网段，得到 %v", cfg.RateLimit.ExemptCIDRs)
+	}
+}
+
+func TestGetRateLimitPolicies(t *testing.T) {
+	cm := GetInstance()
+	previous := cm.GetConfig()
+	t.Cleanup(func() { cm.SetConfig(previous) })
+
+	defaultPolicy := RateLimitPolicy{Algorithm: RateLimitTokenBucket, Burst: defaultRateLimitBurst, Rate: defaultRateLimitRate}
+	cm.SetConfig(nil)
+	if policy := GetRateLimitDefault(); policy != defaultPolicy {
+		t.Errorf("未加载配置期望默认策略，得到 %+v", policy)
+	}
+	if routes := GetRateLimitRoutes(); routes != nil {
+		t.Errorf("未加载配置期望没有路径策略，得到 %+v", routes)
+	}
+
+	cases := []struct {
+		name     string
+		policy   RateLimitPolicy
+		expected RateLimitPolicy
+	}{
+		{"有效的策略", RateLimitPolicy{Algorithm: RateLimitGCRA, Burst: 5, Rate: 1}, RateLimitPolicy{Algorithm: RateLimitGCRA, Burst: 5, Rate: 1}},
+		{"无效的算法使用默认算法", RateLimitPolicy{Algorithm: "leaky_bucket", Burst: 5, Rate: 1}, RateLimitPolicy{Algorithm: RateLimitTokenBucket, Burst: 5, Rate: 1}},
+		{"容量无效时使用默认策略", RateLimitPolicy{Algorithm: RateLimitGCRA, Rate: 1}, defaultPolicy},
+		{"速率无效时使用默认策略", RateLimitPolicy{Algorithm: RateLimitGCRA, Burst: 5}, defaultPolicy},
+	}
+	for _, tc := range cases {
+		cfg := &Config{}
+		cfg.RateLimit.Default = tc.policy
+		cfg.RateLimit.Routes = map[string]RateLimitPolicy{"/api/ping": tc.policy}
+		cm.SetConfig(cfg)
+		if policy := GetRateLimitDefault(); policy != tc.expected {
+			t.Errorf("%s: 期望 %+v，得到 %+v", tc.name, tc.expected, policy)
+		}
+		if routes := GetRateLimitRoutes(); routes["/api/ping"] != tc.policy {
+			t.Errorf("%s: 期望返回配置中的路径策略，得到 %+v", tc.name, routes)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// ErrAccountNotFound 账户不存在
+var ErrAccountNotFound = errors.New("账户不存在")
+
+// ErrAccountQuotaExceeded 账户已达到合计使用上限
+var ErrAccountQuotaExceeded = errors.New("账户已达到使用上限")
+
+// accountColumns 查询账户时使用的列，与 scanAccount 的字段顺序保持一致
+// 密钥数量和密钥使用次数之和通过子查询汇总
+const accountColumns = `a.id, a.name, a.contact, a.plan, a.notes, a.max_usage, a.current_usage, a.quota_period, a.quota_reset_at,
+	(SELECT COUNT(*) FROM api_keys k WHERE k.account_id = a.id),
+	(SELECT COALESCE(SUM(k.current_usage), 0) FROM api_keys k WHERE k.account_id = a.id),
+	a.created_at, a.updated_at`
+
+// scanAccount 按 accountColumns 的顺序扫描一条账户记录
+func scanAccount(scanner rowScanner) (*models.Account, error) {
+	account := &models.Account{}
+	var quotaResetAt int64
+	err := scanner.Scan(
+		&account.ID, &account.Name, &account.Contact, &account.Plan, &account.Notes, &account.MaxUsage,
+		&account.CurrentUsage, &account.QuotaPeriod, &quotaResetAt, &account.KeyCount, &account.KeyUsage,
+		&account.CreatedAt, &account.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if quotaResetAt > 0 {
+		resetAt := time.Unix(quotaResetAt, 0)
+		account.QuotaResetAt = &resetAt
+	}
+	return account, nil
+}
+
+// CreateAccount 创建账户
+// params: 账户属性，ID、使用次数和时间字段由本函数生成
+func CreateAccount(params *models.Account) (*models.Account, error) {
+	account := *params
+	account.CurrentUsage = 0
+	account.QuotaResetAt = nil
+	account.KeyCount = 0
+	account.KeyUsage = 0
+	account.CreatedAt = time.Now()
+	account.UpdatedAt = account.CreatedAt
+
+	result, err := DB.Exec(
+		"INSERT INTO accounts (name, contact, plan, notes, max_usage, quota_period, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		account.Name, account.Contact, account.Plan, account.Notes, account.MaxUsage, account.QuotaPeriod, account.CreatedAt, account.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("创建账户失败: %v", err)
+	}
+
+	account.ID, err = result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("获取账户ID失败: %v", err)
+	}
+
+	return &account, nil
+}
+
+// GetAccountByID 通过ID获取账户信息
+// id: 账户ID
+func GetAccountByID(id int64) (*models.Account, error) {
+	account, err := scanAccount(DB.QueryRow(
+		"SELECT "+accountColumns+" FROM accounts a WHERE a.id = ?",
+		id,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAccountNotFound
+		}
+		return nil, fmt.Errorf("查询账户失败: %v", err)
+	}
+
+	return account, nil
+}
+
+// GetAllAccounts 获取所有账户
+// 按创建时间倒序排列
+func GetAllAccounts() ([]*models.Account, error) {
+	rows, err := DB.Query(
+		"SELECT " + accountColumns + " FROM accounts a ORDER BY a.created_at DESC",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("查询所有账户失败: %v", err)
+	}
+	defer rows.Close()
+
+	var accounts []*models.Account
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, fmt.Errorf("扫描账户失败: %v", err)
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
+
+// GetAPIKeysByAccount 获取账户下的所有API密钥
+// 按创建时间倒序排列
+func GetAPIKeysByAccount(accountID int64) ([]*models.APIKey, error) {
+	rows, err := DB.Query(
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE account_id = ? ORDER BY created_at DESC",
+		accountID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("查询账户API密钥失败: %v", err)
+	}
+	defer rows.Close()
+
+	var apiKeys []*models.APIKey
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("扫描API密钥失败: %v", err)
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	return apiKeys, nil
+}
+
+// UpdateAccount 部分更新账户属性
+// id: 账户ID
+// update: 需要更新的字段，nil字段保持不变
+func UpdateAccount(id int64, update *models.AccountUpdate) (*models.Account, error) {
+	sets := []string{"updated_at = ?"}
+	args := []interface{}{time.Now()}
+
+	if update.Name != nil {
+		sets = append(sets, "name = ?")
+		args = append(args, *update.Name)
+	}
+	if update.Contact != nil {
+		sets = append(sets, "contact = ?")
+		args = append(args, *update.Contact)
+	}
+	if update.Plan != nil {
+		sets = append(sets, "plan = ?")
+		args = append(args, *update.Plan)
+	}
+	if update.Notes != nil {
+		sets = append(sets, "notes = ?")
+		args = append(args, *update.Notes)
+	}
+	if update.MaxUsage != nil {
+		sets = append(sets, "max_usage = ?")
+		args = append(args, *update.MaxUsage)
+	}
+	if update.QuotaPeriod != nil {
+		// 周期设置变化后清空周期结束时间，下一次调用时从新周期开始计数
+		sets = append(sets, "quota_period = ?", "quota_reset_at = 0")
+		args = append(args, *update.QuotaPeriod)
+	}
+
+	args = append(args, id)
+	result, err := DB.Exec("UPDATE accounts SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...)
+	if err != nil {
+		return nil, fmt.Errorf("更新账户失败: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("获取影响行数失败: %v", err)
+	}
+	if rowsAffected == 0 {
+		return nil, ErrAccountNotFound
+	}
+
+	return GetAccountByID(id)
+}
+
+// DeleteAccount 删除账户，账户下的密钥保留并移出账户
+// id: 账户ID
+func DeleteAccount(id int64) error {
+	return Transaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec("DELETE FROM accounts WHERE id = ?", id)
+		if err != nil {
+			return fmt.Errorf("删除账户失败: %v", err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("获取影响行数失败: %v", err)
+		}
+		if rowsAffected == 0 {
+			return ErrAccountNotFound
+		}
+
+		if _, err := tx.Exec("UPDATE api_keys SET account_id = 0, updated_at = ? WHERE account_id = ?", time.Now(), id); err != nil {
+			return fmt.Errorf("移出账户API密钥失败: %v", err)
+		}
+		return nil
+	})
+}
+
+// FlushAccountUsage 在一个事务中批量写入多个账户累计的使用次数
+// 规则与 FlushAPIKeyUsage 相同，账户不记录最后使用时间
+// deltas: 各账户的使用增量
+func FlushAccountUsage(deltas []UsageDelta) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	return Transaction(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(
+			`UPDATE accounts SET
+				current_usage = MAX(CASE WHEN quota_reset_at < ? THEN ? ELSE current_usage + ? END, 0),
+				quota_reset_at = MAX(quota_reset_at, ?)
+			WHERE id = ? AND (? = 0 OR quota_reset_at <= ?)`,
+		)
+		if err != nil {
+			return fmt.Errorf("准备批量更新使用次数语句失败: %v", err)
+		}
+		defer stmt.Close()
+
+		for _, d := range deltas {
+			if _, err := stmt.Exec(d.WindowEnd, d.Count, d.Count, d.WindowEnd, d.ID, d.WindowEnd, d.WindowEnd); err != nil {
+				return fmt.Errorf("批量更新账户 %d 使用次数失败: %v", d.ID, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// CreateAdminUser 创建管理员账号
+// username: 用户名
+// passwordHash: 已哈希的密码
+func CreateAdminUser(username, passwordHash string) (*models.AdminUser, error) {
+	now := time.Now()
+	result, err := DB.Exec(
+		"INSERT INTO admin_users (username, password_hash, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		username, passwordHash, now, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("创建管理员账号失败: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("获取管理员账号ID失败: %v", err)
+	}
+
+	return &models.AdminUser{
+		ID:           id,
+		Username:     username,
+		PasswordHash: passwordHash,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}, nil
+}
+
+// GetAdminUserByUsername 通过用户名获取管理员账号
+// 账号不存在时返回 nil, nil
+func GetAdminUserByUsername(username string) (*models.AdminUser, error) {
+	user := &models.AdminUser{}
+	err := DB.QueryRow(
+		"SELECT id, username, password_hash, session_generation, created_at, updated_at FROM admin_users WHERE username = ?",
+		username,
+	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.SessionGen, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("查询管理员账号失败: %v", err)
+	}
+
+	return user, nil
+}
+
+// UpdateAdminPassword 更新管理员密码，同时递增会话版本，使已签发的会话失效
+// id: 管理员账号ID
+// passwordHash: 已哈希的新密码
+func UpdateAdminPassword(id int64, passwordHash string) error {
+	_, err := DB.Exec(
+		"UPDATE admin_users SET password_hash = ?, session_generation = session_generation + 1, updated_at = ? WHERE id = ?",
+		passwordHash, time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("更新管理员密码失败: %v", err)
+	}
+	return nil
+}
+
+// RevokeAdminSessions 递增管理员的会话版本，使该管理员已签发的所有会话失效
+func RevokeAdminSessions(username string) error {
+	_, err := DB.Exec(
+		"UPDATE admin_users SET session_generation = session_generation + 1 WHERE username = ?",
+		username,
+	)
+	if err != nil {
+		return fmt.Errorf("注销管理员会话失败: %v", err)
+	}
+	return nil
+}
@@ -1,14 +1,33 @@
 package db
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/xrcuo/xrcuo-api/config"
 	"github.com/xrcuo/xrcuo-api/models"
 )
 
+// ErrAPIKeyNotFound API密钥不存在
+var ErrAPIKeyNotFound = errors.New("API密钥不存在")
+
+// ErrAPIKeyQuotaExceeded API密钥已达到使用上限
+var ErrAPIKeyQuotaExceeded = errors.New("API密钥已达到使用上限")
+
+// ErrSigningPepperNotConfigured 未配置 api_key.signing_pepper，不能派生签名密钥
+var ErrSigningPepperNotConfigured = errors.New("未配置 api_key.signing_pepper，不支持签名请求")
+
+// ErrAPIKeyRotationInGrace 上一次轮换的旧密钥仍在宽限期内，不能再次带宽限期轮换
+var ErrAPIKeyRotationInGrace = errors.New("上一次轮换的旧密钥仍在宽限期内")
+
 // generateAPIKey 生成随机API密钥
 // 使用32字节的随机数据，转换为64位的十六进制字符串
 func generateAPIKey() (string, error) {
@@ -19,60 +38,173 @@ func generateAPIKey() (string, error) {
 	return fmt.Sprintf("%x", b), nil
 }
 
+// apiKeyPrefixLength 密钥可见前缀长度
+const apiKeyPrefixLength = 8
+
+// apiKeyColumns 查询API密钥时使用的列，与 scanAPIKey 的字段顺序保持一致
+const apiKeyColumns = "id, key_hash, key_prefix, prev_key_hash, prev_key_expires_at, name, max_usage, current_usage, is_permanent, enabled, expires_at, idle_timeout, last_used_at, scopes, allowed_paths, allowed_cidrs, quota_period, quota_anchor, quota_reset_at, rate_limit_burst, rate_limit_rate, require_signature, account_id, plan, created_at, updated_at"
+
+// HashAPIKey 计算API密钥的SHA-256摘要（十六进制）
+// 数据库和缓存中只保存摘要，不保存明文
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// DeriveSigningSecret 派生签名请求使用的密钥：HMAC-SHA256(api_key.signing_pepper, 密钥摘要)，十六进制
+// 签名密钥不保存到数据库，验证时由密钥摘要和服务器密钥重新派生；只拿到数据库而没有服务器密钥时不能伪造签名
+// 未配置 api_key.signing_pepper 时返回 ErrSigningPepperNotConfigured，不签发签名密钥
+func DeriveSigningSecret(keyHash string) (string, error) {
+	pepper := config.GetAPIKeySigningPepper()
+	if pepper == "" {
+		return "", ErrSigningPepperNotConfigured
+	}
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(keyHash))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// issuedSigningSecret 获取创建或轮换密钥时返回给调用方的签名密钥，未配置服务器密钥时为空
+func issuedSigningSecret(keyHash string) string {
+	secret, _ := DeriveSigningSecret(keyHash)
+	return secret
+}
+
+// apiKeyPrefix 获取API密钥的可见前缀，用于在列表中识别密钥
+func apiKeyPrefix(key string) string {
+	if len(key) <= apiKeyPrefixLength {
+		return key
+	}
+	return key[:apiKeyPrefixLength]
+}
+
+// encodeStringList 将字符串列表编码为JSON保存，空列表保存为空字符串
+func encodeStringList(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(list)
+	return string(data)
+}
+
+// decodeStringList 解析JSON编码的字符串列表
+func decodeStringList(data string) []string {
+	list := []string{}
+	if data != "" {
+		json.Unmarshal([]byte(data), &list)
+	}
+	return list
+}
+
+// rowScanner 兼容 *sql.Row 和 *sql.Rows 的扫描接口
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIKey 按 apiKeyColumns 的顺序扫描一条API密钥记录
+func scanAPIKey(scanner rowScanner) (*models.APIKey, error) {
+	apiKey := &models.APIKey{}
+	var prevKeyExpiresAt, expiresAt, lastUsedAt, quotaAnchor sql.NullTime
+	var scopes, allowedPaths, allowedCIDRs string
+	var quotaResetAt int64
+	err := scanner.Scan(
+		&apiKey.ID, &apiKey.KeyHash, &apiKey.KeyPrefix, &apiKey.PrevKeyHash, &prevKeyExpiresAt, &apiKey.Name, &apiKey.MaxUsage,
+		&apiKey.CurrentUsage, &apiKey.IsPermanent, &apiKey.Enabled, &expiresAt, &apiKey.IdleTimeout, &lastUsedAt,
+		&scopes, &allowedPaths, &allowedCIDRs, &apiKey.QuotaPeriod, &quotaAnchor, &quotaResetAt,
+		&apiKey.RateLimitBurst, &apiKey.RateLimitRate, &apiKey.RequireSignature, &apiKey.AccountID, &apiKey.Plan, &apiKey.CreatedAt, &apiKey.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	apiKey.Scopes = decodeStringList(scopes)
+	apiKey.AllowedPaths = decodeStringList(allowedPaths)
+	apiKey.AllowedCIDRs = decodeStringList(allowedCIDRs)
+	if quotaAnchor.Valid {
+		apiKey.QuotaAnchor = &quotaAnchor.Time
+	}
+	if quotaResetAt > 0 {
+		resetAt := time.Unix(quotaResetAt, 0)
+		apiKey.QuotaResetAt = &resetAt
+	}
+	if prevKeyExpiresAt.Valid {
+		apiKey.PrevKeyExpiresAt = &prevKeyExpiresAt.Time
+	}
+	if expiresAt.Valid {
+		apiKey.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		apiKey.LastUsedAt = &lastUsedAt.Time
+	}
+	return apiKey, nil
+}
+
 // CreateAPIKey 创建一个新的API密钥
-// name: 密钥名称
-// maxUsage: 最大使用次数，0表示无限制
-// isPermanent: 是否为永久密钥
-func CreateAPIKey(name string, maxUsage int64, isPermanent bool) (*models.APIKey, error) {
+// 返回的结构体中包含明文密钥，这是唯一一次可以获取明文的机会
+// params: 密钥属性（名称、使用上限、有效期、权限范围等），ID、密钥和时间字段由本函数生成
+func CreateAPIKey(params *models.APIKey) (*models.APIKey, error) {
 	// 生成API密钥
 	key, err := generateAPIKey()
 	if err != nil {
 		return nil, fmt.Errorf("生成API密钥失败: %v", err)
 	}
 
-	now := time.Now()
-	// 插入到数据库
+	apiKey := *params
+	apiKey.Key = key
+	apiKey.KeyHash = HashAPIKey(key)
+	apiKey.KeyPrefix = apiKeyPrefix(key)
+	apiKey.IssuedSigningSecret = issuedSigningSecret(apiKey.KeyHash)
+	apiKey.CurrentUsage = 0
+	apiKey.QuotaResetAt = nil
+	apiKey.CreatedAt = time.Now()
+	apiKey.UpdatedAt = apiKey.CreatedAt
+	if apiKey.Scopes == nil {
+		apiKey.Scopes = []string{}
+	}
+	if apiKey.AllowedPaths == nil {
+		apiKey.AllowedPaths = []string{}
+	}
+	if apiKey.AllowedCIDRs == nil {
+		apiKey.AllowedCIDRs = []string{}
+	}
+
+	// 插入到数据库（只保存摘要和前缀）
 	result, err := DB.Exec(
-		"INSERT INTO api_keys (key, name, max_usage, current_usage, is_permanent, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
-		key, name, maxUsage, 0, isPermanent, now, now,
+		"INSERT INTO api_keys (key_hash, key_prefix, name, max_usage, current_usage, is_permanent, enabled, expires_at, idle_timeout, scopes, allowed_paths, allowed_cidrs, quota_period, quota_anchor, rate_limit_burst, rate_limit_rate, require_signature, account_id, plan, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		apiKey.KeyHash, apiKey.KeyPrefix, apiKey.Name, apiKey.MaxUsage, 0, apiKey.IsPermanent, apiKey.Enabled, apiKey.ExpiresAt, apiKey.IdleTimeout,
+		encodeStringList(apiKey.Scopes), encodeStringList(apiKey.AllowedPaths), encodeStringList(apiKey.AllowedCIDRs), apiKey.QuotaPeriod, apiKey.QuotaAnchor,
+		apiKey.RateLimitBurst, apiKey.RateLimitRate, apiKey.RequireSignature, apiKey.AccountID, apiKey.Plan, apiKey.CreatedAt, apiKey.UpdatedAt,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("创建API密钥失败: %v", err)
 	}
 
 	// 获取插入的ID
-	id, err := result.LastInsertId()
+	apiKey.ID, err = result.LastInsertId()
 	if err != nil {
 		return nil, fmt.Errorf("获取API密钥ID失败: %v", err)
 	}
 
 	// 返回新创建的API密钥
-	return &models.APIKey{
-		ID:           id,
-		Key:          key,
-		Name:         name,
-		MaxUsage:     maxUsage,
-		CurrentUsage: 0,
-		IsPermanent:  isPermanent,
-		CreatedAt:    now,
-		UpdatedAt:    now,
-	}, nil
-}
-
-// GetAPIKeyByKey 通过密钥字符串获取API密钥信息
+	return &apiKey, nil
+}
+
+// GetAPIKeyByKey 通过明文密钥获取API密钥信息
 // key: API密钥字符串
 func GetAPIKeyByKey(key string) (*models.APIKey, error) {
-	apiKey := &models.APIKey{}
-	err := DB.QueryRow(
-		"SELECT id, key, name, max_usage, current_usage, is_permanent, created_at, updated_at FROM api_keys WHERE key = ?",
-		key,
-	).Scan(
-		&apiKey.ID, &apiKey.Key, &apiKey.Name, &apiKey.MaxUsage,
-		&apiKey.CurrentUsage, &apiKey.IsPermanent, &apiKey.CreatedAt, &apiKey.UpdatedAt,
-	)
+	return GetAPIKeyByHash(HashAPIKey(key))
+}
+
+// GetAPIKeyByHash 通过密钥摘要获取API密钥信息
+// 同时匹配当前密钥和轮换前的旧密钥，旧密钥是否仍在宽限期内由调用方通过 AcceptsHash 判断
+// keyHash: API密钥的SHA-256摘要
+func GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	apiKey, err := scanAPIKey(DB.QueryRow(
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ? OR prev_key_hash = ? ORDER BY key_hash = ? DESC LIMIT 1",
+		keyHash, keyHash, keyHash,
+	))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("API密钥不存在")
+			return nil, ErrAPIKeyNotFound
 		}
 		return nil, fmt.Errorf("查询API密钥失败: %v", err)
 	}
@@ -80,48 +212,195 @@ func GetAPIKeyByKey(key string) (*models.APIKey, error) {
 	return apiKey, nil
 }
 
-// UpdateAPIKeyUsage 更新API密钥使用次数
-// 使用一条UPDATE语句确保原子性，避免竞态条件
-// key: API密钥字符串
-func UpdateAPIKeyUsage(key string) error {
-	// 使用一条UPDATE语句完成检查和更新，避免竞态条件
-	result, err := DB.Exec(
-		"UPDATE api_keys SET current_usage = current_usage + 1, updated_at = ? WHERE key = ? AND (is_permanent = 1 OR current_usage < max_usage)",
-		time.Now(), key,
-	)
+// GetAPIKeyByID 通过ID获取API密钥信息
+// id: API密钥ID
+func GetAPIKeyByID(id int64) (*models.APIKey, error) {
+	apiKey, err := scanAPIKey(DB.QueryRow(
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?",
+		id,
+	))
 	if err != nil {
-		return fmt.Errorf("更新API密钥使用次数失败: %v", err)
+		if err == sql.ErrNoRows {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("查询API密钥失败: %v", err)
 	}
 
-	// 检查是否有行被更新
-	rowsAffected, err := result.RowsAffected()
+	return apiKey, nil
+}
+
+// UpdateAPIKey 部分更新API密钥属性
+// id: API密钥ID
+// update: 需要更新的字段，nil字段保持不变
+func UpdateAPIKey(id int64, update *models.APIKeyUpdate) (*models.APIKey, error) {
+	sets := []string{"updated_at = ?"}
+	args := []interface{}{time.Now()}
+
+	if update.Name != nil {
+		sets = append(sets, "name = ?")
+		args = append(args, *update.Name)
+	}
+	if update.MaxUsage != nil {
+		sets = append(sets, "max_usage = ?")
+		args = append(args, *update.MaxUsage)
+	}
+	if update.IsPermanent != nil {
+		sets = append(sets, "is_permanent = ?")
+		args = append(args, *update.IsPermanent)
+	}
+	if update.Enabled != nil {
+		sets = append(sets, "enabled = ?")
+		args = append(args, *update.Enabled)
+	}
+	if update.ClearExpiresAt {
+		sets = append(sets, "expires_at = NULL")
+	} else if update.ExpiresAt != nil {
+		sets = append(sets, "expires_at = ?")
+		args = append(args, *update.ExpiresAt)
+	}
+	if update.IdleTimeout != nil {
+		sets = append(sets, "idle_timeout = ?")
+		args = append(args, *update.IdleTimeout)
+	}
+	if update.Scopes != nil {
+		sets = append(sets, "scopes = ?")
+		args = append(args, encodeStringList(*update.Scopes))
+	}
+	if update.AllowedPaths != nil {
+		sets = append(sets, "allowed_paths = ?")
+		args = append(args, encodeStringList(*update.AllowedPaths))
+	}
+	if update.AllowedCIDRs != nil {
+		sets = append(sets, "allowed_cidrs = ?")
+		args = append(args, encodeStringList(*update.AllowedCIDRs))
+	}
+	if update.QuotaPeriod != nil || update.QuotaAnchor != nil {
+		// 周期设置变化后清空周期结束时间，下一次调用时从新周期开始计数
+		sets = append(sets, "quota_reset_at = 0")
+		if update.QuotaPeriod != nil {
+			sets = append(sets, "quota_period = ?")
+			args = append(args, *update.QuotaPeriod)
+		}
+		if update.QuotaAnchor != nil {
+			sets = append(sets, "quota_anchor = ?")
+			args = append(args, *update.QuotaAnchor)
+		}
+	}
+	if update.RateLimitBurst != nil {
+		sets = append(sets, "rate_limit_burst = ?")
+		args = append(args, *update.RateLimitBurst)
+	}
+	if update.RateLimitRate != nil {
+		sets = append(sets, "rate_limit_rate = ?")
+		args = append(args, *update.RateLimitRate)
+	}
+	if update.RequireSignature != nil {
+		sets = append(sets, "require_signature = ?")
+		args = append(args, *update.RequireSignature)
+	}
+	if update.AccountID != nil {
+		sets = append(sets, "account_id = ?")
+		args = append(args, *update.AccountID)
+	}
+	if update.Plan != nil {
+		sets = append(sets, "plan = ?")
+		args = append(args, *update.Plan)
+		// 套餐可能改变配额周期，同样从新周期开始计数
+		if update.QuotaPeriod == nil && update.QuotaAnchor == nil {
+			sets = append(sets, "quota_reset_at = 0")
+		}
+	}
+
+	args = append(args, id)
+	result, err := DB.Exec("UPDATE api_keys SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...)
 	if err != nil {
-		return fmt.Errorf("获取影响行数失败: %v", err)
+		return nil, fmt.Errorf("更新API密钥失败: %v", err)
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("获取影响行数失败: %v", err)
+	}
 	if rowsAffected == 0 {
-		// 检查是因为密钥不存在还是达到了使用上限
-		var count int
-		if err := DB.QueryRow("SELECT COUNT(*) FROM api_keys WHERE key = ?", key).Scan(&count); err != nil {
-			return fmt.Errorf("检查API密钥是否存在失败: %v", err)
-		}
+		return nil, ErrAPIKeyNotFound
+	}
+
+	return GetAPIKeyByID(id)
+}
 
-		if count == 0 {
-			return fmt.Errorf("API密钥不存在")
+// RotateAPIKey 为API密钥生成新的密钥，ID、名称、使用次数等属性保持不变
+// 返回的结构体中包含新的明文密钥。上一次轮换的旧密钥仍在宽限期内时，带宽限期的轮换返回 ErrAPIKeyRotationInGrace，
+// 避免覆盖旧密钥使其提前失效；不带宽限期的轮换总是允许，当前密钥和上一次的旧密钥都立即失效（如密钥泄露后）
+// id: API密钥ID
+// grace: 旧密钥的宽限期，宽限期内新旧密钥都可以使用；为0时旧密钥立即失效
+func RotateAPIKey(id int64, grace time.Duration) (*models.APIKey, error) {
+	current, err := GetAPIKeyByID(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if grace > 0 && current.PrevKeyHash != "" && current.PrevKeyExpiresAt != nil && now.Before(*current.PrevKeyExpiresAt) {
+		return current, ErrAPIKeyRotationInGrace
+	}
+
+	key, err := generateAPIKey()
+	if err != nil {
+		return nil, fmt.Errorf("生成API密钥失败: %v", err)
+	}
+
+	// 只在密钥未被并发轮换时更新
+	var result sql.Result
+	if grace > 0 {
+		result, err = DB.Exec(
+			"UPDATE api_keys SET prev_key_hash = key_hash, prev_key_expires_at = ?, key_hash = ?, key_prefix = ?, updated_at = ? WHERE id = ? AND key_hash = ?",
+			now.Add(grace), HashAPIKey(key), apiKeyPrefix(key), now, id, current.KeyHash,
+		)
+	} else {
+		result, err = DB.Exec(
+			"UPDATE api_keys SET prev_key_hash = '', prev_key_expires_at = NULL, key_hash = ?, key_prefix = ?, updated_at = ? WHERE id = ? AND key_hash = ?",
+			HashAPIKey(key), apiKeyPrefix(key), now, id, current.KeyHash,
+		)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("轮换API密钥失败: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("获取影响行数失败: %v", err)
+	}
+	if rowsAffected == 0 {
+		// 查询之后被删除或被并发轮换
+		if _, err := GetAPIKeyByID(id); err != nil {
+			return nil, err
 		}
-		return fmt.Errorf("API密钥已达到使用上限")
+		return nil, ErrAPIKeyRotationInGrace
 	}
 
-	return nil
+	apiKey, err := GetAPIKeyByID(id)
+	if err != nil {
+		return nil, err
+	}
+	apiKey.Key = key
+	apiKey.IssuedSigningSecret = issuedSigningSecret(apiKey.KeyHash)
+	return apiKey, nil
 }
 
 // DeleteAPIKey 删除API密钥
 // id: API密钥ID
 func DeleteAPIKey(id int64) error {
-	_, err := DB.Exec("DELETE FROM api_keys WHERE id = ?", id)
+	result, err := DB.Exec("DELETE FROM api_keys WHERE id = ?", id)
 	if err != nil {
 		return fmt.Errorf("删除API密钥失败: %v", err)
 	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("获取影响行数失败: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
 	return nil
 }
 
@@ -129,7 +408,7 @@ func DeleteAPIKey(id int64) error {
 // 按创建时间倒序排列
 func GetAllAPIKeys() ([]*models.APIKey, error) {
 	rows, err := DB.Query(
-		"SELECT id, key, name, max_usage, current_usage, is_permanent, created_at, updated_at FROM api_keys ORDER BY created_at DESC",
+		"SELECT " + apiKeyColumns + " FROM api_keys ORDER BY created_at DESC",
 	)
 	if err != nil {
 		return nil, fmt.Errorf("查询所有API密钥失败: %v", err)
@@ -138,11 +417,8 @@ func GetAllAPIKeys() ([]*models.APIKey, error) {
 
 	var apiKeys []*models.APIKey
 	for rows.Next() {
-		apiKey := &models.APIKey{}
-		if err := rows.Scan(
-			&apiKey.ID, &apiKey.Key, &apiKey.Name, &apiKey.MaxUsage,
-			&apiKey.CurrentUsage, &apiKey.IsPermanent, &apiKey.CreatedAt, &apiKey.UpdatedAt,
-		); err != nil {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
 			return nil, fmt.Errorf("扫描API密钥失败: %v", err)
 		}
 		apiKeys = append(apiKeys, apiKey)
@@ -150,3 +426,46 @@ func GetAllAPIKeys() ([]*models.APIKey, error) {
 
 	return apiKeys, nil
 }
+
+// UsageDelta 一个API密钥或账户在一次批量写入中累计的使用情况
+type UsageDelta struct {
+	ID         int64     // API密钥或账户ID
+	Count      int64     // 累计使用次数，撤销已写入的使用时为负数
+	WindowEnd  int64     // 这些调用所在配额周期的结束时间（Unix秒），终身配额为0
+	LastUsedAt time.Time // 最后一次调用时间，零值表示不修改
+}
+
+// FlushAPIKeyUsage 在一个事务中批量写入多个API密钥累计的使用次数
+// 数据库中记录的周期结束时间早于增量所在周期时，说明已进入新周期，计数从增量重新开始；
+// 周期配额的增量所在周期早于数据库中记录的周期时不再计入。使用次数不是对密钥的修改，不更新 updated_at
+// deltas: 各密钥的使用增量，同一密钥较早周期的增量应排在前面
+func FlushAPIKeyUsage(deltas []UsageDelta) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	return Transaction(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(
+			`UPDATE api_keys SET
+				current_usage = MAX(CASE WHEN quota_reset_at < ? THEN ? ELSE current_usage + ? END, 0),
+				quota_reset_at = MAX(quota_reset_at, ?),
+				last_used_at = COALESCE(?, last_used_at)
+			WHERE id = ? AND (? = 0 OR quota_reset_at <= ?)`,
+		)
+		if err != nil {
+			return fmt.Errorf("准备批量更新使用次数语句失败: %v", err)
+		}
+		defer stmt.Close()
+
+		for _, d := range deltas {
+			var lastUsedAt interface{}
+			if !d.LastUsedAt.IsZero() {
+				lastUsedAt = d.LastUsedAt
+			}
+			if _, err := stmt.Exec(d.WindowEnd, d.Count, d.Count, d.WindowEnd, lastUsedAt, d.ID, d.WindowEnd, d.WindowEnd); err != nil {
+				return fmt.Errorf("批量更新API密钥 %d 使用次数失败: %v", d.ID, err)
+			}
+		}
+		return nil
+	})
+}
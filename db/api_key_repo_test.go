@@ -0,0 +1,149 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// setupTestDB 使用临时数据库初始化配置和数据库
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	logrus.SetLevel(logrus.WarnLevel)
+
+	cfg := &config.Config{}
+	cfg.Database.Path = filepath.Join(t.TempDir(), "test.db")
+	config.GetInstance().SetConfig(cfg)
+
+	if err := InitDB(); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	t.Cleanup(func() { CloseDB() })
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	setupTestDB(t)
+	created, err := CreateAPIKey(&models.APIKey{Name: "rotate", MaxUsage: 10, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+
+	// 带宽限期轮换：新旧密钥都能查到，旧密钥在宽限期内可用
+	rotated, err := RotateAPIKey(created.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("轮换失败: %v", err)
+	}
+	if rotated.Key == created.Key || rotated.ID != created.ID || rotated.Name != created.Name {
+		t.Fatalf("轮换后期望生成新密钥并保留ID和名称，得到 %+v", rotated)
+	}
+	now := time.Now()
+	cases := []struct {
+		name     string
+		key      string
+		at       time.Time
+		accepted bool
+	}{
+		{"新密钥", rotated.Key, now, true},
+		{"宽限期内的旧密钥", created.Key, now, true},
+		{"宽限期结束后的旧密钥", created.Key, now.Add(2 * time.Hour), false},
+	}
+	for _, tc := range cases {
+		apiKey, err := GetAPIKeyByKey(tc.key)
+		if err != nil {
+			t.Fatalf("%s: 查询失败: %v", tc.name, err)
+		}
+		if accepted := apiKey.AcceptsHash(HashAPIKey(tc.key), tc.at); accepted != tc.accepted {
+			t.Errorf("%s: 期望可用=%v，得到 %v", tc.name, tc.accepted, accepted)
+		}
+	}
+
+	// 宽限期内再次轮换被拒绝，旧密钥不会被覆盖
+	current, err := RotateAPIKey(created.ID, time.Hour)
+	if !errors.Is(err, ErrAPIKeyRotationInGrace) {
+		t.Fatalf("宽限期内再次轮换期望 ErrAPIKeyRotationInGrace，得到 %v", err)
+	}
+	if current == nil || current.PrevKeyExpiresAt == nil || current.PrevKeyExpiresAt.Before(now) {
+		t.Fatalf("期望返回宽限期结束时间，得到 %+v", current)
+	}
+	if apiKey, err := GetAPIKeyByKey(created.Key); err != nil || !apiKey.AcceptsHash(HashAPIKey(created.Key), now) {
+		t.Fatalf("被拒绝的轮换不应影响旧密钥: %v", err)
+	}
+
+	// 宽限期结束后可以再次轮换，之前的旧密钥不再保留
+	if _, err := DB.Exec("UPDATE api_keys SET prev_key_expires_at = ? WHERE id = ?", now.Add(-time.Minute), created.ID); err != nil {
+		t.Fatalf("修改宽限期失败: %v", err)
+	}
+	again, err := RotateAPIKey(created.ID, 0)
+	if err != nil {
+		t.Fatalf("宽限期结束后轮换失败: %v", err)
+	}
+	if again.PrevKeyHash != "" || again.PrevKeyExpiresAt != nil {
+		t.Fatalf("不带宽限期轮换后期望没有旧密钥，得到 %+v", again)
+	}
+	for _, key := range []string{created.Key, rotated.Key} {
+		if _, err := GetAPIKeyByKey(key); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Fatalf("立即失效的旧密钥期望查询不到，得到 %v", err)
+		}
+	}
+
+	if _, err := RotateAPIKey(created.ID+100, time.Hour); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("轮换不存在的密钥期望 ErrAPIKeyNotFound，得到 %v", err)
+	}
+}
+
+func TestRotateAPIKeyDuringGraceWithoutGrace(t *testing.T) {
+	setupTestDB(t)
+	created, err := CreateAPIKey(&models.APIKey{Name: "leaked", MaxUsage: 10, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	rotated, err := RotateAPIKey(created.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("轮换失败: %v", err)
+	}
+
+	// 宽限期内发现密钥泄露，不带宽限期再次轮换，之前的两个密钥都立即失效
+	again, err := RotateAPIKey(created.ID, 0)
+	if err != nil {
+		t.Fatalf("宽限期内不带宽限期轮换期望成功，得到 %v", err)
+	}
+	if again.Key == rotated.Key || again.PrevKeyHash != "" || again.PrevKeyExpiresAt != nil {
+		t.Fatalf("期望生成新密钥并清除旧密钥，得到 %+v", again)
+	}
+	for _, key := range []string{created.Key, rotated.Key} {
+		if _, err := GetAPIKeyByKey(key); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Fatalf("泄露的密钥期望立即失效，得到 %v", err)
+		}
+	}
+	if apiKey, err := GetAPIKeyByKey(again.Key); err != nil || !apiKey.AcceptsHash(HashAPIKey(again.Key), time.Now()) {
+		t.Fatalf("新密钥期望可用: %v", err)
+	}
+}
+
+func TestDeriveSigningSecret(t *testing.T) {
+	setupTestDB(t)
+	if _, err := DeriveSigningSecret("hash"); !errors.Is(err, ErrSigningPepperNotConfigured) {
+		t.Fatalf("未配置服务器密钥期望 %v，得到 %v", ErrSigningPepperNotConfigured, err)
+	}
+
+	config.GetInstance().GetConfig().APIKey.SigningPepper = "test-pepper"
+	first, err := DeriveSigningSecret("hash")
+	if err != nil {
+		t.Fatalf("派生签名密钥失败: %v", err)
+	}
+	if second, _ := DeriveSigningSecret("hash"); second != first {
+		t.Fatalf("同一摘要期望派生相同的签名密钥，得到 %s %s", first, second)
+	}
+	if other, _ := DeriveSigningSecret("other"); other == first {
+		t.Fatal("不同摘要期望派生不同的签名密钥")
+	}
+	config.GetInstance().GetConfig().APIKey.SigningPepper = "other-pepper"
+	if changed, _ := DeriveSigningSecret("hash"); changed == first {
+		t.Fatal("服务器密钥不同期望派生不同的签名密钥")
+	}
+}
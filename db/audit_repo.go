@@ -0,0 +1,107 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// CreateAuditLog 写入一条审计日志
+// 时间以Unix秒保存，便于按时间范围查询
+// entry: 审计日志，ID由本函数生成
+func CreateAuditLog(entry *models.AuditLog) error {
+	result, err := DB.Exec(
+		"INSERT INTO audit_log (actor, action, target_type, target_id, before_json, after_json, client_ip, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.Actor, entry.Action, entry.TargetType, entry.TargetID, string(entry.Before), string(entry.After), entry.ClientIP, entry.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("写入审计日志失败: %v", err)
+	}
+
+	entry.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("获取审计日志ID失败: %v", err)
+	}
+	return nil
+}
+
+// GetAuditLogs 按条件分页查询审计日志，按时间倒序排列，同时返回符合条件的总数
+func GetAuditLogs(filter models.AuditLogFilter) ([]*models.AuditLog, int64, error) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		if strings.HasSuffix(filter.Action, ".") {
+			conditions = append(conditions, "substr(action, 1, ?) = ?")
+			args = append(args, len(filter.Action), filter.Action)
+		} else {
+			conditions = append(conditions, "action = ?")
+			args = append(args, filter.Action)
+		}
+	}
+	if filter.TargetType != "" {
+		conditions = append(conditions, "target_type = ?")
+		args = append(args, filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		conditions = append(conditions, "target_id = ?")
+		args = append(args, filter.TargetID)
+	}
+	if filter.Since != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since.Unix())
+	}
+	if filter.Until != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.Until.Unix())
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int64
+	if err := DB.QueryRow("SELECT COUNT(*) FROM audit_log"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("统计审计日志失败: %v", err)
+	}
+
+	rows, err := DB.Query(
+		"SELECT id, actor, action, target_type, target_id, before_json, after_json, client_ip, created_at FROM audit_log"+where+" ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?",
+		append(args, filter.Limit, filter.Offset)...,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("查询审计日志失败: %v", err)
+	}
+	defer rows.Close()
+
+	logs := []*models.AuditLog{}
+	for rows.Next() {
+		entry := &models.AuditLog{}
+		var before, after string
+		var createdAt int64
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.TargetType, &entry.TargetID, &before, &after, &entry.ClientIP, &createdAt); err != nil {
+			return nil, 0, fmt.Errorf("扫描审计日志失败: %v", err)
+		}
+		entry.CreatedAt = time.Unix(createdAt, 0)
+		entry.Before = rawJSON(before)
+		entry.After = rawJSON(after)
+		logs = append(logs, entry)
+	}
+
+	return logs, total, nil
+}
+
+// rawJSON 将数据库中保存的JSON文本转换为 json.RawMessage，空字符串转换为 null
+func rawJSON(value string) []byte {
+	if value == "" {
+		return []byte("null")
+	}
+	return []byte(value)
+}
@@ -95,6 +95,17 @@ func createTables() error {
 			UNIQUE(ip)
 		);
 		`,
+		// 调用方统计表
+		`
+		CREATE TABLE IF NOT EXISTS principal_calls (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			principal TEXT NOT NULL,
+			count INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(principal)
+		);
+		`,
 		// API调用详情表
 		`
 		CREATE TABLE IF NOT EXISTS call_details (
@@ -104,18 +115,77 @@ func createTables() error {
 			ip TEXT NOT NULL,
 			timestamp DATETIME NOT NULL,
 			status_code INTEGER NOT NULL,
+			principal TEXT NOT NULL DEFAULT '',
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		);
 		`,
 		// API密钥表
+		fmt.Sprintf(apiKeysTableSchema, "api_keys"),
+		// 客户账户表
 		`
-		CREATE TABLE IF NOT EXISTS api_keys (
+		CREATE TABLE IF NOT EXISTS accounts (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			key TEXT NOT NULL UNIQUE,
 			name TEXT NOT NULL,
+			contact TEXT NOT NULL DEFAULT '',
+			plan TEXT NOT NULL DEFAULT '',
+			notes TEXT NOT NULL DEFAULT '',
 			max_usage INTEGER NOT NULL DEFAULT 0,
 			current_usage INTEGER NOT NULL DEFAULT 0,
-			is_permanent BOOLEAN NOT NULL DEFAULT 0,
+			quota_period TEXT NOT NULL DEFAULT '',
+			quota_reset_at INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		`,
+		// Webhook投递记录表
+		`
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event_id TEXT NOT NULL,
+			event TEXT NOT NULL,
+			url TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			response_code INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			next_attempt_at INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		`,
+		// 管理操作审计日志表
+		`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target_type TEXT NOT NULL DEFAULT '',
+			target_id TEXT NOT NULL DEFAULT '',
+			before_json TEXT NOT NULL DEFAULT '',
+			after_json TEXT NOT NULL DEFAULT '',
+			client_ip TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL
+		);
+		`,
+		// IP封禁表，banned_until 为Unix时间戳（秒）
+		`
+		CREATE TABLE IF NOT EXISTS ip_bans (
+			ip TEXT PRIMARY KEY,
+			level INTEGER NOT NULL DEFAULT 1,
+			failures INTEGER NOT NULL DEFAULT 0,
+			banned_until INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		);
+		`,
+		// 管理员账号表
+		`
+		CREATE TABLE IF NOT EXISTS admin_users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			session_generation INTEGER NOT NULL DEFAULT 0,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		);
@@ -129,13 +199,24 @@ func createTables() error {
 		}
 	}
 
+	// 执行数据迁移，升级旧版本的表结构
+	if err := migrateTables(); err != nil {
+		return err
+	}
+
 	// 创建索引以提高查询性能
 	indexSQLs := []string{
 		"CREATE INDEX IF NOT EXISTS idx_call_details_timestamp ON call_details(timestamp DESC);",
 		"CREATE INDEX IF NOT EXISTS idx_call_details_path ON call_details(path);",
 		"CREATE INDEX IF NOT EXISTS idx_call_details_method ON call_details(method);",
 		"CREATE INDEX IF NOT EXISTS idx_call_details_status ON call_details(status_code);",
-		"CREATE INDEX IF NOT EXISTS idx_api_keys_key ON api_keys(key);",
+		"CREATE INDEX IF NOT EXISTS idx_api_keys_key_hash ON api_keys(key_hash);",
+		"CREATE INDEX IF NOT EXISTS idx_api_keys_prev_key_hash ON api_keys(prev_key_hash);",
+		"CREATE INDEX IF NOT EXISTS idx_api_keys_account_id ON api_keys(account_id);",
+		"CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status ON webhook_deliveries(status, next_attempt_at);",
+		"CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at DESC);",
+		"CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_type, target_id);",
+		"CREATE INDEX IF NOT EXISTS idx_ip_bans_banned_until ON ip_bans(banned_until);",
 		"CREATE INDEX IF NOT EXISTS idx_ip_calls_ip ON ip_calls(ip);",
 		"CREATE INDEX IF NOT EXISTS idx_path_calls_path ON path_calls(path);",
 		"CREATE INDEX IF NOT EXISTS idx_method_calls_method ON method_calls(method);",
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// ErrIPBanNotFound IP封禁记录不存在
+var ErrIPBanNotFound = errors.New("IP封禁记录不存在")
+
+// ipBanColumns 查询封禁记录时使用的列，与 scanIPBan 的字段顺序保持一致
+const ipBanColumns = "ip, level, failures, banned_until, created_at, updated_at"
+
+// scanIPBan 按 ipBanColumns 的顺序扫描一条封禁记录
+func scanIPBan(scanner rowScanner) (*models.IPBan, error) {
+	ban := &models.IPBan{}
+	var bannedUntil int64
+	err := scanner.Scan(&ban.IP, &ban.Level, &ban.Failures, &bannedUntil, &ban.CreatedAt, &ban.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	ban.BannedUntil = time.Unix(bannedUntil, 0)
+	return ban, nil
+}
+
+// GetIPBan 获取IP的封禁记录，包括已经结束的封禁
+// ip: 客户端IP
+func GetIPBan(ip string) (*models.IPBan, error) {
+	ban, err := scanIPBan(DB.QueryRow("SELECT "+ipBanColumns+" FROM ip_bans WHERE ip = ?", ip))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrIPBanNotFound
+		}
+		return nil, fmt.Errorf("查询IP封禁记录失败: %v", err)
+	}
+	return ban, nil
+}
+
+// GetIPBans 获取封禁记录，按最近一次封禁时间倒序排列
+// activeOnly: 为true时只返回在 now 时仍然有效的封禁
+func GetIPBans(activeOnly bool, now time.Time) ([]*models.IPBan, error) {
+	query := "SELECT " + ipBanColumns + " FROM ip_bans"
+	var args []interface{}
+	if activeOnly {
+		query += " WHERE banned_until > ?"
+		args = append(args, now.Unix())
+	}
+	query += " ORDER BY updated_at DESC"
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询IP封禁记录失败: %v", err)
+	}
+	defer rows.Close()
+
+	var bans []*models.IPBan
+	for rows.Next() {
+		ban, err := scanIPBan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("扫描IP封禁记录失败: %v", err)
+		}
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
+
+// SaveIPBan 保存封禁记录，IP已有记录时覆盖（保留首次封禁时间）
+func SaveIPBan(ban *models.IPBan) error {
+	_, err := DB.Exec(
+		`INSERT INTO ip_bans (ip, level, failures, banned_until, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(ip) DO UPDATE SET
+			level = excluded.level,
+			failures = excluded.failures,
+			banned_until = excluded.banned_until,
+			updated_at = excluded.updated_at`,
+		ban.IP, ban.Level, ban.Failures, ban.BannedUntil.Unix(), ban.CreatedAt, ban.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("保存IP封禁记录失败: %v", err)
+	}
+	return nil
+}
+
+// DeleteIPBan 删除IP的封禁记录，再次被封禁时从首次封禁的时长重新开始
+// ip: 客户端IP
+func DeleteIPBan(ip string) error {
+	result, err := DB.Exec("DELETE FROM ip_bans WHERE ip = ?", ip)
+	if err != nil {
+		return fmt.Errorf("删除IP封禁记录失败: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("获取影响行数失败: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrIPBanNotFound
+	}
+	return nil
+}
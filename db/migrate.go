@@ -0,0 +1,300 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// apiKeysTableSchema API密钥表结构，%s 为表名（迁移时用于创建临时表）
+// 密钥只保存SHA-256摘要和用于识别的前缀，明文仅在创建时返回一次
+const apiKeysTableSchema = `
+		CREATE TABLE IF NOT EXISTS %s (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			key_hash TEXT NOT NULL UNIQUE,
+			key_prefix TEXT NOT NULL DEFAULT '',
+			prev_key_hash TEXT NOT NULL DEFAULT '',
+			prev_key_expires_at DATETIME,
+			name TEXT NOT NULL,
+			max_usage INTEGER NOT NULL DEFAULT 0,
+			current_usage INTEGER NOT NULL DEFAULT 0,
+			is_permanent BOOLEAN NOT NULL DEFAULT 0,
+			enabled BOOLEAN NOT NULL DEFAULT 1,
+			expires_at DATETIME,
+			idle_timeout INTEGER NOT NULL DEFAULT 0,
+			last_used_at DATETIME,
+			scopes TEXT NOT NULL DEFAULT '',
+			allowed_paths TEXT NOT NULL DEFAULT '',
+			allowed_cidrs TEXT NOT NULL DEFAULT '',
+			quota_period TEXT NOT NULL DEFAULT '',
+			quota_anchor DATETIME,
+			quota_reset_at INTEGER NOT NULL DEFAULT 0,
+			rate_limit_burst INTEGER NOT NULL DEFAULT 0,
+			rate_limit_rate REAL NOT NULL DEFAULT 0,
+			require_signature BOOLEAN NOT NULL DEFAULT 0,
+			account_id INTEGER NOT NULL DEFAULT 0,
+			plan TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		`
+
+// migrateTables 依次执行所有数据迁移
+// 每个迁移都需要是幂等的，可以在每次启动时重复执行
+func migrateTables() error {
+	migrations := []struct {
+		name string
+		fn   func() error
+	}{
+		{"api_keys明文密钥转为哈希存储", migrateAPIKeyHashes},
+		{"api_keys增加过期时间字段", migrateAPIKeyExpiry},
+		{"api_keys增加权限范围字段", migrateAPIKeyScopes},
+		{"api_keys增加周期配额字段", migrateAPIKeyQuotaPeriod},
+		{"api_keys增加速率限制字段", migrateAPIKeyRateLimit},
+		{"api_keys增加启用状态字段", migrateAPIKeyEnabled},
+		{"api_keys增加轮换前密钥字段", migrateAPIKeyRotation},
+		{"api_keys增加IP网段限制字段", migrateAPIKeyAllowedCIDRs},
+		{"api_keys增加签名要求字段", migrateAPIKeyRequireSignature},
+		{"api_keys增加所属账户字段", migrateAPIKeyAccount},
+		{"api_keys增加套餐字段", migrateAPIKeyPlan},
+		{"call_details增加调用方字段", migrateCallDetailPrincipal},
+		{"admin_users增加会话版本字段", migrateAdminSessionGeneration},
+		{"api_keys删除保存的签名密钥字段", migrateDropAPIKeySigningSecret},
+	}
+
+	for _, m := range migrations {
+		if err := m.fn(); err != nil {
+			return fmt.Errorf("数据迁移[%s]失败: %v", m.name, err)
+		}
+	}
+
+	return nil
+}
+
+// columnExists 检查表中是否存在指定列
+func columnExists(table, column string) (bool, error) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, fmt.Errorf("查询表结构失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return false, fmt.Errorf("扫描表结构失败: %v", err)
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
+
+// addColumnIfNotExists 列不存在时为表添加列
+func addColumnIfNotExists(table, column, definition string) error {
+	exists, err := columnExists(table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	if _, err := DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("添加列 %s.%s 失败: %v", table, column, err)
+	}
+
+	logrus.Infof("已为表 %s 添加列 %s", table, column)
+	return nil
+}
+
+// migrateAPIKeyHashes 将旧版本明文保存的API密钥迁移为哈希存储
+// 旧表包含 key 列，迁移时重建表结构，只保留摘要和前缀
+func migrateAPIKeyHashes() error {
+	hasPlainKey, err := columnExists("api_keys", "key")
+	if err != nil || !hasPlainKey {
+		return err
+	}
+
+	logrus.Info("检测到明文保存的API密钥，开始迁移为哈希存储")
+
+	type legacyKey struct {
+		id           int64
+		key          string
+		name         string
+		maxUsage     int64
+		currentUsage int64
+		isPermanent  bool
+		createdAt    time.Time
+		updatedAt    time.Time
+	}
+
+	var migrated int
+	err = Transaction(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id, key, name, max_usage, current_usage, is_permanent, created_at, updated_at FROM api_keys")
+		if err != nil {
+			return fmt.Errorf("读取旧API密钥失败: %v", err)
+		}
+
+		var keys []legacyKey
+		for rows.Next() {
+			var k legacyKey
+			if err := rows.Scan(&k.id, &k.key, &k.name, &k.maxUsage, &k.currentUsage, &k.isPermanent, &k.createdAt, &k.updatedAt); err != nil {
+				rows.Close()
+				return fmt.Errorf("扫描旧API密钥失败: %v", err)
+			}
+			keys = append(keys, k)
+		}
+		rows.Close()
+
+		if _, err := tx.Exec(fmt.Sprintf(apiKeysTableSchema, "api_keys_new")); err != nil {
+			return fmt.Errorf("创建新API密钥表失败: %v", err)
+		}
+
+		for _, k := range keys {
+			if _, err := tx.Exec(
+				"INSERT INTO api_keys_new (id, key_hash, key_prefix, name, max_usage, current_usage, is_permanent, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				k.id, HashAPIKey(k.key), apiKeyPrefix(k.key), k.name, k.maxUsage, k.currentUsage, k.isPermanent, k.createdAt, k.updatedAt,
+			); err != nil {
+				return fmt.Errorf("写入迁移后的API密钥失败: %v", err)
+			}
+		}
+
+		if _, err := tx.Exec("DROP TABLE api_keys"); err != nil {
+			return fmt.Errorf("删除旧API密钥表失败: %v", err)
+		}
+		if _, err := tx.Exec("ALTER TABLE api_keys_new RENAME TO api_keys"); err != nil {
+			return fmt.Errorf("重命名API密钥表失败: %v", err)
+		}
+
+		migrated = len(keys)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("API密钥哈希迁移完成，共迁移 %d 条记录", migrated)
+	return nil
+}
+
+// columnDef 迁移时需要添加的列定义
+type columnDef struct {
+	name       string
+	definition string
+}
+
+// addColumnsIfNotExist 批量为表添加不存在的列
+func addColumnsIfNotExist(table string, columns []columnDef) error {
+	for _, col := range columns {
+		if err := addColumnIfNotExists(table, col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateAPIKeyExpiry 为API密钥表添加绝对过期和闲置过期相关字段
+func migrateAPIKeyExpiry() error {
+	return addColumnsIfNotExist("api_keys", []columnDef{
+		{"expires_at", "DATETIME"},
+		{"idle_timeout", "INTEGER NOT NULL DEFAULT 0"},
+		{"last_used_at", "DATETIME"},
+	})
+}
+
+// migrateAPIKeyScopes 为API密钥表添加插件和路径权限范围字段
+func migrateAPIKeyScopes() error {
+	return addColumnsIfNotExist("api_keys", []columnDef{
+		{"scopes", "TEXT NOT NULL DEFAULT ''"},
+		{"allowed_paths", "TEXT NOT NULL DEFAULT ''"},
+	})
+}
+
+// migrateAPIKeyQuotaPeriod 为API密钥表添加周期配额字段
+// quota_reset_at 以Unix时间戳保存，便于在SQL中直接比较
+func migrateAPIKeyQuotaPeriod() error {
+	return addColumnsIfNotExist("api_keys", []columnDef{
+		{"quota_period", "TEXT NOT NULL DEFAULT ''"},
+		{"quota_anchor", "DATETIME"},
+		{"quota_reset_at", "INTEGER NOT NULL DEFAULT 0"},
+	})
+}
+
+// migrateAPIKeyRateLimit 为API密钥表添加按密钥的速率限制字段
+func migrateAPIKeyRateLimit() error {
+	return addColumnsIfNotExist("api_keys", []columnDef{
+		{"rate_limit_burst", "INTEGER NOT NULL DEFAULT 0"},
+		{"rate_limit_rate", "REAL NOT NULL DEFAULT 0"},
+	})
+}
+
+// migrateAPIKeyEnabled 为API密钥表添加启用状态字段，已有密钥默认启用
+func migrateAPIKeyEnabled() error {
+	return addColumnIfNotExists("api_keys", "enabled", "BOOLEAN NOT NULL DEFAULT 1")
+}
+
+// migrateAPIKeyRotation 为API密钥表添加轮换前的旧密钥摘要和宽限期字段
+func migrateAPIKeyRotation() error {
+	return addColumnsIfNotExist("api_keys", []columnDef{
+		{"prev_key_hash", "TEXT NOT NULL DEFAULT ''"},
+		{"prev_key_expires_at", "DATETIME"},
+	})
+}
+
+// migrateAPIKeyAllowedCIDRs 为API密钥表添加客户端IP网段限制字段
+func migrateAPIKeyAllowedCIDRs() error {
+	return addColumnIfNotExists("api_keys", "allowed_cidrs", "TEXT NOT NULL DEFAULT ''")
+}
+
+// migrateAPIKeyRequireSignature 为API密钥表添加是否强制签名请求字段，已有密钥默认不强制
+func migrateAPIKeyRequireSignature() error {
+	return addColumnIfNotExists("api_keys", "require_signature", "BOOLEAN NOT NULL DEFAULT 0")
+}
+
+// migrateAPIKeyAccount 为API密钥表添加所属账户字段，已有密钥不属于任何账户
+func migrateAPIKeyAccount() error {
+	return addColumnIfNotExists("api_keys", "account_id", "INTEGER NOT NULL DEFAULT 0")
+}
+
+// migrateAPIKeyPlan 为API密钥表添加套餐字段，已有密钥不使用套餐
+func migrateAPIKeyPlan() error {
+	return addColumnIfNotExists("api_keys", "plan", "TEXT NOT NULL DEFAULT ''")
+}
+
+// migrateCallDetailPrincipal 为调用详情表添加调用方字段，已有记录的调用方为空
+func migrateCallDetailPrincipal() error {
+	return addColumnIfNotExists("call_details", "principal", "TEXT NOT NULL DEFAULT ''")
+}
+
+// migrateAdminSessionGeneration 为管理员表添加会话版本字段，修改密码或退出登录时递增，使已签发的会话失效
+func migrateAdminSessionGeneration() error {
+	return addColumnIfNotExists("admin_users", "session_generation", "INTEGER NOT NULL DEFAULT 0")
+}
+
+// migrateDropAPIKeySigningSecret 删除之前版本保存的签名密钥字段
+// 签名密钥改为验证时由密钥摘要和服务器密钥派生，数据库中不再保存，包括迁移的旧密钥在内的所有密钥都可以直接发送签名请求
+func migrateDropAPIKeySigningSecret() error {
+	for _, column := range []string{"signing_secret", "prev_signing_secret"} {
+		exists, err := columnExists("api_keys", column)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if _, err := DB.Exec(fmt.Sprintf("ALTER TABLE api_keys DROP COLUMN %s", column)); err != nil {
+			return fmt.Errorf("删除列 api_keys.%s 失败: %v", column, err)
+		}
+		logrus.Infof("已删除表 api_keys 的列 %s", column)
+	}
+	return nil
+}
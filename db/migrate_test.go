@@ -0,0 +1,141 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+func TestMigrateAPIKeyHashes(t *testing.T) {
+	logrus.SetLevel(logrus.WarnLevel)
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+	// 旧版本的表结构，密钥明文保存在 key 列中
+	legacy, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	createdAt := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	if _, err := legacy.Exec(`
+		CREATE TABLE api_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			key TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			max_usage INTEGER NOT NULL DEFAULT 0,
+			current_usage INTEGER NOT NULL DEFAULT 0,
+			is_permanent BOOLEAN NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`); err != nil {
+		t.Fatalf("创建旧表失败: %v", err)
+	}
+	const legacyKey = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	if _, err := legacy.Exec(
+		"INSERT INTO api_keys (id, key, name, max_usage, current_usage, is_permanent, created_at, updated_at) VALUES (7, ?, 'legacy', 100, 42, 0, ?, ?)",
+		legacyKey, createdAt, createdAt,
+	); err != nil {
+		t.Fatalf("写入旧密钥失败: %v", err)
+	}
+	legacy.Close()
+
+	cfg := &config.Config{}
+	cfg.Database.Path = dbPath
+	config.GetInstance().SetConfig(cfg)
+	if err := InitDB(); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	t.Cleanup(func() { CloseDB() })
+
+	// 明文列被移除，密钥保留ID、使用情况，并可通过明文查到
+	if exists, err := columnExists("api_keys", "key"); err != nil || exists {
+		t.Fatalf("迁移后期望移除明文列，得到 %v %v", exists, err)
+	}
+	apiKey, err := GetAPIKeyByKey(legacyKey)
+	if err != nil {
+		t.Fatalf("迁移后查询密钥失败: %v", err)
+	}
+	if apiKey.ID != 7 || apiKey.Name != "legacy" || apiKey.CurrentUsage != 42 || apiKey.MaxUsage != 100 {
+		t.Fatalf("迁移后密钥信息不一致: %+v", apiKey)
+	}
+	if apiKey.KeyHash != HashAPIKey(legacyKey) || apiKey.KeyPrefix != legacyKey[:apiKeyPrefixLength] || apiKey.Key != "" {
+		t.Fatalf("迁移后期望只保存摘要和前缀，得到 %+v", apiKey)
+	}
+	if !apiKey.Enabled {
+		t.Fatalf("迁移后期望启用，得到 %+v", apiKey)
+	}
+	// 签名密钥由摘要派生，迁移的密钥不需要轮换即可发送签名请求
+	cfg.APIKey.SigningPepper = "test-pepper"
+	if secret, err := DeriveSigningSecret(apiKey.KeyHash); err != nil || secret == "" {
+		t.Fatalf("迁移后期望可以派生签名密钥，得到 %q %v", secret, err)
+	}
+
+	// 迁移可以重复执行
+	if err := migrateTables(); err != nil {
+		t.Fatalf("重复迁移失败: %v", err)
+	}
+}
+
+func TestCreateAPIKeyStoresHash(t *testing.T) {
+	setupTestDB(t)
+	created, err := CreateAPIKey(&models.APIKey{Name: "hashed", MaxUsage: 10, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	if len(created.Key) != 64 || created.KeyHash != HashAPIKey(created.Key) || created.KeyPrefix != created.Key[:apiKeyPrefixLength] {
+		t.Fatalf("创建的密钥格式不正确: %+v", created)
+	}
+
+	// 数据库中没有明文，只能通过明文的摘要查到
+	var stored string
+	if err := DB.QueryRow("SELECT key_hash FROM api_keys WHERE id = ?", created.ID).Scan(&stored); err != nil {
+		t.Fatalf("查询密钥摘要失败: %v", err)
+	}
+	if stored == created.Key || stored != created.KeyHash {
+		t.Fatalf("期望只保存摘要，得到 %s", stored)
+	}
+	byID, err := GetAPIKeyByID(created.ID)
+	if err != nil {
+		t.Fatalf("查询密钥失败: %v", err)
+	}
+	if byID.Key != "" || byID.IssuedSigningSecret != "" {
+		t.Fatalf("查询结果不应包含明文密钥或签名密钥: %+v", byID)
+	}
+	if _, err := GetAPIKeyByKey(created.Key); err != nil {
+		t.Fatalf("通过明文查询失败: %v", err)
+	}
+	if _, err := GetAPIKeyByKey(created.KeyHash); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("使用摘要作为密钥期望 ErrAPIKeyNotFound，得到 %v", err)
+	}
+}
+
+func TestMigrateDropAPIKeySigningSecret(t *testing.T) {
+	setupTestDB(t)
+	// 之前版本在数据库中保存了签名密钥
+	for _, column := range []string{"signing_secret", "prev_signing_secret"} {
+		if err := addColumnIfNotExists("api_keys", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			t.Fatalf("添加列失败: %v", err)
+		}
+	}
+	created, err := CreateAPIKey(&models.APIKey{Name: "stored", MaxUsage: 10, Enabled: true})
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+
+	if err := migrateTables(); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	for _, column := range []string{"signing_secret", "prev_signing_secret"} {
+		if exists, err := columnExists("api_keys", column); err != nil || exists {
+			t.Fatalf("迁移后期望删除列 %s，得到 %v %v", column, exists, err)
+		}
+	}
+	if _, err := GetAPIKeyByKey(created.Key); err != nil {
+		t.Fatalf("迁移后查询密钥失败: %v", err)
+	}
+}
@@ -12,9 +12,10 @@ import (
 // LoadStats 从数据库加载统计信息
 func LoadStats() (*models.Stats, error) {
 	stats := &models.Stats{
-		MethodCalls: make(map[string]int64),
-		PathCalls:   make(map[string]int64),
-		IPCalls:     make(map[string]int64),
+		MethodCalls:    make(map[string]int64),
+		PathCalls:      make(map[string]int64),
+		IPCalls:        make(map[string]int64),
+		PrincipalCalls: make(map[string]int64),
 	}
 
 	// 加载基本统计信息
@@ -79,9 +80,25 @@ func LoadStats() (*models.Stats, error) {
 		stats.IPCalls[ip] = count
 	}
 
+	// 加载调用方统计
+	rows, err = DB.Query("SELECT principal, count FROM principal_calls")
+	if err != nil {
+		return nil, fmt.Errorf("加载调用方统计失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var principal string
+		var count int64
+		if scanErr := rows.Scan(&principal, &count); scanErr != nil {
+			return nil, fmt.Errorf("扫描调用方统计失败: %v", scanErr)
+		}
+		stats.PrincipalCalls[principal] = count
+	}
+
 	// 加载最近的调用详情（最多100条）
 	rows, err = DB.Query(
-		"SELECT path, method, ip, timestamp, status_code FROM call_details ORDER BY timestamp DESC LIMIT 100",
+		"SELECT path, method, ip, timestamp, status_code, principal FROM call_details ORDER BY timestamp DESC LIMIT 100",
 	)
 	if err != nil {
 		return nil, fmt.Errorf("加载调用详情失败: %v", err)
@@ -91,7 +108,7 @@ func LoadStats() (*models.Stats, error) {
 	details := make([]*models.CallDetail, 0, 100)
 	for rows.Next() {
 		var detail models.CallDetail
-		if scanErr := rows.Scan(&detail.Path, &detail.Method, &detail.IP, &detail.Timestamp, &detail.StatusCode); scanErr != nil {
+		if scanErr := rows.Scan(&detail.Path, &detail.Method, &detail.IP, &detail.Timestamp, &detail.StatusCode, &detail.Principal); scanErr != nil {
 			return nil, fmt.Errorf("扫描调用详情失败: %v", scanErr)
 		}
 		details = append(details, &detail)
@@ -163,6 +180,17 @@ func SaveStats(stats *models.Stats) error {
 		}
 	}
 
+	// 保存调用方统计
+	for principal, count := range stats.PrincipalCalls {
+		_, err = tx.Exec(
+			"INSERT OR REPLACE INTO principal_calls (principal, count, updated_at) VALUES (?, ?, ?)",
+			principal, count, time.Now(),
+		)
+		if err != nil {
+			return fmt.Errorf("保存调用方统计失败: %v", err)
+		}
+	}
+
 	// 提交事务
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("提交事务失败: %v", err)
@@ -194,7 +222,7 @@ func SaveCallDetailsBatch(details []*models.CallDetail) error {
 	}()
 
 	// 准备插入语句
-	stmt, err := tx.Prepare("INSERT INTO call_details (path, method, ip, timestamp, status_code) VALUES (?, ?, ?, ?, ?)")
+	stmt, err := tx.Prepare("INSERT INTO call_details (path, method, ip, timestamp, status_code, principal) VALUES (?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return fmt.Errorf("准备插入语句失败: %v", err)
 	}
@@ -202,7 +230,7 @@ func SaveCallDetailsBatch(details []*models.CallDetail) error {
 
 	// 批量插入数据
 	for _, detail := range details {
-		_, err = stmt.Exec(detail.Path, detail.Method, detail.IP, detail.Timestamp, detail.StatusCode)
+		_, err = stmt.Exec(detail.Path, detail.Method, detail.IP, detail.Timestamp, detail.StatusCode, detail.Principal)
 		if err != nil {
 			return fmt.Errorf("插入调用详情失败: %v", err)
 		}
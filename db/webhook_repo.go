@@ -0,0 +1,156 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// ErrWebhookDeliveryNotFound Webhook投递记录不存在
+var ErrWebhookDeliveryNotFound = errors.New("Webhook投递记录不存在")
+
+// webhookDeliveryColumns 查询投递记录时使用的列，与 scanWebhookDelivery 的字段顺序保持一致
+const webhookDeliveryColumns = "id, event_id, event, url, payload, status, attempts, response_code, last_error, next_attempt_at, created_at, updated_at"
+
+// scanWebhookDelivery 按 webhookDeliveryColumns 的顺序扫描一条投递记录
+func scanWebhookDelivery(scanner rowScanner) (*models.WebhookDelivery, error) {
+	delivery := &models.WebhookDelivery{}
+	var nextAttemptAt int64
+	err := scanner.Scan(
+		&delivery.ID, &delivery.EventID, &delivery.Event, &delivery.URL, &delivery.Payload, &delivery.Status,
+		&delivery.Attempts, &delivery.ResponseCode, &delivery.LastError, &nextAttemptAt, &delivery.CreatedAt, &delivery.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if nextAttemptAt > 0 {
+		next := time.Unix(nextAttemptAt, 0)
+		delivery.NextAttemptAt = &next
+	}
+	return delivery, nil
+}
+
+// queryWebhookDeliveries 执行查询并扫描所有投递记录
+func queryWebhookDeliveries(query string, args ...interface{}) ([]*models.WebhookDelivery, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询Webhook投递记录失败: %v", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*models.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("扫描Webhook投递记录失败: %v", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// CreateWebhookDeliveries 在一个事务中保存同一事件的多条待投递记录
+func CreateWebhookDeliveries(deliveries []*models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return Transaction(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(
+			"INSERT INTO webhook_deliveries (event_id, event, url, payload, status, next_attempt_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		)
+		if err != nil {
+			return fmt.Errorf("准备写入Webhook投递记录语句失败: %v", err)
+		}
+		defer stmt.Close()
+
+		now := time.Now()
+		for _, d := range deliveries {
+			result, err := stmt.Exec(d.EventID, d.Event, d.URL, d.Payload, models.WebhookStatusPending, now.Unix(), now, now)
+			if err != nil {
+				return fmt.Errorf("写入Webhook投递记录失败: %v", err)
+			}
+			if d.ID, err = result.LastInsertId(); err != nil {
+				return fmt.Errorf("获取Webhook投递记录ID失败: %v", err)
+			}
+			d.Status = models.WebhookStatusPending
+			d.NextAttemptAt = &now
+			d.CreatedAt, d.UpdatedAt = now, now
+		}
+		return nil
+	})
+}
+
+// GetDueWebhookDeliveries 获取已到投递时间的待投递记录，按投递时间先后排列
+// now: 当前时间
+// limit: 最多返回的记录数
+func GetDueWebhookDeliveries(now time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	return queryWebhookDeliveries(
+		"SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?",
+		models.WebhookStatusPending, now.Unix(), limit,
+	)
+}
+
+// GetWebhookDeliveries 分页获取投递记录，按ID倒序排列
+// status: 只返回该状态的记录，为空表示全部
+func GetWebhookDeliveries(status string, limit, offset int) ([]*models.WebhookDelivery, error) {
+	if status == "" {
+		return queryWebhookDeliveries(
+			"SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries ORDER BY id DESC LIMIT ? OFFSET ?",
+			limit, offset,
+		)
+	}
+	return queryWebhookDeliveries(
+		"SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE status = ? ORDER BY id DESC LIMIT ? OFFSET ?",
+		status, limit, offset,
+	)
+}
+
+// UpdateWebhookDeliveryResult 保存一次投递的结果
+// delivery: 投递记录，Status、Attempts、ResponseCode、LastError、NextAttemptAt 为本次投递后的值
+func UpdateWebhookDeliveryResult(delivery *models.WebhookDelivery) error {
+	var nextAttemptAt int64
+	if delivery.NextAttemptAt != nil {
+		nextAttemptAt = delivery.NextAttemptAt.Unix()
+	}
+	delivery.UpdatedAt = time.Now()
+
+	_, err := DB.Exec(
+		"UPDATE webhook_deliveries SET status = ?, attempts = ?, response_code = ?, last_error = ?, next_attempt_at = ?, updated_at = ? WHERE id = ?",
+		delivery.Status, delivery.Attempts, delivery.ResponseCode, delivery.LastError, nextAttemptAt, delivery.UpdatedAt, delivery.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("更新Webhook投递记录失败: %v", err)
+	}
+	return nil
+}
+
+// RetryWebhookDelivery 将投递记录重新设为待投递，立即重新投递并重新计算投递次数
+// id: 投递记录ID
+func RetryWebhookDelivery(id int64) (*models.WebhookDelivery, error) {
+	now := time.Now()
+	result, err := DB.Exec(
+		"UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ?, updated_at = ? WHERE id = ?",
+		models.WebhookStatusPending, now.Unix(), now, id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("重新投递Webhook失败: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("获取影响行数失败: %v", err)
+	}
+	if rowsAffected == 0 {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+
+	delivery, err := scanWebhookDelivery(DB.QueryRow("SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE id = ?", id))
+	if err != nil {
+		return nil, fmt.Errorf("查询Webhook投递记录失败: %v", err)
+	}
+	return delivery, nil
+}
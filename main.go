@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"html/template"
 	"io/fs"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -29,8 +35,11 @@ var globalPluginManager *plugin.PluginManager
 // 2. 初始化日志配置
 // 3. 启动配置文件监听
 // 4. 初始化数据库连接
-// 5. 预加载IP2Region数据库
-// 6. 初始化统计信息
+// 5. 初始化管理员账号
+// 6. 加载IP封禁记录
+// 7. 启动API密钥使用次数写入任务和Webhook投递任务
+// 8. 预加载IP2Region数据库
+// 9. 初始化统计信息
 func initApp() {
 	// 解析配置文件
 	config.Parse()
@@ -38,6 +47,9 @@ func initApp() {
 	// 初始化日志配置
 	log.InitLogger()
 
+	// 按配置设置速率限制策略
+	common.ConfigureRateLimiter()
+
 	// 注册配置更新回调
 	config.GetInstance().RegisterUpdateCallback(func(newConfig *config.Config) {
 		// 更新数据库连接池配置
@@ -57,6 +69,17 @@ func initApp() {
 
 		// 重新初始化日志配置
 		log.InitLogger()
+
+		// 更新速率限制策略，保留已有的令牌桶
+		common.ConfigureRateLimiter()
+
+		// 同步管理员账号配置
+		if err := common.InitAdmin(); err != nil {
+			logrus.Errorf("管理员账号同步失败: %v", err)
+		}
+
+		// 记录配置变更到审计日志
+		common.RecordConfigReload(config.GetInstance().GetPreviousConfig(), newConfig)
 	})
 
 	// 启动配置文件监听，实现配置热重载
@@ -67,6 +90,22 @@ func initApp() {
 		logrus.Fatalf("数据库初始化失败：%v", err)
 	}
 
+	// 根据配置初始化管理员账号
+	if err := common.InitAdmin(); err != nil {
+		logrus.Fatalf("管理员账号初始化失败：%v", err)
+	}
+
+	// 加载仍然有效的IP封禁记录，防止重启后绕过封禁
+	if err := common.InitIPBans(); err != nil {
+		logrus.Fatalf("IP封禁记录加载失败：%v", err)
+	}
+
+	// 启动API密钥使用次数的批量写入任务
+	common.StartUsageFlusher()
+
+	// 启动Webhook后台投递任务，继续投递上次退出时尚未完成的记录
+	common.StartWebhookDispatcher()
+
 	// 预加载IP2Region数据库，用于IP地址查询
 	if err := common.InitIP2Region(); err != nil {
 		logrus.Fatalf("IP2Region数据库初始化失败：%v", err)
@@ -90,13 +129,16 @@ func setupGin() *gin.Engine {
 	r.Use(common.RequestLoggerMiddleware())
 	// 添加跨域中间件
 	r.Use(common.CORSMiddleware())
-	// 添加速率限制中间件
-	r.Use(common.RateLimitMiddleware())
 	// 添加性能监控中间件
 	r.Use(common.PerformanceMiddleware())
+	// 未匹配到路由的请求按客户端IP限流，其余路由的速率限制在注册路由时按分组添加
+	r.NoRoute(common.RateLimitMiddleware())
 
-	// 信任所有代理，确保能正确获取客户端真实IP
-	r.SetTrustedProxies(nil)
+	// 只读取可信代理转发的客户端IP（X-Forwarded-For / X-Real-IP），其余请求使用连接的来源地址
+	// 速率限制、统计、IP封禁和各插件统一通过 c.ClientIP() 获取客户端IP
+	if err := r.SetTrustedProxies(config.GetTrustedProxies()); err != nil {
+		logrus.Fatalf("设置可信代理失败：%v", err)
+	}
 
 	return r
 }
@@ -125,8 +167,11 @@ func setupTemplates(r *gin.Engine) {
 
 // 设置静态文件服务
 func setupStaticFiles(r *gin.Engine) {
+	// 静态文件按客户端IP限流
+	static := r.Group("", common.RateLimitMiddleware())
+
 	// 添加静态文件服务，用于提供本地图片（如果需要）
-	r.Static("/images", "./images")
+	static.Static("/images", "./images")
 
 	// 从嵌入式文件系统中获取static子目录
 	staticFS, err := fs.Sub(embeddedFiles, "static")
@@ -135,13 +180,13 @@ func setupStaticFiles(r *gin.Engine) {
 	}
 
 	// 使用嵌入式文件系统提供静态资源
-	r.StaticFS("/static", http.FS(staticFS))
+	static.StaticFS("/static", http.FS(staticFS))
 
 	// 使用静态文件服务提供docsify文档，直接映射到static/docs目录
-	r.StaticFS("/docs", http.Dir("./static/docs"))
+	static.StaticFS("/docs", http.Dir("./static/docs"))
 
 	// 使用嵌入式文件系统提供favicon.ico
-	r.GET("/favicon.ico", func(c *gin.Context) {
+	static.GET("/favicon.ico", func(c *gin.Context) {
 		c.FileFromFS("favicon.ico", http.FS(staticFS))
 	})
 }
@@ -163,46 +208,87 @@ func registerRoutes(r *gin.Engine) {
 	globalPluginManager = pluginManager
 
 	// 注册API根路由（所有插件路由都挂载在/api下）
-	apiGroup := r.Group("/api")
+	// 只有插件路由验证请求中的API密钥并按密钥限流，避免其他页面和接口查询密钥、读取请求体或消耗签名nonce
+	apiGroup := r.Group("/api", common.APIRateLimitMiddleware())
 	{
 		// 为所有API注册统计中间件
 		apiGroup.Use(common.StatsMiddleware())
-		// 为所有API注册API密钥验证中间件
-		apiGroup.Use(common.APIKeyMiddleware())
-		// 使用插件管理器注册所有插件路由
-		pluginManager.RegisterAll(apiGroup)
+		// 使用插件管理器注册所有插件路由，并为插件路由注册API密钥验证中间件
+		pluginManager.RegisterAll(apiGroup, common.APIKeyMiddleware())
 	}
 
-	// 注册API密钥管理路由（不需要API密钥验证）
-	authGroup := r.Group("/auth")
+	// 插件路由以外的页面和接口按客户端IP限流
+	public := r.Group("", common.RateLimitMiddleware())
+
+	// 管理员认证中间件，保护管理接口和管理页面
+	adminAuth := common.AdminAuthMiddleware()
+
+	// 注册API密钥管理路由（需要管理员身份认证，不需要API密钥验证）
+	authGroup := public.Group("/auth", adminAuth)
 	{
 		// 注册API密钥管理路由
-		plugin.RegisterAPIRouter(authGroup)
+		pluginManager.RegisterAPIRouter(authGroup)
 	}
 
+	// 使用API密钥换取短期访问令牌（由API密钥本身认证，不需要管理员身份）
+	public.POST("/auth/token", common.AccessTokenHandler)
+
+	// 添加管理员登录/退出路由
+	public.GET("/login", common.LoginPageHandler)
+	public.POST("/login", common.LoginHandler)
+	public.POST("/logout", common.LogoutHandler)
+
 	// 添加统计信息展示页面路由
-	r.GET("/stats", common.StatsHandler)
+	public.GET("/stats", adminAuth, common.StatsHandler)
 	// 添加统计信息API路由，返回JSON格式数据
-	r.GET("/api/stats", common.StatsAPIHandler)
+	public.GET("/api/stats", adminAuth, common.StatsAPIHandler)
 	// 添加API密钥管理页面路由
-	r.GET("/api_key", common.APIKeyHandler)
+	public.GET("/api_key", adminAuth, common.APIKeyHandler)
+	// 添加审计日志页面路由
+	public.GET("/audit", adminAuth, common.AuditPageHandler)
 
 	// 根路径重定向到docs
-	r.GET("/", func(c *gin.Context) {
+	public.GET("/", func(c *gin.Context) {
 		c.Redirect(http.StatusMovedPermanently, "/docs/")
 	})
 }
 
-// 启动服务
+// 启动服务，收到退出信号后优雅关闭，等待正在处理的请求完成
 func startServer(r *gin.Engine) {
 	port := config.GetServerPort()
+	server := &http.Server{
+		Addr:    port,
+		Handler: r,
+	}
+
+	listener, err := net.Listen("tcp", port)
+	if err != nil {
+		logrus.Fatalf("服务启动失败：%v", err)
+	}
+	// 按配置接受四层负载均衡发送的PROXY协议头
+	listener = common.ProxyProtocolListener(listener)
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logrus.Fatalf("服务启动失败：%v", err)
+		}
+	}()
+
 	logrus.Infof("服务启动成功，监听地址：http://localhost%s", port)
 	logrus.Infof("IP接口示例：http://localhost%s/api/ip?ip=114.114.114.114", port)
 	logrus.Infof("Ping接口示例：http://localhost%s/api/ping?target=www.baidu.com&count=3", port)
 	logrus.Infof("统计页面：http://localhost%s/stats", port)
 
-	if err := r.Run(port); err != nil {
-		logrus.Fatalf("服务启动失败：%v", err)
+	// 等待退出信号
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	logrus.Info("收到退出信号，正在关闭服务...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logrus.Errorf("服务关闭失败：%v", err)
 	}
 }
 
@@ -214,6 +300,10 @@ func main() {
 	defer func() {
 		// 关闭IP2Region服务
 		common.CloseIP2Region()
+		// 写入尚未保存的API密钥使用次数（需要在关闭数据库之前）
+		common.StopUsageFlusher()
+		// 停止Webhook投递任务（需要在关闭数据库之前）
+		common.StopWebhookDispatcher()
 		// 关闭数据库连接
 		if err := db.CloseDB(); err != nil {
 			logrus.Errorf("关闭数据库连接失败：%v", err)
@@ -228,6 +318,8 @@ func main() {
 		config.GetInstance().StopWatching()
 		// 停止API密钥缓存清理任务
 		common.StopAPICacheCleanup()
+		// 关闭共享限流存储的连接
+		common.CloseRateLimitStore()
 	}()
 
 	// 设置Gin引擎和中间件
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+// Account 表示客户账户的模型，一个账户可以拥有多个API密钥
+type Account struct {
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	Contact      string     `json:"contact"`        // 联系方式
+	Plan         string     `json:"plan"`           // 套餐
+	Notes        string     `json:"notes"`          // 备注
+	MaxUsage     int64      `json:"max_usage"`      // 账户下所有密钥合计的使用上限，0表示不限制
+	CurrentUsage int64      `json:"current_usage"`  // 当前配额周期内账户下所有密钥的合计使用次数
+	QuotaPeriod  string     `json:"quota_period"`   // 配额周期（空表示终身配额，daily/monthly），以创建时间为起点
+	QuotaResetAt *time.Time `json:"quota_reset_at"` // 当前配额周期的结束时间
+	KeyCount     int64      `json:"key_count"`      // 账户下的密钥数量
+	KeyUsage     int64      `json:"key_usage"`      // 账户下所有密钥记录的使用次数之和
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// QuotaWindowEnd 计算指定时间所在配额周期的结束时间，终身配额返回零值
+func (a *Account) QuotaWindowEnd(now time.Time) time.Time {
+	return quotaWindowEnd(a.QuotaPeriod, a.CreatedAt, now)
+}
+
+// EffectiveUsage 获取指定时间所在配额周期内的合计使用次数
+func (a *Account) EffectiveUsage(now time.Time) int64 {
+	return effectiveUsage(a.QuotaPeriod, a.CurrentUsage, a.QuotaResetAt, now)
+}
+
+// AccountUpdate 表示账户的部分更新，nil字段表示不修改
+type AccountUpdate struct {
+	Name        *string // 新的名称
+	Contact     *string // 新的联系方式
+	Plan        *string // 新的套餐
+	Notes       *string // 新的备注
+	MaxUsage    *int64  // 新的合计使用上限
+	QuotaPeriod *string // 新的配额周期，修改后从新周期重新计数
+}
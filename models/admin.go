@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// AdminUser 表示管理员账号的模型
+type AdminUser struct {
+	ID           int64     `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"`
+	SessionGen   int64     `json:"-"` // 会话版本，与会话Cookie中的版本不一致时会话失效
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
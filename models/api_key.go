@@ -1,24 +1,283 @@
 package models
 
 import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
 	"time"
 )
 
+// 配额周期类型
+const (
+	QuotaPeriodLifetime = ""        // 终身配额，用完即止
+	QuotaPeriodDaily    = "daily"   // 每日重置
+	QuotaPeriodMonthly  = "monthly" // 每月重置
+)
+
 // APIKey 表示API密钥的模型
 type APIKey struct {
-	ID           int64     `json:"id"`
-	Key          string    `json:"key"`
-	Name         string    `json:"name"`
-	MaxUsage     int64     `json:"max_usage"`
-	CurrentUsage int64     `json:"current_usage"`
-	IsPermanent  bool      `json:"is_permanent"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID                  int64      `json:"id"`
+	Key                 string     `json:"key,omitempty"`            // 明文密钥，仅在创建时返回
+	KeyHash             string     `json:"-"`                        // 密钥SHA-256摘要
+	KeyPrefix           string     `json:"key_prefix"`               // 密钥可见前缀
+	PrevKeyHash         string     `json:"-"`                        // 轮换前旧密钥的SHA-256摘要
+	PrevKeyExpiresAt    *time.Time `json:"prev_key_expires_at"`      // 旧密钥宽限期结束时间，nil表示没有处于宽限期的旧密钥
+	IssuedSigningSecret string     `json:"signing_secret,omitempty"` // 签名密钥，由服务器密钥和密钥摘要派生，仅在创建和轮换时返回
+	Name                string     `json:"name"`
+	MaxUsage            int64      `json:"max_usage"`
+	CurrentUsage        int64      `json:"current_usage"`
+	IsPermanent         bool       `json:"is_permanent"`
+	Enabled             bool       `json:"enabled"`              // 是否启用，禁用的密钥无法调用接口
+	Scopes              []string   `json:"scopes"`               // 允许访问的插件名称，为空表示不限制
+	AllowedPaths        []string   `json:"allowed_paths"`        // 允许访问的路径模式，为空表示不限制
+	AllowedCIDRs        []string   `json:"allowed_cidrs"`        // 允许调用的客户端IP网段（IPv4/IPv6 CIDR），为空表示不限制
+	QuotaPeriod         string     `json:"quota_period"`         // 配额周期（空表示终身配额，daily/monthly）
+	QuotaAnchor         *time.Time `json:"quota_anchor"`         // 配额周期起点，nil表示以创建时间为起点
+	QuotaResetAt        *time.Time `json:"quota_reset_at"`       // 当前配额周期的结束时间
+	ExpiresAt           *time.Time `json:"expires_at"`           // 绝对过期时间，nil表示不过期
+	IdleTimeout         int64      `json:"idle_timeout"`         // 闲置过期时间（秒），0表示不启用
+	LastUsedAt          *time.Time `json:"last_used_at"`         // 最后使用时间
+	RateLimitBurst      int64      `json:"rate_limit_burst"`     // 速率限制的令牌桶容量（突发请求数），0表示使用全局默认值
+	RateLimitRate       float64    `json:"rate_limit_rate"`      // 速率限制的令牌生成速率（每秒），0表示使用全局默认值
+	RequireSignature    bool       `json:"require_signature"`    // 是否只接受HMAC签名请求，不接受直接携带密钥的请求
+	AccountID           int64      `json:"account_id"`           // 所属账户ID，0表示不属于任何账户
+	Plan                string     `json:"plan"`                 // 套餐名称，为空表示不使用套餐
+	ExpiresIn           *int64     `json:"expires_in,omitempty"` // 剩余有效期（秒），仅在列表中返回
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// EffectiveExpiry 计算密钥的实际过期时间
+// 取绝对过期时间和闲置过期时间（最后使用时间或创建时间 + 闲置时长）中较早的一个
+func (k *APIKey) EffectiveExpiry() (time.Time, bool) {
+	var expiry time.Time
+	hasExpiry := false
+
+	if k.ExpiresAt != nil {
+		expiry = *k.ExpiresAt
+		hasExpiry = true
+	}
+
+	if k.IdleTimeout > 0 {
+		lastActive := k.CreatedAt
+		if k.LastUsedAt != nil {
+			lastActive = *k.LastUsedAt
+		}
+		idleExpiry := lastActive.Add(time.Duration(k.IdleTimeout) * time.Second)
+		if !hasExpiry || idleExpiry.Before(expiry) {
+			expiry = idleExpiry
+			hasExpiry = true
+		}
+	}
+
+	return expiry, hasExpiry
+}
+
+// AcceptsHash 检查请求中密钥的摘要是否可用于访问
+// 当前密钥始终可用；轮换前的旧密钥只在宽限期内可用
+func (k *APIKey) AcceptsHash(keyHash string, now time.Time) bool {
+	if keyHash == k.KeyHash {
+		return true
+	}
+	return k.PrevKeyHash != "" && keyHash == k.PrevKeyHash &&
+		k.PrevKeyExpiresAt != nil && now.Before(*k.PrevKeyExpiresAt)
+}
+
+// IsExpired 检查密钥在指定时间是否已过期
+func (k *APIKey) IsExpired(now time.Time) bool {
+	expiry, ok := k.EffectiveExpiry()
+	return ok && !now.Before(expiry)
+}
+
+// IsValidQuotaPeriod 检查配额周期类型是否有效
+func IsValidQuotaPeriod(period string) bool {
+	return period == QuotaPeriodLifetime || period == QuotaPeriodDaily || period == QuotaPeriodMonthly
+}
+
+// QuotaWindowEnd 计算指定时间所在配额周期的结束时间
+// 周期从起点开始按自然日/自然月对齐，终身配额返回零值
+func (k *APIKey) QuotaWindowEnd(now time.Time) time.Time {
+	anchor := k.CreatedAt
+	if k.QuotaAnchor != nil {
+		anchor = *k.QuotaAnchor
+	}
+	return quotaWindowEnd(k.QuotaPeriod, anchor, now)
+}
+
+// quotaWindowEnd 计算从 anchor 开始按 period 滚动的配额周期中，now 所在周期的结束时间
+// 终身配额返回零值
+func quotaWindowEnd(period string, anchor, now time.Time) time.Time {
+	var step func(n int) time.Time
+	var n int
+	switch period {
+	case QuotaPeriodDaily:
+		step = func(n int) time.Time { return anchor.AddDate(0, 0, n) }
+		n = int(now.Sub(anchor).Hours() / 24)
+	case QuotaPeriodMonthly:
+		step = func(n int) time.Time { return addMonths(anchor, n) }
+		n = (now.Year()-anchor.Year())*12 + int(now.Month()-anchor.Month())
+	default:
+		return time.Time{}
+	}
+
+	// 估算值可能因夏令时、月份天数偏差一个周期，逐步修正到 start <= now < end
+	for step(n).After(now) {
+		n--
+	}
+	for !step(n + 1).After(now) {
+		n++
+	}
+	return step(n + 1)
+}
+
+// addMonths 在 t 的基础上增加 n 个月，目标月份没有对应日期时取该月最后一天
+// 如1月31日加1个月为2月28日（闰年为29日），而不是 AddDate 规范化后的3月3日
+func addMonths(t time.Time, n int) time.Time {
+	year, month, day := t.Date()
+	hour, minute, sec := t.Clock()
+	// 目标月份的最后一天：下个月第0天
+	lastDay := time.Date(year, month+time.Month(n)+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(year, month+time.Month(n), day, hour, minute, sec, t.Nanosecond(), t.Location())
+}
+
+// EffectiveUsage 获取指定时间所在配额周期内的已用次数
+// 周期性配额在进入新周期后视为0
+func (k *APIKey) EffectiveUsage(now time.Time) int64 {
+	return effectiveUsage(k.QuotaPeriod, k.CurrentUsage, k.QuotaResetAt, now)
+}
+
+// effectiveUsage 获取指定时间所在配额周期内的已用次数，resetAt 为数据库中记录的周期结束时间
+func effectiveUsage(period string, currentUsage int64, resetAt *time.Time, now time.Time) int64 {
+	if period != QuotaPeriodLifetime && (resetAt == nil || !now.Before(*resetAt)) {
+		return 0
+	}
+	return currentUsage
+}
+
+// QuotaExhausted 检查密钥在指定时间是否已用完配额
+func (k *APIKey) QuotaExhausted(now time.Time) bool {
+	return !k.IsPermanent && k.EffectiveUsage(now) >= k.MaxUsage
+}
+
+// MissingScope 检查密钥是否允许访问指定插件和路径，返回缺少的权限，允许访问时返回空字符串
+// 同时配置了插件和路径时，两者都需要满足
+func (k *APIKey) MissingScope(plugin, requestPath string) string {
+	if len(k.Scopes) > 0 && !containsString(k.Scopes, plugin) {
+		return "plugin:" + plugin
+	}
+
+	if len(k.AllowedPaths) > 0 {
+		for _, pattern := range k.AllowedPaths {
+			if MatchPathPattern(pattern, requestPath) {
+				return ""
+			}
+		}
+		return "path:" + requestPath
+	}
+
+	return ""
+}
+
+// AllowsIP 检查客户端IP是否在密钥允许的网段内，未配置网段时不限制
+func (k *APIKey) AllowsIP(clientIP string) bool {
+	if len(k.AllowedCIDRs) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range k.AllowedCIDRs {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeCIDRs 校验并规范化IP网段列表
+// 单个IP地址会转换为 /32（IPv4）或 /128（IPv6）网段，网段统一为网络地址形式（如 10.0.0.1/8 转为 10.0.0.0/8）
+func NormalizeCIDRs(list []string) ([]string, error) {
+	normalized := make([]string, 0, len(list))
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的IP地址: %s", item)
+			}
+			if ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("无效的IP网段: %s", item)
+		}
+		normalized = append(normalized, ipNet.String())
+	}
+	return normalized, nil
+}
+
+// MatchPathPattern 判断请求路径是否匹配路径模式
+// 以 /* 结尾的模式匹配该路径本身及其下的所有子路径（如 /api/random/* 匹配 /api/random 和 /api/random/a/b，
+// 不匹配 /api/randomx），其余按 path.Match 规则匹配，* 不跨越 /
+func MatchPathPattern(pattern, requestPath string) bool {
+	if base, ok := strings.CutSuffix(pattern, "/*"); ok {
+		if rest, ok := strings.CutPrefix(requestPath, base); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+			return true
+		}
+	}
+	matched, err := path.Match(pattern, requestPath)
+	return err == nil && matched
+}
+
+// containsString 判断字符串切片是否包含指定值
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyUpdate 表示API密钥的部分更新，nil字段表示不修改
+type APIKeyUpdate struct {
+	Name             *string    // 新的名称
+	MaxUsage         *int64     // 新的使用上限
+	IsPermanent      *bool      // 是否永久有效（不限使用次数）
+	Enabled          *bool      // 是否启用
+	ExpiresAt        *time.Time // 新的绝对过期时间
+	ClearExpiresAt   bool       // 是否清除绝对过期时间
+	IdleTimeout      *int64     // 新的闲置过期时间（秒）
+	Scopes           *[]string  // 新的插件权限范围
+	AllowedPaths     *[]string  // 新的路径权限范围
+	AllowedCIDRs     *[]string  // 新的客户端IP网段
+	QuotaPeriod      *string    // 新的配额周期，修改后从新周期重新计数
+	QuotaAnchor      *time.Time // 新的配额周期起点
+	RateLimitBurst   *int64     // 新的令牌桶容量
+	RateLimitRate    *float64   // 新的令牌生成速率
+	RequireSignature *bool      // 是否只接受HMAC签名请求
+	AccountID        *int64     // 新的所属账户ID，0表示移出账户
+	Plan             *string    // 新的套餐名称，空字符串表示不使用套餐
 }
 
 // APIKeyResponse 表示API密钥响应的模型
 type APIKeyResponse struct {
-	Key          string `json:"key"`
+	KeyPrefix    string `json:"key_prefix"`
 	Name         string `json:"name"`
 	MaxUsage     int64  `json:"max_usage"`
 	CurrentUsage int64  `json:"current_usage"`
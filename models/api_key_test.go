@@ -0,0 +1,211 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchPathPattern(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		matched       bool
+	}{
+		// /* 结尾匹配路径本身及子路径，需要在路径段边界上匹配
+		{"/api/random/*", "/api/random", true},
+		{"/api/random/*", "/api/random/", true},
+		{"/api/random/*", "/api/random/a", true},
+		{"/api/random/*", "/api/random/a/b", true},
+		{"/api/random/*", "/api/randomx", false},
+		{"/api/random/*", "/api/randomx/a", false},
+		{"/api/random/*", "/api/rand", false},
+		{"/*", "/anything/at/all", true},
+		// 其余按 path.Match 规则匹配，* 不跨越 /
+		{"/api/ping", "/api/ping", true},
+		{"/api/ping", "/api/ping/x", false},
+		{"/api/rand*", "/api/random", true},
+		{"/api/rand*", "/api/random/a", false},
+		{"/api/*/info", "/api/ip/info", true},
+		{"/api/*/info", "/api/ip/v4/info", false},
+		{"/api/ip?", "/api/ip4", true},
+		{"/api/[", "/api/[", false},
+	}
+	for _, tc := range cases {
+		if matched := MatchPathPattern(tc.pattern, tc.path); matched != tc.matched {
+			t.Errorf("模式 %s 路径 %s: 期望 %v，得到 %v", tc.pattern, tc.path, tc.matched, matched)
+		}
+	}
+}
+
+func TestMissingScope(t *testing.T) {
+	cases := []struct {
+		name         string
+		scopes       []string
+		allowedPaths []string
+		plugin, path string
+		missing      string
+	}{
+		{"不限制", nil, nil, "ping", "/api/ping", ""},
+		{"插件在范围内", []string{"ping", "ip"}, nil, "ip", "/api/ip", ""},
+		{"插件不在范围内", []string{"ping"}, nil, "ip", "/api/ip", "plugin:ip"},
+		{"路径匹配", nil, []string{"/api/random/*"}, "random", "/api/random/image", ""},
+		{"路径不匹配", nil, []string{"/api/random/*"}, "random", "/api/randomx", "path:/api/randomx"},
+		{"插件和路径都满足", []string{"random"}, []string{"/api/random/*"}, "random", "/api/random", ""},
+		{"插件满足路径不满足", []string{"ping"}, []string{"/api/ping/v2/*"}, "ping", "/api/ping", "path:/api/ping"},
+		{"路径满足插件不满足", []string{"ping"}, []string{"/*"}, "ip", "/api/ip", "plugin:ip"},
+	}
+	for _, tc := range cases {
+		key := &APIKey{Scopes: tc.scopes, AllowedPaths: tc.allowedPaths}
+		if missing := key.MissingScope(tc.plugin, tc.path); missing != tc.missing {
+			t.Errorf("%s: 期望缺少 %q，得到 %q", tc.name, tc.missing, missing)
+		}
+	}
+}
+
+func TestQuotaWindowEnd(t *testing.T) {
+	date := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		name        string
+		period      string
+		anchor, now time.Time
+		expected    time.Time
+	}{
+		{"终身配额", QuotaPeriodLifetime, date(2025, 1, 1, 0), date(2025, 6, 1, 0), time.Time{}},
+		{"每日周期内", QuotaPeriodDaily, date(2025, 1, 1, 8), date(2025, 1, 3, 9), date(2025, 1, 4, 8)},
+		{"每日周期边界", QuotaPeriodDaily, date(2025, 1, 1, 8), date(2025, 1, 3, 8), date(2025, 1, 4, 8)},
+		{"每日周期结束前", QuotaPeriodDaily, date(2025, 1, 1, 8), date(2025, 1, 3, 7), date(2025, 1, 3, 8)},
+		{"每月周期", QuotaPeriodMonthly, date(2025, 1, 15, 0), date(2025, 3, 20, 0), date(2025, 4, 15, 0)},
+		{"每月周期边界", QuotaPeriodMonthly, date(2025, 1, 15, 0), date(2025, 3, 15, 0), date(2025, 4, 15, 0)},
+		// 1月31日起的月度周期在2月最后一天重置，之后仍回到31日或当月最后一天
+		{"1月31日起点的1月", QuotaPeriodMonthly, date(2025, 1, 31, 0), date(2025, 2, 10, 0), date(2025, 2, 28, 0)},
+		{"1月31日起点的2月", QuotaPeriodMonthly, date(2025, 1, 31, 0), date(2025, 2, 28, 0), date(2025, 3, 31, 0)},
+		{"1月31日起点的3月", QuotaPeriodMonthly, date(2025, 1, 31, 0), date(2025, 3, 31, 0), date(2025, 4, 30, 0)},
+		{"1月31日起点的4月", QuotaPeriodMonthly, date(2025, 1, 31, 0), date(2025, 4, 30, 12), date(2025, 5, 31, 0)},
+		{"闰年1月31日起点", QuotaPeriodMonthly, date(2024, 1, 31, 0), date(2024, 2, 10, 0), date(2024, 2, 29, 0)},
+		{"闰年2月29日", QuotaPeriodMonthly, date(2024, 1, 31, 0), date(2024, 2, 29, 0), date(2024, 3, 31, 0)},
+		{"2月29日起点的平年", QuotaPeriodMonthly, date(2024, 2, 29, 0), date(2025, 2, 28, 0), date(2025, 3, 29, 0)},
+		{"2月29日起点的平年2月前", QuotaPeriodMonthly, date(2024, 2, 29, 0), date(2025, 2, 27, 0), date(2025, 2, 28, 0)},
+		{"跨年", QuotaPeriodMonthly, date(2024, 12, 31, 0), date(2025, 2, 1, 0), date(2025, 2, 28, 0)},
+		// 起点晚于当前时间时，当前时间所在的周期在起点之前
+		{"起点在未来", QuotaPeriodMonthly, date(2025, 3, 31, 0), date(2025, 3, 1, 0), date(2025, 3, 31, 0)},
+	}
+	for _, tc := range cases {
+		if end := quotaWindowEnd(tc.period, tc.anchor, tc.now); !end.Equal(tc.expected) {
+			t.Errorf("%s: 期望周期结束于 %v，得到 %v", tc.name, tc.expected, end)
+		}
+	}
+}
+
+func TestQuotaExhausted(t *testing.T) {
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	windowEnd := time.Date(2025, 1, 11, 0, 0, 0, 0, time.UTC)
+	expiredWindow := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name      string
+		key       APIKey
+		exhausted bool
+	}{
+		{"未达上限", APIKey{MaxUsage: 10, CurrentUsage: 9}, false},
+		{"恰好达到上限", APIKey{MaxUsage: 10, CurrentUsage: 10}, true},
+		{"永久有效不限次数", APIKey{MaxUsage: 10, CurrentUsage: 100, IsPermanent: true}, false},
+		{"周期内达到上限", APIKey{MaxUsage: 10, CurrentUsage: 10, QuotaPeriod: QuotaPeriodDaily, QuotaResetAt: &windowEnd}, true},
+		{"进入新周期后重新计数", APIKey{MaxUsage: 10, CurrentUsage: 10, QuotaPeriod: QuotaPeriodDaily, QuotaResetAt: &expiredWindow}, false},
+		{"周期配额未记录周期", APIKey{MaxUsage: 10, CurrentUsage: 10, QuotaPeriod: QuotaPeriodMonthly}, false},
+	}
+	for _, tc := range cases {
+		if exhausted := tc.key.QuotaExhausted(now); exhausted != tc.exhausted {
+			t.Errorf("%s: 期望配额用完=%v，得到 %v", tc.name, tc.exhausted, exhausted)
+		}
+	}
+
+	// 周期结束时刻本身属于新周期
+	key := APIKey{MaxUsage: 10, CurrentUsage: 10, QuotaPeriod: QuotaPeriodDaily, QuotaResetAt: &windowEnd}
+	if key.QuotaExhausted(windowEnd) || !key.QuotaExhausted(windowEnd.Add(-time.Nanosecond)) {
+		t.Fatal("周期结束时刻之前应当已用完配额，结束时刻之后应当重新计数")
+	}
+}
+
+func TestEffectiveExpiry(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		v := created.Add(d)
+		return &v
+	}
+
+	cases := []struct {
+		name     string
+		key      APIKey
+		expiry   time.Time
+		hasValue bool
+	}{
+		{"不过期", APIKey{CreatedAt: created}, time.Time{}, false},
+		{"绝对过期时间", APIKey{CreatedAt: created, ExpiresAt: at(48 * time.Hour)}, created.Add(48 * time.Hour), true},
+		{"从未使用时从创建时间计算闲置", APIKey{CreatedAt: created, IdleTimeout: 3600}, created.Add(time.Hour), true},
+		{"从最后使用时间计算闲置", APIKey{CreatedAt: created, IdleTimeout: 3600, LastUsedAt: at(10 * time.Hour)}, created.Add(11 * time.Hour), true},
+		{"闲置过期早于绝对过期", APIKey{CreatedAt: created, IdleTimeout: 3600, ExpiresAt: at(48 * time.Hour)}, created.Add(time.Hour), true},
+		{"绝对过期早于闲置过期", APIKey{CreatedAt: created, IdleTimeout: 3600, LastUsedAt: at(47*time.Hour + 30*time.Minute), ExpiresAt: at(48 * time.Hour)}, created.Add(48 * time.Hour), true},
+	}
+	for _, tc := range cases {
+		expiry, ok := tc.key.EffectiveExpiry()
+		if ok != tc.hasValue || !expiry.Equal(tc.expiry) {
+			t.Errorf("%s: 期望 %v %v，得到 %v %v", tc.name, tc.expiry, tc.hasValue, expiry, ok)
+		}
+		if !tc.hasValue {
+			continue
+		}
+		// 到达过期时间即视为过期
+		if tc.key.IsExpired(tc.expiry.Add(-time.Second)) || !tc.key.IsExpired(tc.expiry) {
+			t.Errorf("%s: 过期时间边界判断错误", tc.name)
+		}
+	}
+}
+
+func TestNormalizeCIDRs(t *testing.T) {
+	normalized, err := NormalizeCIDRs([]string{" 10.1.2.3/8 ", "192.0.2.7", "2001:db8::1", "2001:db8::/32", ""})
+	if err != nil {
+		t.Fatalf("规范化失败: %v", err)
+	}
+	expected := []string{"10.0.0.0/8", "192.0.2.7/32", "2001:db8::1/128", "2001:db8::/32"}
+	if len(normalized) != len(expected) {
+		t.Fatalf("期望 %v，得到 %v", expected, normalized)
+	}
+	for i := range expected {
+		if normalized[i] != expected[i] {
+			t.Fatalf("期望 %v，得到 %v", expected, normalized)
+		}
+	}
+
+	for _, invalid := range []string{"10.0.0.0/33", "not-an-ip", "300.1.1.1"} {
+		if _, err := NormalizeCIDRs([]string{invalid}); err == nil {
+			t.Errorf("%s: 期望返回错误", invalid)
+		}
+	}
+}
+
+func TestAllowsIP(t *testing.T) {
+	key := APIKey{AllowedCIDRs: []string{"10.0.0.0/8", "2001:db8::/32"}}
+	cases := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.20.30.40", true},
+		{"11.0.0.1", false},
+		{"2001:db8::abcd", true},
+		{"2001:db9::1", false},
+		{"::ffff:10.0.0.1", true}, // IPv4映射的IPv6地址按IPv4匹配
+		{"", false},
+		{"invalid", false},
+	}
+	for _, tc := range cases {
+		if allowed := key.AllowsIP(tc.ip); allowed != tc.allowed {
+			t.Errorf("%q: 期望 %v，得到 %v", tc.ip, tc.allowed, allowed)
+		}
+	}
+
+	// 未配置网段时不限制
+	if !(&APIKey{}).AllowsIP("203.0.113.1") {
+		t.Error("未配置网段时期望允许所有IP")
+	}
+}
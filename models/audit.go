@@ -0,0 +1,31 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditLog 表示一条管理操作审计日志
+type AuditLog struct {
+	ID         int64           `json:"id"`
+	Actor      string          `json:"actor"`       // 操作者：管理员用户名，使用管理API令牌时为 token，配置重载等系统操作为 system
+	Action     string          `json:"action"`      // 操作类型，如 api_key.create、plugin.disable
+	TargetType string          `json:"target_type"` // 操作对象类型，如 api_key、account、plugin、config
+	TargetID   string          `json:"target_id"`   // 操作对象ID
+	Before     json.RawMessage `json:"before"`      // 操作前的数据（JSON），创建操作为null
+	After      json.RawMessage `json:"after"`       // 操作后的数据（JSON），删除操作为null
+	ClientIP   string          `json:"client_ip"`   // 操作者的客户端IP，系统操作为空
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditLogFilter 审计日志查询条件，零值字段表示不限制
+type AuditLogFilter struct {
+	Actor      string     // 操作者
+	Action     string     // 操作类型，以 . 结尾时按前缀匹配（如 api_key. 匹配所有密钥操作）
+	TargetType string     // 操作对象类型
+	TargetID   string     // 操作对象ID
+	Since      *time.Time // 开始时间（包含）
+	Until      *time.Time // 结束时间（不包含）
+	Limit      int        // 返回的记录数
+	Offset     int        // 跳过的记录数
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// IPBan 表示一个因多次使用无效API密钥而被临时封禁的IP
+type IPBan struct {
+	IP          string    `json:"ip"`
+	Level       int       `json:"level"`        // 连续被封禁的次数，封禁时长随之翻倍
+	Failures    int       `json:"failures"`     // 触发最近一次封禁的无效密钥次数
+	BannedUntil time.Time `json:"banned_until"` // 封禁结束时间
+	CreatedAt   time.Time `json:"created_at"`   // 首次封禁时间
+	UpdatedAt   time.Time `json:"updated_at"`   // 最近一次封禁时间
+}
+
+// IsActive 判断封禁在指定时间是否仍然有效
+func (b *IPBan) IsActive(now time.Time) bool {
+	return now.Before(b.BannedUntil)
+}
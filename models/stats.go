@@ -12,6 +12,7 @@ type Stats struct {
 	MethodCalls     map[string]int64 `json:"method_calls"`      // 按HTTP方法统计
 	PathCalls       map[string]int64 `json:"path_calls"`        // 按API路径统计
 	IPCalls         map[string]int64 `json:"ip_calls"`          // 按IP统计
+	PrincipalCalls  map[string]int64 `json:"principal_calls"`   // 按调用方统计（api_key:<ID> 或 anonymous）
 	LastResetTime   time.Time        `json:"last_reset_time"`   // 上次重置时间
 	LastCallDetails []*CallDetail    `json:"last_call_details"` // 最近调用详情
 }
@@ -23,4 +24,5 @@ type CallDetail struct {
 	IP         string    `json:"ip"`          // 请求IP
 	Timestamp  time.Time `json:"timestamp"`   // 请求时间
 	StatusCode int       `json:"status_code"` // 响应状态码
+	Principal  string    `json:"principal"`   // 调用方（api_key:<ID> 或 anonymous），未通过认证的请求为空
 }
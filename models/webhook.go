@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// Webhook投递状态
+const (
+	WebhookStatusPending = "pending" // 等待投递或等待重试
+	WebhookStatusSuccess = "success" // 接收方返回2xx
+	WebhookStatusFailed  = "failed"  // 达到最多投递次数仍未成功
+)
+
+// WebhookDelivery 表示一次Webhook投递的记录，每个事件向每个订阅地址各投递一次
+type WebhookDelivery struct {
+	ID            int64      `json:"id"`
+	EventID       string     `json:"event_id"`        // 事件ID，同一事件投递到多个地址时相同
+	Event         string     `json:"event"`           // 事件类型
+	URL           string     `json:"url"`             // 接收地址
+	Payload       string     `json:"payload"`         // 请求体（JSON）
+	Status        string     `json:"status"`          // 投递状态（pending/success/failed）
+	Attempts      int        `json:"attempts"`        // 已投递次数
+	ResponseCode  int        `json:"response_code"`   // 最近一次投递的HTTP状态码，0表示未收到响应
+	LastError     string     `json:"last_error"`      // 最近一次投递失败的原因
+	NextAttemptAt *time.Time `json:"next_attempt_at"` // 下一次投递时间，投递结束后为nil
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,338 @@
+package account
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/common"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// refreshQuota 计算账户当前周期的使用情况，周期配额进入新周期后已用次数视为0
+func refreshQuota(account *models.Account, now time.Time) {
+	if account.QuotaPeriod != models.QuotaPeriodLifetime {
+		account.CurrentUsage = account.EffectiveUsage(now)
+		resetAt := account.QuotaWindowEnd(now)
+		account.QuotaResetAt = &resetAt
+	}
+}
+
+// GetAccountsHandler 获取所有账户
+func GetAccountsHandler(c *gin.Context) {
+	// 先写入内存中累计的使用次数，确保列表中的数据是最新的
+	if err := common.FlushAPIKeyUsage(); err != nil {
+		logrus.Errorf("写入使用次数失败: %v", err)
+	}
+
+	accounts, err := db.GetAllAccounts()
+	if err != nil {
+		logrus.Errorf("获取账户列表失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "获取账户列表失败",
+		})
+		return
+	}
+
+	now := time.Now()
+	for _, account := range accounts {
+		refreshQuota(account, now)
+	}
+
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"accounts": accounts,
+	})
+}
+
+// GetAccountHandler 获取账户详情及其API密钥
+func GetAccountHandler(c *gin.Context) {
+	// 从URL参数中获取ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的ID参数",
+		})
+		return
+	}
+
+	// 先写入内存中累计的使用次数，确保返回的数据是最新的
+	if err := common.FlushAPIKeyUsage(); err != nil {
+		logrus.Errorf("写入使用次数失败: %v", err)
+	}
+
+	account, err := db.GetAccountByID(id)
+	if err != nil {
+		if errors.Is(err, db.ErrAccountNotFound) {
+			common.JSONResponse(c, http.StatusNotFound, gin.H{
+				"error": "账户不存在",
+			})
+			return
+		}
+		logrus.Errorf("查询账户失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "查询账户失败",
+		})
+		return
+	}
+
+	apiKeys, err := db.GetAPIKeysByAccount(id)
+	if err != nil {
+		logrus.Errorf("查询账户API密钥失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "查询账户失败",
+		})
+		return
+	}
+
+	now := time.Now()
+	refreshQuota(account, now)
+	for _, apiKey := range apiKeys {
+		if apiKey.QuotaPeriod != models.QuotaPeriodLifetime {
+			apiKey.CurrentUsage = apiKey.EffectiveUsage(now)
+		}
+	}
+
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"account":  account,
+		"api_keys": apiKeys,
+	})
+}
+
+// CreateAccountHandler 创建新的账户
+func CreateAccountHandler(c *gin.Context) {
+	// 从请求体中获取参数
+	var req struct {
+		Name        string `json:"name" binding:"required"`
+		Contact     string `json:"contact"`      // 联系方式
+		Plan        string `json:"plan"`         // 套餐
+		Notes       string `json:"notes"`        // 备注
+		MaxUsage    int64  `json:"max_usage"`    // 账户下所有密钥合计的使用上限，0表示不限制
+		QuotaPeriod string `json:"quota_period"` // 配额周期（空/daily/monthly）
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "请求参数无效",
+		})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "名称不能为空",
+		})
+		return
+	}
+	if req.MaxUsage < 0 {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "使用上限不能为负数",
+		})
+		return
+	}
+	if !models.IsValidQuotaPeriod(req.QuotaPeriod) {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的配额周期，可选值：daily、monthly 或留空",
+		})
+		return
+	}
+
+	account, err := db.CreateAccount(&models.Account{
+		Name:        name,
+		Contact:     req.Contact,
+		Plan:        req.Plan,
+		Notes:       req.Notes,
+		MaxUsage:    req.MaxUsage,
+		QuotaPeriod: req.QuotaPeriod,
+	})
+	if err != nil {
+		logrus.Errorf("创建账户失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "创建账户失败",
+		})
+		return
+	}
+
+	common.RecordAudit(c, "account.create", "account", strconv.FormatInt(account.ID, 10), nil, account)
+
+	common.JSONResponse(c, http.StatusCreated, gin.H{
+		"account": account,
+	})
+}
+
+// UpdateAccountHandler 更新账户属性
+func UpdateAccountHandler(c *gin.Context) {
+	// 从URL参数中获取ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的ID参数",
+		})
+		return
+	}
+
+	// 从请求体中获取参数，未提供的字段保持不变
+	var req struct {
+		Name        *string `json:"name"`         // 名称
+		Contact     *string `json:"contact"`      // 联系方式
+		Plan        *string `json:"plan"`         // 套餐
+		Notes       *string `json:"notes"`        // 备注
+		MaxUsage    *int64  `json:"max_usage"`    // 合计使用上限，0表示不限制
+		QuotaPeriod *string `json:"quota_period"` // 配额周期（空/daily/monthly），修改后从新周期重新计数
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "请求参数无效",
+		})
+		return
+	}
+
+	update := &models.AccountUpdate{
+		Contact: req.Contact,
+		Plan:    req.Plan,
+		Notes:   req.Notes,
+	}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": "名称不能为空",
+			})
+			return
+		}
+		update.Name = &name
+	}
+	if req.MaxUsage != nil {
+		if *req.MaxUsage < 0 {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": "使用上限不能为负数",
+			})
+			return
+		}
+		update.MaxUsage = req.MaxUsage
+	}
+	if req.QuotaPeriod != nil {
+		if !models.IsValidQuotaPeriod(*req.QuotaPeriod) {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": "无效的配额周期，可选值：daily、monthly 或留空",
+			})
+			return
+		}
+		update.QuotaPeriod = req.QuotaPeriod
+	}
+
+	// 先写入内存中累计的使用次数，确保返回的数据是最新的
+	if err := common.FlushAPIKeyUsage(); err != nil {
+		logrus.Errorf("写入使用次数失败: %v", err)
+	}
+
+	// 记录修改前的数据用于审计日志
+	before, err := db.GetAccountByID(id)
+	if err != nil {
+		if errors.Is(err, db.ErrAccountNotFound) {
+			common.JSONResponse(c, http.StatusNotFound, gin.H{
+				"error": "账户不存在",
+			})
+			return
+		}
+		logrus.Errorf("查询账户失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "更新账户失败",
+		})
+		return
+	}
+
+	account, err := db.UpdateAccount(id, update)
+	if err != nil {
+		if errors.Is(err, db.ErrAccountNotFound) {
+			common.JSONResponse(c, http.StatusNotFound, gin.H{
+				"error": "账户不存在",
+			})
+			return
+		}
+		logrus.Errorf("更新账户失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "更新账户失败",
+		})
+		return
+	}
+
+	// 使缓存失效，确保修改立即生效
+	common.InvalidateAccount(id)
+
+	common.RecordAudit(c, "account.update", "account", strconv.FormatInt(id, 10), before, account)
+
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"account": account,
+	})
+}
+
+// DeleteAccountHandler 删除账户，账户下的API密钥保留并移出账户
+func DeleteAccountHandler(c *gin.Context) {
+	// 从URL参数中获取ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的ID参数",
+		})
+		return
+	}
+
+	// 记录删除前的数据用于审计日志
+	before, err := db.GetAccountByID(id)
+	if err != nil {
+		if errors.Is(err, db.ErrAccountNotFound) {
+			common.JSONResponse(c, http.StatusNotFound, gin.H{
+				"error": "账户不存在",
+			})
+			return
+		}
+		logrus.Errorf("查询账户失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "删除账户失败",
+		})
+		return
+	}
+
+	// 先查询账户下的密钥，删除后需要清除这些密钥的缓存
+	apiKeys, err := db.GetAPIKeysByAccount(id)
+	if err != nil {
+		logrus.Errorf("查询账户API密钥失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "删除账户失败",
+		})
+		return
+	}
+
+	if err := db.DeleteAccount(id); err != nil {
+		if errors.Is(err, db.ErrAccountNotFound) {
+			common.JSONResponse(c, http.StatusNotFound, gin.H{
+				"error": "账户不存在",
+			})
+			return
+		}
+		logrus.Errorf("删除账户失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "删除账户失败",
+		})
+		return
+	}
+
+	// 使缓存失效，确保删除立即生效
+	common.InvalidateAccount(id)
+	for _, apiKey := range apiKeys {
+		common.InvalidateAPIKey(apiKey)
+	}
+
+	common.RecordAudit(c, "account.delete", "account", strconv.FormatInt(id, 10), before, nil)
+
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"message": "账户删除成功",
+	})
+}
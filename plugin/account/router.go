@@ -0,0 +1,22 @@
+package account
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRouter 注册账户管理路由
+func RegisterRouter(r *gin.RouterGroup) {
+	accountGroup := r.Group("/account")
+	{
+		// 获取所有账户
+		accountGroup.GET("", GetAccountsHandler)
+		// 获取账户详情及其API密钥
+		accountGroup.GET("/:id", GetAccountHandler)
+		// 创建新的账户
+		accountGroup.POST("", CreateAccountHandler)
+		// 更新账户属性
+		accountGroup.PATCH("/:id", UpdateAccountHandler)
+		// 删除账户
+		accountGroup.DELETE("/:id", DeleteAccountHandler)
+	}
+}
@@ -1,17 +1,141 @@
 package api_key
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"github.com/xrcuo/xrcuo-api/common"
+	"github.com/xrcuo/xrcuo-api/config"
 	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
 )
 
+// availableScopes 可分配给API密钥的权限范围（已注册的插件名称）
+var availableScopes []string
+
+// validateName 校验密钥名称，返回去除首尾空白后的名称
+func validateName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("名称不能为空")
+	}
+	return name, nil
+}
+
+// validateUsageLimits 校验使用上限和闲置过期时间
+func validateUsageLimits(maxUsage, idleTimeout int64) error {
+	if maxUsage < 0 {
+		return fmt.Errorf("使用上限不能为负数")
+	}
+	if idleTimeout < 0 {
+		return fmt.Errorf("闲置过期时间不能为负数")
+	}
+	return nil
+}
+
+// validateExpiresAt 校验绝对过期时间，必须晚于当前时间
+func validateExpiresAt(expiresAt, now time.Time) error {
+	if !expiresAt.After(now) {
+		return fmt.Errorf("过期时间必须晚于当前时间")
+	}
+	return nil
+}
+
+// validateScopes 校验插件权限范围和路径模式
+func validateScopes(scopes, allowedPaths []string) error {
+	for _, scope := range scopes {
+		valid := false
+		for _, available := range availableScopes {
+			if scope == available {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("未知的权限范围: %s", scope)
+		}
+	}
+
+	for _, pattern := range allowedPaths {
+		if !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("路径模式必须以 / 开头: %s", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("无效的路径模式: %s", pattern)
+		}
+	}
+
+	return nil
+}
+
+// validateRateLimit 校验按密钥的速率限制参数
+func validateRateLimit(burst int64, rate float64) error {
+	if burst < 0 {
+		return fmt.Errorf("令牌桶容量不能为负数")
+	}
+	if rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return fmt.Errorf("令牌生成速率必须为非负数")
+	}
+	return nil
+}
+
+// validateAccount 校验密钥要归属的账户是否存在，0表示不属于任何账户
+func validateAccount(accountID int64) error {
+	if accountID < 0 {
+		return fmt.Errorf("无效的账户ID")
+	}
+	if accountID == 0 {
+		return nil
+	}
+	if _, err := db.GetAccountByID(accountID); err != nil {
+		if errors.Is(err, db.ErrAccountNotFound) {
+			return fmt.Errorf("账户不存在: %d", accountID)
+		}
+		return err
+	}
+	return nil
+}
+
+// validatePlan 校验密钥要使用的套餐是否已在配置中定义，空字符串表示不使用套餐
+func validatePlan(plan string) error {
+	if plan == "" {
+		return nil
+	}
+	if _, ok := config.GetPlan(plan); !ok {
+		return fmt.Errorf("套餐不存在: %s", plan)
+	}
+	return nil
+}
+
+// GetPlansHandler 获取配置中定义的套餐名称
+func GetPlansHandler(c *gin.Context) {
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"plans": config.GetPlanNames(),
+	})
+}
+
+// GetScopesHandler 获取可用的权限范围
+func GetScopesHandler(c *gin.Context) {
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"scopes": availableScopes,
+	})
+}
+
 // GetAPIKeysHandler 获取所有API密钥
 func GetAPIKeysHandler(c *gin.Context) {
+	// 先写入内存中累计的使用次数，确保列表中的数据是最新的
+	if err := common.FlushAPIKeyUsage(); err != nil {
+		logrus.Errorf("写入API密钥使用次数失败: %v", err)
+	}
+
 	// 获取所有API密钥
 	apiKeys, err := db.GetAllAPIKeys()
 	if err != nil {
@@ -22,6 +146,23 @@ func GetAPIKeysHandler(c *gin.Context) {
 		return
 	}
 
+	// 计算每个密钥的剩余有效期和当前周期的使用情况
+	now := time.Now()
+	for _, apiKey := range apiKeys {
+		if apiKey.QuotaPeriod != models.QuotaPeriodLifetime {
+			apiKey.CurrentUsage = apiKey.EffectiveUsage(now)
+			resetAt := apiKey.QuotaWindowEnd(now)
+			apiKey.QuotaResetAt = &resetAt
+		}
+		if expiry, ok := apiKey.EffectiveExpiry(); ok {
+			remaining := int64(expiry.Sub(now).Seconds())
+			if remaining < 0 {
+				remaining = 0
+			}
+			apiKey.ExpiresIn = &remaining
+		}
+	}
+
 	// 返回API密钥列表
 	common.JSONResponse(c, http.StatusOK, gin.H{
 		"api_keys": apiKeys,
@@ -32,9 +173,21 @@ func GetAPIKeysHandler(c *gin.Context) {
 func CreateAPIKeyHandler(c *gin.Context) {
 	// 从请求体中获取参数
 	var req struct {
-		Name        string `json:"name" binding:"required"`
-		MaxUsage    int64  `json:"max_usage"`
-		IsPermanent bool   `json:"is_permanent"`
+		Name             string     `json:"name" binding:"required"`
+		MaxUsage         int64      `json:"max_usage"`
+		IsPermanent      bool       `json:"is_permanent"`
+		ExpiresAt        *time.Time `json:"expires_at"`        // 绝对过期时间（RFC3339）
+		IdleTimeout      int64      `json:"idle_timeout"`      // 闲置过期时间（秒）
+		Scopes           []string   `json:"scopes"`            // 允许访问的插件名称
+		AllowedPaths     []string   `json:"allowed_paths"`     // 允许访问的路径模式
+		AllowedCIDRs     []string   `json:"allowed_cidrs"`     // 允许调用的客户端IP网段，支持单个IP
+		QuotaPeriod      string     `json:"quota_period"`      // 配额周期（空/daily/monthly）
+		QuotaAnchor      *time.Time `json:"quota_anchor"`      // 配额周期起点（RFC3339），默认为创建时间
+		RateLimitBurst   int64      `json:"rate_limit_burst"`  // 令牌桶容量，0表示使用全局默认值
+		RateLimitRate    float64    `json:"rate_limit_rate"`   // 令牌生成速率（每秒），0表示使用全局默认值
+		RequireSignature bool       `json:"require_signature"` // 是否只接受HMAC签名请求
+		AccountID        int64      `json:"account_id"`        // 所属账户ID，0表示不属于任何账户
+		Plan             string     `json:"plan"`              // 套餐名称，为空表示不使用套餐
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -44,8 +197,87 @@ func CreateAPIKeyHandler(c *gin.Context) {
 		return
 	}
 
+	name, err := validateName(req.Name)
+	if err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := validateUsageLimits(req.MaxUsage, req.IdleTimeout); err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 校验过期参数
+	if req.ExpiresAt != nil {
+		if err := validateExpiresAt(*req.ExpiresAt, time.Now()); err != nil {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := validateScopes(req.Scopes, req.AllowedPaths); err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	allowedCIDRs, err := models.NormalizeCIDRs(req.AllowedCIDRs)
+	if err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if !models.IsValidQuotaPeriod(req.QuotaPeriod) {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的配额周期，可选值：daily、monthly 或留空",
+		})
+		return
+	}
+	if err := validateRateLimit(req.RateLimitBurst, req.RateLimitRate); err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := validateAccount(req.AccountID); err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := validatePlan(req.Plan); err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	// 创建API密钥
-	apiKey, err := db.CreateAPIKey(req.Name, req.MaxUsage, req.IsPermanent)
+	apiKey, err := db.CreateAPIKey(&models.APIKey{
+		Name:             name,
+		MaxUsage:         req.MaxUsage,
+		IsPermanent:      req.IsPermanent,
+		Enabled:          true,
+		ExpiresAt:        req.ExpiresAt,
+		IdleTimeout:      req.IdleTimeout,
+		Scopes:           req.Scopes,
+		AllowedPaths:     req.AllowedPaths,
+		AllowedCIDRs:     allowedCIDRs,
+		QuotaPeriod:      req.QuotaPeriod,
+		QuotaAnchor:      req.QuotaAnchor,
+		RateLimitBurst:   req.RateLimitBurst,
+		RateLimitRate:    req.RateLimitRate,
+		RequireSignature: req.RequireSignature,
+		AccountID:        req.AccountID,
+		Plan:             req.Plan,
+	})
 	if err != nil {
 		logrus.Errorf("创建API密钥失败: %v", err)
 		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
@@ -53,6 +285,10 @@ func CreateAPIKeyHandler(c *gin.Context) {
 		})
 		return
 	}
+	// 清除该ID此前查询不到时留下的缓存
+	common.InvalidateAPIKey(apiKey)
+	common.EmitAPIKeyWebhook(common.WebhookEventKeyCreated, apiKey, nil)
+	common.RecordAudit(c, "api_key.create", "api_key", strconv.FormatInt(apiKey.ID, 10), nil, apiKey)
 
 	// 返回创建的API密钥
 	common.JSONResponse(c, http.StatusCreated, gin.H{
@@ -60,6 +296,347 @@ func CreateAPIKeyHandler(c *gin.Context) {
 	})
 }
 
+// UpdateAPIKeyHandler 更新API密钥属性
+func UpdateAPIKeyHandler(c *gin.Context) {
+	// 从URL参数中获取ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的ID参数",
+		})
+		return
+	}
+
+	// 从请求体中获取参数，未提供的字段保持不变
+	var req struct {
+		Name             *string    `json:"name"`              // 名称
+		MaxUsage         *int64     `json:"max_usage"`         // 使用上限
+		IsPermanent      *bool      `json:"is_permanent"`      // 是否永久有效（不限使用次数）
+		Enabled          *bool      `json:"enabled"`           // 是否启用
+		ExpiresAt        *string    `json:"expires_at"`        // 绝对过期时间（RFC3339），空字符串表示取消过期时间
+		IdleTimeout      *int64     `json:"idle_timeout"`      // 闲置过期时间（秒），0表示不启用
+		Scopes           *[]string  `json:"scopes"`            // 允许访问的插件名称，空数组表示不限制
+		AllowedPaths     *[]string  `json:"allowed_paths"`     // 允许访问的路径模式，空数组表示不限制
+		AllowedCIDRs     *[]string  `json:"allowed_cidrs"`     // 允许调用的客户端IP网段，空数组表示不限制
+		QuotaPeriod      *string    `json:"quota_period"`      // 配额周期（空/daily/monthly），修改后从新周期重新计数
+		QuotaAnchor      *time.Time `json:"quota_anchor"`      // 配额周期起点（RFC3339）
+		RateLimitBurst   *int64     `json:"rate_limit_burst"`  // 令牌桶容量，0表示使用全局默认值
+		RateLimitRate    *float64   `json:"rate_limit_rate"`   // 令牌生成速率（每秒），0表示使用全局默认值
+		RequireSignature *bool      `json:"require_signature"` // 是否只接受HMAC签名请求
+		AccountID        *int64     `json:"account_id"`        // 所属账户ID，0表示移出账户
+		Plan             *string    `json:"plan"`              // 套餐名称，空字符串表示不使用套餐
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "请求参数无效",
+		})
+		return
+	}
+
+	update := &models.APIKeyUpdate{
+		IsPermanent:      req.IsPermanent,
+		Enabled:          req.Enabled,
+		RequireSignature: req.RequireSignature,
+	}
+	if req.Name != nil {
+		name, err := validateName(*req.Name)
+		if err != nil {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		update.Name = &name
+	}
+	if req.MaxUsage != nil || req.IdleTimeout != nil {
+		var maxUsage, idleTimeout int64
+		if req.MaxUsage != nil {
+			maxUsage = *req.MaxUsage
+		}
+		if req.IdleTimeout != nil {
+			idleTimeout = *req.IdleTimeout
+		}
+		if err := validateUsageLimits(maxUsage, idleTimeout); err != nil {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		update.MaxUsage = req.MaxUsage
+		update.IdleTimeout = req.IdleTimeout
+	}
+	if req.ExpiresAt != nil {
+		if *req.ExpiresAt == "" {
+			update.ClearExpiresAt = true
+		} else {
+			expiresAt, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+			if err != nil {
+				common.JSONResponse(c, http.StatusBadRequest, gin.H{
+					"error": "过期时间格式无效，应为RFC3339格式",
+				})
+				return
+			}
+			if err := validateExpiresAt(expiresAt, time.Now()); err != nil {
+				common.JSONResponse(c, http.StatusBadRequest, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			update.ExpiresAt = &expiresAt
+		}
+	}
+	if req.Scopes != nil || req.AllowedPaths != nil {
+		var scopes, allowedPaths []string
+		if req.Scopes != nil {
+			scopes = *req.Scopes
+		}
+		if req.AllowedPaths != nil {
+			allowedPaths = *req.AllowedPaths
+		}
+		if err := validateScopes(scopes, allowedPaths); err != nil {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		update.Scopes = req.Scopes
+		update.AllowedPaths = req.AllowedPaths
+	}
+	if req.AllowedCIDRs != nil {
+		allowedCIDRs, err := models.NormalizeCIDRs(*req.AllowedCIDRs)
+		if err != nil {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		update.AllowedCIDRs = &allowedCIDRs
+	}
+	if req.QuotaPeriod != nil {
+		if !models.IsValidQuotaPeriod(*req.QuotaPeriod) {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": "无效的配额周期，可选值：daily、monthly 或留空",
+			})
+			return
+		}
+		update.QuotaPeriod = req.QuotaPeriod
+	}
+	update.QuotaAnchor = req.QuotaAnchor
+	if req.RateLimitBurst != nil || req.RateLimitRate != nil {
+		var burst int64
+		var rate float64
+		if req.RateLimitBurst != nil {
+			burst = *req.RateLimitBurst
+		}
+		if req.RateLimitRate != nil {
+			rate = *req.RateLimitRate
+		}
+		if err := validateRateLimit(burst, rate); err != nil {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		update.RateLimitBurst = req.RateLimitBurst
+		update.RateLimitRate = req.RateLimitRate
+	}
+	if req.AccountID != nil {
+		if err := validateAccount(*req.AccountID); err != nil {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		update.AccountID = req.AccountID
+	}
+	if req.Plan != nil {
+		if err := validatePlan(*req.Plan); err != nil {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		update.Plan = req.Plan
+	}
+
+	// 先写入内存中累计的使用次数，确保返回的数据是最新的
+	if err := common.FlushAPIKeyUsage(); err != nil {
+		logrus.Errorf("写入API密钥使用次数失败: %v", err)
+	}
+
+	// 记录修改前的数据用于审计日志
+	before, err := db.GetAPIKeyByID(id)
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			common.JSONResponse(c, http.StatusNotFound, gin.H{
+				"error": "API密钥不存在",
+			})
+			return
+		}
+		logrus.Errorf("查询API密钥失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "更新API密钥失败",
+		})
+		return
+	}
+
+	// 更新API密钥
+	apiKey, err := db.UpdateAPIKey(id, update)
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			common.JSONResponse(c, http.StatusNotFound, gin.H{
+				"error": "API密钥不存在",
+			})
+			return
+		}
+		logrus.Errorf("更新API密钥失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "更新API密钥失败",
+		})
+		return
+	}
+
+	// 使缓存失效，确保修改立即生效
+	common.InvalidateAPIKey(apiKey)
+	common.RecordAudit(c, "api_key.update", "api_key", strconv.FormatInt(id, 10), before, apiKey)
+
+	// 返回更新后的API密钥
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"api_key": apiKey,
+	})
+}
+
+// RotateAPIKeyHandler 轮换API密钥
+// 生成新密钥替换当前密钥，ID、名称和使用次数保持不变；旧密钥在宽限期内仍可使用
+func RotateAPIKeyHandler(c *gin.Context) {
+	// 从URL参数中获取ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的ID参数",
+		})
+		return
+	}
+
+	// 请求体可选，未指定宽限期时使用配置中的默认值
+	var req struct {
+		GraceSeconds *int64 `json:"grace_seconds"` // 旧密钥宽限期（秒），0表示立即失效
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": "请求参数无效",
+			})
+			return
+		}
+	}
+
+	grace := config.GetAPIKeyRotationGracePeriod()
+	if req.GraceSeconds != nil {
+		if *req.GraceSeconds < 0 {
+			common.JSONResponse(c, http.StatusBadRequest, gin.H{
+				"error": "宽限期不能为负数",
+			})
+			return
+		}
+		grace = time.Duration(*req.GraceSeconds) * time.Second
+	}
+
+	// 先查询轮换前的密钥，轮换后需要清除新旧摘要对应的缓存
+	oldKey, err := db.GetAPIKeyByID(id)
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			common.JSONResponse(c, http.StatusNotFound, gin.H{
+				"error": "API密钥不存在",
+			})
+			return
+		}
+		logrus.Errorf("查询API密钥失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "轮换API密钥失败",
+		})
+		return
+	}
+
+	// 轮换API密钥
+	apiKey, err := db.RotateAPIKey(id, grace)
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			common.JSONResponse(c, http.StatusNotFound, gin.H{
+				"error": "API密钥不存在",
+			})
+			return
+		}
+		if errors.Is(err, db.ErrAPIKeyRotationInGrace) {
+			response := gin.H{
+				"error": "上一次轮换的旧密钥仍在宽限期内，宽限期结束后才能再次带宽限期轮换；需要立即作废旧密钥时请将 grace_seconds 设为0",
+			}
+			if apiKey != nil {
+				response["prev_key_expires_at"] = apiKey.PrevKeyExpiresAt
+			}
+			common.JSONResponse(c, http.StatusConflict, response)
+			return
+		}
+		logrus.Errorf("轮换API密钥失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "轮换API密钥失败",
+		})
+		return
+	}
+
+	// 使缓存失效，旧密钥的缓存重新加载后按宽限期判断是否可用
+	common.InvalidateAPIKey(oldKey)
+	common.RecordAudit(c, "api_key.rotate", "api_key", strconv.FormatInt(id, 10), oldKey, apiKey)
+
+	// 返回新密钥，明文只返回这一次
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"api_key": apiKey,
+	})
+}
+
+// GetSigningSecretHandler 获取API密钥当前的签名密钥
+// 签名密钥由服务器密钥和密钥摘要派生，不需要轮换即可重新获取，用于迁移的旧密钥或遗失签名密钥的客户端
+func GetSigningSecretHandler(c *gin.Context) {
+	// 从URL参数中获取ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的ID参数",
+		})
+		return
+	}
+
+	apiKey, err := db.GetAPIKeyByID(id)
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			common.JSONResponse(c, http.StatusNotFound, gin.H{
+				"error": "API密钥不存在",
+			})
+			return
+		}
+		logrus.Errorf("查询API密钥失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "获取签名密钥失败",
+		})
+		return
+	}
+
+	secret, err := db.DeriveSigningSecret(apiKey.KeyHash)
+	if err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	common.RecordAudit(c, "api_key.signing_secret", "api_key", strconv.FormatInt(id, 10), nil, nil)
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"id":             apiKey.ID,
+		"signing_secret": secret,
+	})
+}
+
 // DeleteAPIKeyHandler 删除API密钥
 func DeleteAPIKeyHandler(c *gin.Context) {
 	// 从URL参数中获取ID
@@ -72,8 +649,24 @@ func DeleteAPIKeyHandler(c *gin.Context) {
 		return
 	}
 
+	// 先查询密钥，删除后需要根据摘要清除缓存
+	apiKey, err := db.GetAPIKeyByID(id)
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			common.JSONResponse(c, http.StatusNotFound, gin.H{
+				"error": "API密钥不存在",
+			})
+			return
+		}
+		logrus.Errorf("查询API密钥失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "删除API密钥失败",
+		})
+		return
+	}
+
 	// 删除API密钥
-	if err := db.DeleteAPIKey(id); err != nil {
+	if err := db.DeleteAPIKey(id); err != nil && !errors.Is(err, db.ErrAPIKeyNotFound) {
 		logrus.Errorf("删除API密钥失败: %v", err)
 		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
 			"error": "删除API密钥失败",
@@ -81,6 +674,11 @@ func DeleteAPIKeyHandler(c *gin.Context) {
 		return
 	}
 
+	// 使缓存失效，已删除的密钥立即无法使用
+	common.InvalidateAPIKey(apiKey)
+	common.EmitAPIKeyWebhook(common.WebhookEventKeyDeleted, apiKey, nil)
+	common.RecordAudit(c, "api_key.delete", "api_key", idStr, apiKey, nil)
+
 	// 返回删除成功
 	common.JSONResponse(c, http.StatusOK, gin.H{
 		"message": "API密钥删除成功",
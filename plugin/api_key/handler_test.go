@@ -0,0 +1,102 @@
+package api_key
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestValidateName(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected string
+		ok       bool
+	}{
+		{"client", "client", true},
+		{"  client  ", "client", true},
+		{"", "", false},
+		{" \t\n ", "", false},
+	}
+	for _, tc := range cases {
+		name, err := validateName(tc.name)
+		if (err == nil) != tc.ok || name != tc.expected {
+			t.Errorf("%q: 期望 %q（有效=%v），得到 %q（%v）", tc.name, tc.expected, tc.ok, name, err)
+		}
+	}
+}
+
+func TestValidateUsageLimits(t *testing.T) {
+	cases := []struct {
+		maxUsage, idleTimeout int64
+		ok                    bool
+	}{
+		{0, 0, true},
+		{1000, 3600, true},
+		{-1, 0, false},
+		{0, -1, false},
+	}
+	for _, tc := range cases {
+		if err := validateUsageLimits(tc.maxUsage, tc.idleTimeout); (err == nil) != tc.ok {
+			t.Errorf("max_usage=%d idle_timeout=%d: 期望有效=%v，得到 %v", tc.maxUsage, tc.idleTimeout, tc.ok, err)
+		}
+	}
+}
+
+func TestValidateExpiresAt(t *testing.T) {
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	cases := []struct {
+		expiresAt time.Time
+		ok        bool
+	}{
+		{now.Add(time.Second), true},
+		{now.AddDate(1, 0, 0), true},
+		{now, false},
+		{now.Add(-time.Hour), false},
+	}
+	for _, tc := range cases {
+		if err := validateExpiresAt(tc.expiresAt, now); (err == nil) != tc.ok {
+			t.Errorf("%s: 期望有效=%v，得到 %v", tc.expiresAt.Format(time.RFC3339), tc.ok, err)
+		}
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	availableScopes = []string{"ping", "ip"}
+	t.Cleanup(func() { availableScopes = nil })
+
+	cases := []struct {
+		scopes, allowedPaths []string
+		ok                   bool
+	}{
+		{nil, nil, true},
+		{[]string{"ping", "ip"}, []string{"/api/ping", "/api/ip/*"}, true},
+		{[]string{"unknown"}, nil, false},
+		{nil, []string{"api/ping"}, false},
+		{nil, []string{"/api/[ping"}, false},
+	}
+	for _, tc := range cases {
+		if err := validateScopes(tc.scopes, tc.allowedPaths); (err == nil) != tc.ok {
+			t.Errorf("scopes=%v paths=%v: 期望有效=%v，得到 %v", tc.scopes, tc.allowedPaths, tc.ok, err)
+		}
+	}
+}
+
+func TestValidateRateLimit(t *testing.T) {
+	cases := []struct {
+		burst int64
+		rate  float64
+		ok    bool
+	}{
+		{0, 0, true},
+		{10, 2.5, true},
+		{-1, 1, false},
+		{1, -1, false},
+		{1, math.NaN(), false},
+		{1, math.Inf(1), false},
+	}
+	for _, tc := range cases {
+		if err := validateRateLimit(tc.burst, tc.rate); (err == nil) != tc.ok {
+			t.Errorf("burst=%d rate=%v: 期望有效=%v，得到 %v", tc.burst, tc.rate, tc.ok, err)
+		}
+	}
+}
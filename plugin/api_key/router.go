@@ -5,13 +5,26 @@ import (
 )
 
 // RegisterRouter 注册API密钥管理路由
-func RegisterRouter(r *gin.RouterGroup) {
+// scopes: 可分配给API密钥的权限范围（已注册的插件名称）
+func RegisterRouter(r *gin.RouterGroup, scopes []string) {
+	availableScopes = scopes
+
 	apiKeyGroup := r.Group("/api_key")
 	{
 		// 获取所有API密钥
 		apiKeyGroup.GET("", GetAPIKeysHandler)
+		// 获取可用的权限范围
+		apiKeyGroup.GET("/scopes", GetScopesHandler)
+		// 获取配置中定义的套餐
+		apiKeyGroup.GET("/plans", GetPlansHandler)
 		// 创建新的API密钥
 		apiKeyGroup.POST("", CreateAPIKeyHandler)
+		// 更新API密钥属性
+		apiKeyGroup.PATCH("/:id", UpdateAPIKeyHandler)
+		// 轮换API密钥
+		apiKeyGroup.POST("/:id/rotate", RotateAPIKeyHandler)
+		// 获取API密钥的签名密钥
+		apiKeyGroup.GET("/:id/signing_secret", GetSigningSecretHandler)
 		// 删除API密钥
 		apiKeyGroup.DELETE("/:id", DeleteAPIKeyHandler)
 	}
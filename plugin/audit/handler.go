@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/common"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// maxPageSize 单次查询最多返回的审计日志数
+const maxPageSize = 200
+
+// parseTime 解析RFC3339格式的时间参数，参数为空时返回nil
+func parseTime(c *gin.Context, name string) (*time.Time, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, true
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的" + name + "参数，需要RFC3339格式的时间",
+		})
+		return nil, false
+	}
+	return &t, true
+}
+
+// GetAuditLogsHandler 分页查询审计日志，按时间倒序排列
+// 查询参数：actor、action（以 . 结尾时按前缀匹配）、target_type、target_id、
+// since/until（RFC3339时间）、limit（默认50）、offset
+func GetAuditLogsHandler(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的limit参数，取值范围1-200",
+		})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的offset参数",
+		})
+		return
+	}
+
+	since, ok := parseTime(c, "since")
+	if !ok {
+		return
+	}
+	until, ok := parseTime(c, "until")
+	if !ok {
+		return
+	}
+
+	logs, total, err := db.GetAuditLogs(models.AuditLogFilter{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		Since:      since,
+		Until:      until,
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		logrus.Errorf("获取审计日志失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "获取审计日志失败",
+		})
+		return
+	}
+	if logs == nil {
+		logs = []*models.AuditLog{}
+	}
+
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"logs":  logs,
+		"total": total,
+	})
+}
@@ -0,0 +1,11 @@
+package audit
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRouter 注册审计日志查询路由
+func RegisterRouter(r *gin.RouterGroup) {
+	// 分页查询审计日志
+	r.GET("/audit", GetAuditLogsHandler)
+}
@@ -110,21 +110,9 @@ func parseUserAgent(ua string) (os string, browser string, version string) {
 	return
 }
 
-// GetRealIP 获取客户端真实IP，处理代理情况
+// GetRealIP 获取客户端真实IP
+// 只有来自可信代理（server.trusted_proxies）的请求才使用 X-Forwarded-For / X-Real-IP 中的地址，
+// 与速率限制、统计等使用相同的结果，客户端无法通过伪造请求头修改
 func GetRealIP(c *gin.Context) string {
-	// 优先从X-Real-IP头获取
-	if ip := c.GetHeader("X-Real-IP"); ip != "" {
-		return ip
-	}
-
-	// 然后从X-Forwarded-For头获取，取第一个IP
-	if ip := c.GetHeader("X-Forwarded-For"); ip != "" {
-		ips := strings.Split(ip, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
-		}
-	}
-
-	// 最后使用Gin的ClientIP方法
 	return c.ClientIP()
 }
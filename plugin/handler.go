@@ -0,0 +1,54 @@
+package plugin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xrcuo/xrcuo-api/common"
+)
+
+// GetPluginsHandler 获取所有插件及其启用状态
+func (pm *PluginManager) GetPluginsHandler(c *gin.Context) {
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"plugins": pm.GetPluginInfos(),
+	})
+}
+
+// EnablePluginHandler 启用插件
+func (pm *PluginManager) EnablePluginHandler(c *gin.Context) {
+	pm.switchPlugin(c, true)
+}
+
+// DisablePluginHandler 停用插件，启用状态只保存在内存中，服务重启后所有插件恢复启用
+func (pm *PluginManager) DisablePluginHandler(c *gin.Context) {
+	pm.switchPlugin(c, false)
+}
+
+// switchPlugin 修改插件的启用状态并记录审计日志
+func (pm *PluginManager) switchPlugin(c *gin.Context, enabled bool) {
+	name := c.Param("name")
+	before, exists := pm.GetPluginInfo(name)
+	if !exists {
+		common.JSONResponse(c, http.StatusNotFound, gin.H{
+			"error": "插件不存在",
+		})
+		return
+	}
+
+	if enabled {
+		pm.EnablePlugin(name)
+	} else {
+		pm.DisablePlugin(name)
+	}
+	after, _ := pm.GetPluginInfo(name)
+
+	action := "plugin.disable"
+	if enabled {
+		action = "plugin.enable"
+	}
+	common.RecordAudit(c, action, "plugin", name, before, after)
+
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"plugin": after,
+	})
+}
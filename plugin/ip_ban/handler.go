@@ -0,0 +1,58 @@
+package ip_ban
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/common"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// GetIPBansHandler 获取IP封禁列表，默认只返回仍然有效的封禁
+// 查询参数：all=true 时同时返回已经结束的封禁
+func GetIPBansHandler(c *gin.Context) {
+	activeOnly := c.Query("all") != "true"
+	bans, err := db.GetIPBans(activeOnly, time.Now())
+	if err != nil {
+		logrus.Errorf("获取IP封禁列表失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "获取IP封禁列表失败",
+		})
+		return
+	}
+	if bans == nil {
+		bans = []*models.IPBan{}
+	}
+
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"bans": bans,
+	})
+}
+
+// DeleteIPBanHandler 解除IP的封禁并删除封禁记录
+func DeleteIPBanHandler(c *gin.Context) {
+	ip := c.Param("ip")
+	ban, err := common.ClearIPBan(ip)
+	if err != nil {
+		if errors.Is(err, db.ErrIPBanNotFound) {
+			common.JSONResponse(c, http.StatusNotFound, gin.H{
+				"error": "IP封禁记录不存在",
+			})
+			return
+		}
+		logrus.Errorf("解除IP封禁失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "解除IP封禁失败",
+		})
+		return
+	}
+	common.RecordAudit(c, "ip_ban.delete", "ip_ban", ip, ban, nil)
+
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"message": "IP封禁已解除",
+	})
+}
@@ -0,0 +1,16 @@
+package ip_ban
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRouter 注册IP封禁管理路由
+func RegisterRouter(r *gin.RouterGroup) {
+	ipBanGroup := r.Group("/ip_ban")
+	{
+		// 获取封禁列表
+		ipBanGroup.GET("", GetIPBansHandler)
+		// 解除封禁
+		ipBanGroup.DELETE("/:ip", DeleteIPBanHandler)
+	}
+}
@@ -8,8 +8,12 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-ping/ping"
 	"github.com/xrcuo/xrcuo-api/common"
+	"golang.org/x/sync/singleflight"
 )
 
+// pingGroup 合并同时进行的相同Ping测试，目标IP、超时时间和包数都相同的请求共用一次测试结果
+var pingGroup singleflight.Group
+
 // // PingHandler Ping测试处理函数
 func PingHandler(c *gin.Context) {
 	startTime := time.Now()
@@ -60,7 +64,7 @@ func PingHandler(c *gin.Context) {
 	}
 
 	// 3. 执行Ping测试
-	pingStats, err := doPing(ipAddr, timeout, count)
+	pingStats, err := sharedPing(ipAddr, timeout, count)
 	if err != nil {
 		response.Code = 500
 		response.Msg = "Ping测试失败：" + err.Error()
@@ -105,6 +109,19 @@ func PingHandler(c *gin.Context) {
 	}
 }
 
+// sharedPing 执行Ping测试，已有相同参数的测试正在进行时等待并共用其结果
+func sharedPing(ip string, timeout time.Duration, count int) (*ping.Statistics, error) {
+	key := fmt.Sprintf("%s|%s|%d", ip, timeout, count)
+	result, err, _ := pingGroup.Do(key, func() (interface{}, error) {
+		return doPing(ip, timeout, count)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 共用的统计结果只读，不能修改
+	return result.(*ping.Statistics), nil
+}
+
 // doPing 执行ICMP Ping测试（适配内外网间隔）
 func doPing(ip string, timeout time.Duration, count int) (*ping.Statistics, error) {
 	pinger, err := ping.NewPinger(ip)
@@ -1,14 +1,22 @@
 package plugin
 
 import (
+	"net/http"
+	"sync"
+
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/common"
+	"github.com/xrcuo/xrcuo-api/plugin/account"
 	"github.com/xrcuo/xrcuo-api/plugin/api_key"
+	"github.com/xrcuo/xrcuo-api/plugin/audit"
 	"github.com/xrcuo/xrcuo-api/plugin/client"
 	"github.com/xrcuo/xrcuo-api/plugin/ip"
+	"github.com/xrcuo/xrcuo-api/plugin/ip_ban"
 	"github.com/xrcuo/xrcuo-api/plugin/ipify"
 	"github.com/xrcuo/xrcuo-api/plugin/ping"
 	"github.com/xrcuo/xrcuo-api/plugin/random"
+	"github.com/xrcuo/xrcuo-api/plugin/webhook"
 )
 
 // Plugin 插件接口
@@ -25,8 +33,8 @@ type Plugin interface {
 
 // PluginInfo 插件信息
 type PluginInfo struct {
-	Name    string
-	Enabled bool
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
 }
 
 // PluginManager 插件管理器
@@ -34,6 +42,7 @@ type PluginManager struct {
 	plugins     []Plugin
 	initialized bool
 	pluginInfos map[string]*PluginInfo
+	infoMutex   sync.RWMutex // 保护插件的启用状态，运行时可通过管理接口修改
 }
 
 // NewPluginManager 创建新的插件管理器
@@ -53,10 +62,12 @@ func (pm *PluginManager) Register(plugin Plugin) {
 	}
 
 	pm.plugins = append(pm.plugins, plugin)
+	pm.infoMutex.Lock()
 	pm.pluginInfos[name] = &PluginInfo{
 		Name:    name,
 		Enabled: true,
 	}
+	pm.infoMutex.Unlock()
 
 	logrus.Infof("插件 %s 已注册", name)
 }
@@ -80,9 +91,18 @@ func (pm *PluginManager) InitAll() error {
 }
 
 // RegisterAll 注册所有插件到指定路由组
-func (pm *PluginManager) RegisterAll(group *gin.RouterGroup) {
+// 每个插件挂载在独立的子路由组上，先写入插件名称到上下文、检查插件是否已停用，再执行传入的中间件，
+// 便于API密钥中间件等按插件进行权限控制；之后等待插件的并发限制，取得处理位置后才扣除API密钥的使用次数，
+// 已停用插件、未通过验证和因并发数已满被拒绝的请求不会占用处理位置，也不会消耗使用次数
+func (pm *PluginManager) RegisterAll(group *gin.RouterGroup, middlewares ...gin.HandlerFunc) {
 	for _, plugin := range pm.plugins {
-		plugin.RegisterRouter(group)
+		handlers := []gin.HandlerFunc{
+			common.PluginContextMiddleware(plugin.Name()),
+			pm.enabledMiddleware(plugin.Name()),
+		}
+		handlers = append(handlers, middlewares...)
+		handlers = append(handlers, common.ConcurrencyMiddleware(plugin.Name()), common.UsageMiddleware())
+		plugin.RegisterRouter(group.Group("", handlers...))
 		logrus.Infof("插件 %s 路由注册成功", plugin.Name())
 	}
 }
@@ -105,36 +125,92 @@ func (pm *PluginManager) GetPlugins() []Plugin {
 	return pm.plugins
 }
 
+// PluginNames 获取所有注册插件的名称
+func (pm *PluginManager) PluginNames() []string {
+	names := make([]string, 0, len(pm.plugins))
+	for _, plugin := range pm.plugins {
+		names = append(names, plugin.Name())
+	}
+	return names
+}
+
 // GetPluginInfo 获取插件信息
+// 返回信息的副本，修改启用状态请使用 EnablePlugin/DisablePlugin
 func (pm *PluginManager) GetPluginInfo(name string) (*PluginInfo, bool) {
-	info, exists := pm.pluginInfos[name]
-	return info, exists
-}
+	pm.infoMutex.RLock()
+	defer pm.infoMutex.RUnlock()
 
-// EnablePlugin 启用插件
-func (pm *PluginManager) EnablePlugin(name string) bool {
 	info, exists := pm.pluginInfos[name]
 	if !exists {
-		return false
+		return nil, false
 	}
+	snapshot := *info
+	return &snapshot, true
+}
 
-	info.Enabled = true
-	logrus.Infof("插件 %s 已启用", name)
-	return true
+// GetPluginInfos 按注册顺序获取所有插件的信息
+func (pm *PluginManager) GetPluginInfos() []PluginInfo {
+	pm.infoMutex.RLock()
+	defer pm.infoMutex.RUnlock()
+
+	infos := make([]PluginInfo, 0, len(pm.plugins))
+	for _, plugin := range pm.plugins {
+		infos = append(infos, *pm.pluginInfos[plugin.Name()])
+	}
+	return infos
+}
+
+// IsEnabled 判断插件是否启用，未注册的插件视为未启用
+func (pm *PluginManager) IsEnabled(name string) bool {
+	pm.infoMutex.RLock()
+	defer pm.infoMutex.RUnlock()
+
+	info, exists := pm.pluginInfos[name]
+	return exists && info.Enabled
+}
+
+// EnablePlugin 启用插件
+func (pm *PluginManager) EnablePlugin(name string) bool {
+	return pm.setEnabled(name, true)
 }
 
 // DisablePlugin 禁用插件
+// 禁用后插件的路由仍然保留，请求直接返回503，重新启用后立即恢复
 func (pm *PluginManager) DisablePlugin(name string) bool {
+	return pm.setEnabled(name, false)
+}
+
+// setEnabled 修改插件的启用状态，插件不存在时返回false
+func (pm *PluginManager) setEnabled(name string, enabled bool) bool {
+	pm.infoMutex.Lock()
+	defer pm.infoMutex.Unlock()
+
 	info, exists := pm.pluginInfos[name]
 	if !exists {
 		return false
 	}
 
-	info.Enabled = false
-	logrus.Infof("插件 %s 已禁用", name)
+	info.Enabled = enabled
+	if enabled {
+		logrus.Infof("插件 %s 已启用", name)
+	} else {
+		logrus.Infof("插件 %s 已禁用", name)
+	}
 	return true
 }
 
+// enabledMiddleware 已停用插件的请求直接返回503
+func (pm *PluginManager) enabledMiddleware(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !pm.IsEnabled(name) {
+			common.ErrorResponse(c, http.StatusServiceUnavailable, common.CodePluginDisabled, "插件已停用")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 // RegisterBuiltinPlugins 注册所有内置插件
 func (pm *PluginManager) RegisterBuiltinPlugins() {
 	pm.Register(ip.IPPlugin)
@@ -144,7 +220,22 @@ func (pm *PluginManager) RegisterBuiltinPlugins() {
 	pm.Register(ipify.IpifyPlugin)
 }
 
-// RegisterAPIRouter 注册API密钥管理路由
-func RegisterAPIRouter(r *gin.RouterGroup) {
-	api_key.RegisterRouter(r)
+// RegisterAPIRouter 注册API密钥、账户、Webhook、审计日志、IP封禁和插件管理路由
+// 已注册的插件名称作为API密钥可用的权限范围
+func (pm *PluginManager) RegisterAPIRouter(r *gin.RouterGroup) {
+	api_key.RegisterRouter(r, pm.PluginNames())
+	account.RegisterRouter(r)
+	webhook.RegisterRouter(r)
+	audit.RegisterRouter(r)
+	ip_ban.RegisterRouter(r)
+
+	pluginGroup := r.Group("/plugin")
+	{
+		// 获取插件列表及启用状态
+		pluginGroup.GET("", pm.GetPluginsHandler)
+		// 启用插件
+		pluginGroup.POST("/:name/enable", pm.EnablePluginHandler)
+		// 停用插件
+		pluginGroup.POST("/:name/disable", pm.DisablePluginHandler)
+	}
 }
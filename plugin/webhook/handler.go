@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/xrcuo/xrcuo-api/common"
+	"github.com/xrcuo/xrcuo-api/config"
+	"github.com/xrcuo/xrcuo-api/db"
+	"github.com/xrcuo/xrcuo-api/models"
+)
+
+// maxPageSize 单次查询最多返回的投递记录数
+const maxPageSize = 200
+
+// GetDeliveriesHandler 分页获取Webhook投递记录
+// 查询参数：status（pending/success/failed，为空表示全部）、limit（默认50）、offset
+func GetDeliveriesHandler(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && status != models.WebhookStatusPending && status != models.WebhookStatusSuccess && status != models.WebhookStatusFailed {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的投递状态，可选值：pending、success、failed",
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的limit参数，取值范围1-200",
+		})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的offset参数",
+		})
+		return
+	}
+
+	deliveries, err := db.GetWebhookDeliveries(status, limit, offset)
+	if err != nil {
+		logrus.Errorf("获取Webhook投递记录失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "获取Webhook投递记录失败",
+		})
+		return
+	}
+
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"deliveries": deliveries,
+	})
+}
+
+// RetryDeliveryHandler 立即重新投递一条记录，投递次数重新计算
+func RetryDeliveryHandler(c *gin.Context) {
+	// 从URL参数中获取ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "无效的ID参数",
+		})
+		return
+	}
+
+	delivery, err := db.RetryWebhookDelivery(id)
+	if err != nil {
+		if errors.Is(err, db.ErrWebhookDeliveryNotFound) {
+			common.JSONResponse(c, http.StatusNotFound, gin.H{
+				"error": "投递记录不存在",
+			})
+			return
+		}
+		logrus.Errorf("重新投递Webhook失败: %v", err)
+		common.JSONResponse(c, http.StatusInternalServerError, gin.H{
+			"error": "重新投递Webhook失败",
+		})
+		return
+	}
+	common.WakeWebhookDispatcher()
+	common.RecordAudit(c, "webhook.retry", "webhook_delivery", strconv.FormatInt(id, 10), nil, delivery)
+
+	common.JSONResponse(c, http.StatusOK, gin.H{
+		"delivery": delivery,
+	})
+}
+
+// TestHandler 向所有订阅了测试事件的地址发送一个测试事件，用于验证接收方的配置
+func TestHandler(c *gin.Context) {
+	if len(config.GetWebhookEndpoints()) == 0 {
+		common.JSONResponse(c, http.StatusBadRequest, gin.H{
+			"error": "未配置Webhook接收地址",
+		})
+		return
+	}
+
+	common.EmitWebhook(common.WebhookEventTest, gin.H{
+		"message": "这是一个测试事件",
+		"sent_at": time.Now(),
+	})
+	common.RecordAudit(c, "webhook.test", "webhook", "", nil, nil)
+
+	common.JSONResponse(c, http.StatusAccepted, gin.H{
+		"message": "测试事件已加入投递队列",
+	})
+}
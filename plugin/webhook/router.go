@@ -0,0 +1,18 @@
+package webhook
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRouter 注册Webhook管理路由
+func RegisterRouter(r *gin.RouterGroup) {
+	webhookGroup := r.Group("/webhook")
+	{
+		// 获取投递记录
+		webhookGroup.GET("/deliveries", GetDeliveriesHandler)
+		// 重新投递
+		webhookGroup.POST("/deliveries/:id/retry", RetryDeliveryHandler)
+		// 发送测试事件
+		webhookGroup.POST("/test", TestHandler)
+	}
+}
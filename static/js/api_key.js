@@ -3,17 +3,35 @@ document.addEventListener('DOMContentLoaded', function() {
     // 加载API Key列表
     loadApiKeys();
 
+    // 加载可用的权限范围和套餐
+    loadScopes();
+    loadPlans();
+
     // 表单提交事件
     document.getElementById('createApiKeyForm').addEventListener('submit', function(e) {
         e.preventDefault();
         createApiKey();
     });
+
+    document.getElementById('editApiKeyForm').addEventListener('submit', function(e) {
+        e.preventDefault();
+        saveApiKey();
+    });
 });
 
+// 处理管理接口响应，会话失效时跳转到登录页
+function handleAuthResponse(response) {
+    if (response.status === 401) {
+        window.location.href = '/login?redirect=' + encodeURIComponent(window.location.pathname);
+        throw new Error('会话已失效，请重新登录');
+    }
+    return response.json();
+}
+
 // 加载API Key列表
 function loadApiKeys() {
     fetch('/auth/api_key')
-        .then(response => response.json())
+        .then(handleAuthResponse)
         .then(data => {
             renderApiKeys(data.api_keys);
         })
@@ -23,8 +41,68 @@ function loadApiKeys() {
         });
 }
 
+// 加载可用的权限范围
+function loadScopes() {
+    fetch('/auth/api_key/scopes')
+        .then(handleAuthResponse)
+        .then(data => {
+            const container = document.getElementById('apiKeyScopes');
+            container.innerHTML = data.scopes.map(scope => `
+                <div class="form-check form-check-inline">
+                    <input class="form-check-input" type="checkbox" name="scopes" value="${scope}" id="scope-${scope}">
+                    <label class="form-check-label" for="scope-${scope}">${scope}</label>
+                </div>
+            `).join('');
+        })
+        .catch(error => {
+            console.error('加载权限范围失败:', error);
+        });
+}
+
+// 加载配置中定义的套餐
+function loadPlans() {
+    fetch('/auth/api_key/plans')
+        .then(handleAuthResponse)
+        .then(data => {
+            const options = data.plans.map(plan => `<option value="${plan}">${plan}</option>`).join('');
+            document.querySelectorAll('.plan-select').forEach(select => {
+                select.innerHTML = '<option value="">不使用套餐</option>' + options;
+            });
+        })
+        .catch(error => {
+            console.error('加载套餐失败:', error);
+        });
+}
+
+// 渲染权限范围标签
+function renderScopes(key) {
+    const items = (key.scopes || []).map(scope => `<span class="badge badge-scope">${scope}</span>`)
+        .concat((key.allowed_paths || []).map(pattern => `<span class="badge badge-scope">${pattern}</span>`));
+    if (items.length === 0) {
+        return '<span class="text-muted">全部插件</span>';
+    }
+    return items.join(' ');
+}
+
+// 当前列表中的API Key，编辑时按ID查找
+let currentApiKeys = [];
+
+// 渲染IP限制标签
+function renderCIDRs(key) {
+    if (!key.allowed_cidrs || key.allowed_cidrs.length === 0) {
+        return '<span class="text-muted">不限制</span>';
+    }
+    return key.allowed_cidrs.map(cidr => `<span class="badge badge-scope">${cidr}</span>`).join(' ');
+}
+
+// 将逗号分隔的输入拆分为列表
+function splitList(value) {
+    return (value || '').split(',').map(item => item.trim()).filter(item => item);
+}
+
 // 渲染API Key列表
 function renderApiKeys(apiKeys) {
+    currentApiKeys = apiKeys;
     const container = document.getElementById('apiKeysContainer');
     
     if (apiKeys.length === 0) {
@@ -36,19 +114,39 @@ function renderApiKeys(apiKeys) {
     apiKeys.forEach(key => {
         const usagePercentage = key.is_permanent ? 0 : Math.min(100, (key.current_usage / key.max_usage) * 100);
         const badgeClass = key.is_permanent ? 'badge-permanent' : 'badge-limited';
-        const badgeText = key.is_permanent ? '永久有效' : `限制使用 ${key.max_usage} 次`;
+        const periodText = { daily: '每日', monthly: '每月' }[key.quota_period] || '';
+        const badgeText = key.is_permanent ? '永久有效' : `${periodText}限制使用 ${key.max_usage} 次`;
+        const expiryBadge = renderExpiryBadge(key);
+        const disabledBadge = key.enabled ? '' : '<span class="badge badge-disabled">已禁用</span>';
+        const signatureBadge = key.require_signature ? '<span class="badge badge-scope">仅签名请求</span>' : '';
+        const planBadge = key.plan ? `<span class="badge badge-scope">套餐 ${key.plan}</span>` : '';
         
         html += `
-            <div class="api-key-item">
+            <div class="api-key-item ${key.enabled ? '' : 'disabled'}">
                 <div class="row">
                     <div class="col-md-8">
                         <div class="d-flex justify-content-between align-items-start mb-2">
                             <h5>${key.name}</h5>
-                            <span class="badge ${badgeClass}">${badgeText}</span>
+                            <div>
+                                ${disabledBadge}
+                                ${signatureBadge}
+                                ${planBadge}
+                                ${expiryBadge}
+                                <span class="badge ${badgeClass}">${badgeText}</span>
+                            </div>
+                        </div>
+                        <div class="mb-2">
+                            <strong>API Key:</strong> <span class="text-muted" style="font-size: 0.85rem;">ID ${key.id}</span>
+                            <div class="api-key-value mt-1">${key.key_prefix}…</div>
+                            ${key.prev_key_expires_at && new Date(key.prev_key_expires_at) > new Date() ? `<div class="text-muted mt-1" style="font-size: 0.85rem;">旧密钥宽限期至 ${new Date(key.prev_key_expires_at).toLocaleString()}</div>` : ''}
                         </div>
                         <div class="mb-2">
-                            <strong>API Key:</strong>
-                            <div class="api-key-value mt-1">${key.key}</div>
+                            <strong>权限范围:</strong>
+                            ${renderScopes(key)}
+                        </div>
+                        <div class="mb-2">
+                            <strong>IP限制:</strong>
+                            ${renderCIDRs(key)}
                         </div>
                         <div class="mb-1">
                             <div class="d-flex justify-content-between">
@@ -61,9 +159,23 @@ function renderApiKeys(apiKeys) {
                         </div>
                         <div class="text-muted" style="font-size: 0.85rem;">
                             创建时间: ${new Date(key.created_at).toLocaleString()}
+                            ${key.quota_period && key.quota_reset_at ? ` · 配额重置时间: ${new Date(key.quota_reset_at).toLocaleString()}` : ''}
+                            ${key.rate_limit_burst || key.rate_limit_rate ? ` · 速率限制: ${key.rate_limit_burst || '默认'} 突发 / ${key.rate_limit_rate ? Math.round(key.rate_limit_rate * 60 * 100) / 100 : '默认'} 次每分钟` : ''}
                         </div>
                     </div>
-                    <div class="col-md-4 d-flex align-items-center justify-content-end">
+                    <div class="col-md-4 d-flex align-items-center justify-content-end gap-2">
+                        <button class="btn btn-outline-primary btn-sm" onclick="editApiKey(${key.id})">
+                            <i class="fa fa-pencil mr-1"></i>
+                            编辑
+                        </button>
+                        <button class="btn btn-outline-warning btn-sm" onclick="rotateApiKey(${key.id}, '${key.name}')">
+                            <i class="fa fa-refresh mr-1"></i>
+                            轮换
+                        </button>
+                        <button class="btn btn-outline-secondary btn-sm" onclick="toggleApiKey(${key.id}, ${!key.enabled})">
+                            <i class="fa ${key.enabled ? 'fa-pause' : 'fa-play'} mr-1"></i>
+                            ${key.enabled ? '禁用' : '启用'}
+                        </button>
                         <button class="btn btn-danger btn-sm" onclick="deleteApiKey(${key.id}, '${key.name}')">
                             <i class="fa fa-trash mr-1"></i>
                             删除
@@ -77,6 +189,31 @@ function renderApiKeys(apiKeys) {
     container.innerHTML = html;
 }
 
+// 格式化剩余时长
+function formatDuration(seconds) {
+    const days = Math.floor(seconds / 86400);
+    const hours = Math.floor((seconds % 86400) / 3600);
+    const minutes = Math.floor((seconds % 3600) / 60);
+    if (days > 0) {
+        return `${days}天${hours}小时`;
+    }
+    if (hours > 0) {
+        return `${hours}小时${minutes}分钟`;
+    }
+    return `${minutes}分钟`;
+}
+
+// 渲染剩余有效期标签
+function renderExpiryBadge(key) {
+    if (key.expires_in === undefined || key.expires_in === null) {
+        return '';
+    }
+    if (key.expires_in <= 0) {
+        return '<span class="badge badge-expired">已过期</span>';
+    }
+    return `<span class="badge badge-expiring">剩余 ${formatDuration(key.expires_in)}</span>`;
+}
+
 // 创建API Key
 function createApiKey() {
     const form = document.getElementById('createApiKeyForm');
@@ -84,8 +221,20 @@ function createApiKey() {
     const data = {
         name: formData.get('name'),
         max_usage: parseInt(formData.get('max_usage')),
-        is_permanent: formData.get('is_permanent') === 'on'
+        is_permanent: formData.get('is_permanent') === 'on',
+        require_signature: formData.get('require_signature') === 'on',
+        quota_period: formData.get('quota_period'),
+        plan: formData.get('plan'),
+        idle_timeout: (parseInt(formData.get('idle_timeout')) || 0) * 3600,
+        rate_limit_burst: parseInt(formData.get('rate_limit_burst')) || 0,
+        rate_limit_rate: (parseFloat(formData.get('rate_limit_rate')) || 0) / 60,
+        scopes: formData.getAll('scopes'),
+        allowed_paths: splitList(formData.get('allowed_paths')),
+        allowed_cidrs: splitList(formData.get('allowed_cidrs'))
     };
+    if (formData.get('expires_at')) {
+        data.expires_at = new Date(formData.get('expires_at')).toISOString();
+    }
 
     fetch('/auth/api_key', {
         method: 'POST',
@@ -94,15 +243,15 @@ function createApiKey() {
         },
         body: JSON.stringify(data)
     })
-    .then(response => response.json())
+    .then(handleAuthResponse)
     .then(result => {
         if (result.api_key) {
             // 关闭模态框
             const modal = bootstrap.Modal.getInstance(document.getElementById('createApiKeyModal'));
             modal.hide();
             
-            // 显示成功消息
-            showSuccessMessage('API Key创建成功');
+            // 显示新密钥（明文只返回这一次）
+            showCreatedKey(result.api_key.key, result.api_key.signing_secret);
             
             // 重置表单
             form.reset();
@@ -119,13 +268,106 @@ function createApiKey() {
     });
 }
 
+// 提交API Key的部分更新
+function patchApiKey(id, data) {
+    return fetch(`/auth/api_key/${id}`, {
+        method: 'PATCH',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(data)
+    })
+    .then(handleAuthResponse)
+    .then(result => {
+        if (!result.api_key) {
+            throw new Error(result.error || '未知错误');
+        }
+        return result.api_key;
+    });
+}
+
+// 打开编辑API Key模态框
+function editApiKey(id) {
+    const key = currentApiKeys.find(k => k.id === id);
+    if (!key) {
+        return;
+    }
+    document.getElementById('editApiKeyId').value = key.id;
+    document.getElementById('editApiKeyName').value = key.name;
+    document.getElementById('editApiKeyMaxUsage').value = key.max_usage;
+    document.getElementById('editApiKeyAllowedCIDRs').value = (key.allowed_cidrs || []).join(', ');
+    document.getElementById('editApiKeyIsPermanent').checked = key.is_permanent;
+    document.getElementById('editApiKeyRequireSignature').checked = key.require_signature;
+    document.getElementById('editApiKeyPlan').value = key.plan || '';
+    const modal = new bootstrap.Modal(document.getElementById('editApiKeyModal'));
+    modal.show();
+}
+
+// 保存API Key修改
+function saveApiKey() {
+    const id = document.getElementById('editApiKeyId').value;
+    patchApiKey(id, {
+        name: document.getElementById('editApiKeyName').value,
+        max_usage: parseInt(document.getElementById('editApiKeyMaxUsage').value) || 0,
+        is_permanent: document.getElementById('editApiKeyIsPermanent').checked,
+        require_signature: document.getElementById('editApiKeyRequireSignature').checked,
+        plan: document.getElementById('editApiKeyPlan').value,
+        allowed_cidrs: splitList(document.getElementById('editApiKeyAllowedCIDRs').value)
+    })
+    .then(() => {
+        bootstrap.Modal.getInstance(document.getElementById('editApiKeyModal')).hide();
+        showSuccessMessage('API Key已更新');
+        loadApiKeys();
+    })
+    .catch(error => {
+        console.error('更新API Key失败:', error);
+        alert('更新失败: ' + error.message);
+    });
+}
+
+// 启用或禁用API Key
+function toggleApiKey(id, enabled) {
+    patchApiKey(id, { enabled: enabled })
+    .then(() => {
+        showSuccessMessage(enabled ? 'API Key已启用' : 'API Key已禁用');
+        loadApiKeys();
+    })
+    .catch(error => {
+        console.error('更新API Key失败:', error);
+        alert('更新失败: ' + error.message);
+    });
+}
+
+// 轮换API Key，旧密钥在宽限期内仍可使用
+function rotateApiKey(id, name) {
+    if (!confirm(`确定要轮换API Key "${name}"吗？将生成新密钥，旧密钥在宽限期结束后失效。`)) {
+        return;
+    }
+    fetch(`/auth/api_key/${id}/rotate`, {
+        method: 'POST'
+    })
+    .then(handleAuthResponse)
+    .then(result => {
+        if (result.api_key) {
+            showCreatedKey(result.api_key.key, result.api_key.signing_secret);
+            loadApiKeys();
+        } else {
+            alert('轮换失败: ' + (result.error || '未知错误'));
+        }
+    })
+    .catch(error => {
+        console.error('轮换API Key失败:', error);
+        alert('轮换API Key失败，请重试');
+    });
+}
+
 // 删除API Key
 function deleteApiKey(id, name) {
     if (confirm(`确定要删除API Key "${name}"吗？此操作不可恢复。`)) {
         fetch(`/auth/api_key/${id}`, {
             method: 'DELETE'
         })
-        .then(response => response.json())
+        .then(handleAuthResponse)
         .then(result => {
             if (result.message) {
                 // 显示成功消息
@@ -144,6 +386,25 @@ function deleteApiKey(id, name) {
     }
 }
 
+// 显示新创建的API Key明文
+function showCreatedKey(key, signingSecret) {
+    document.getElementById('createdKeyValue').textContent = key;
+    document.getElementById('createdSigningSecretValue').textContent = signingSecret || '';
+    // 服务器未配置签名密钥时不显示
+    document.getElementById('createdSigningSecret').style.display = signingSecret ? '' : 'none';
+    document.getElementById('copyCreatedKeyBtn').textContent = '复制';
+    const modal = new bootstrap.Modal(document.getElementById('createdKeyModal'));
+    modal.show();
+}
+
+// 复制新创建的API Key
+function copyCreatedKey() {
+    const key = document.getElementById('createdKeyValue').textContent;
+    navigator.clipboard.writeText(key).then(() => {
+        document.getElementById('copyCreatedKeyBtn').textContent = '已复制';
+    });
+}
+
 // 显示成功消息
 function showSuccessMessage(message) {
     const modal = new bootstrap.Modal(document.getElementById('successModal'));
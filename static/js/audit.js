@@ -0,0 +1,153 @@
+// 审计日志查看功能
+const auditPageSize = 50;
+let auditOffset = 0;
+let auditTotal = 0;
+let auditLogs = [];
+
+document.addEventListener('DOMContentLoaded', function() {
+    loadAuditLogs();
+
+    // 修改筛选条件后从第一页开始查询
+    document.getElementById('auditFilterForm').addEventListener('submit', function(e) {
+        e.preventDefault();
+        auditOffset = 0;
+        loadAuditLogs();
+    });
+});
+
+// 处理管理接口响应，会话失效时跳转到登录页
+function handleAuthResponse(response) {
+    if (response.status === 401) {
+        window.location.href = '/login?redirect=' + encodeURIComponent(window.location.pathname);
+        throw new Error('会话已失效，请重新登录');
+    }
+    return response.json();
+}
+
+// 转义HTML，日志中的名称等字段可能包含任意字符
+function escapeHtml(value) {
+    return String(value === undefined || value === null ? '' : value)
+        .replace(/&/g, '&amp;')
+        .replace(/</g, '&lt;')
+        .replace(/>/g, '&gt;')
+        .replace(/"/g, '&quot;');
+}
+
+// 根据筛选条件构造查询参数
+function buildQuery() {
+    const params = new URLSearchParams();
+    const fields = {
+        actor: 'filterActor',
+        action: 'filterAction',
+        target_type: 'filterTargetType',
+        target_id: 'filterTargetId'
+    };
+    Object.keys(fields).forEach(name => {
+        const value = document.getElementById(fields[name]).value.trim();
+        if (value) {
+            params.set(name, value);
+        }
+    });
+
+    // 时间控件为本地时间，转换为RFC3339格式
+    const since = document.getElementById('filterSince').value;
+    if (since) {
+        params.set('since', new Date(since).toISOString());
+    }
+    const until = document.getElementById('filterUntil').value;
+    if (until) {
+        params.set('until', new Date(until).toISOString());
+    }
+
+    params.set('limit', auditPageSize);
+    params.set('offset', auditOffset);
+    return params.toString();
+}
+
+// 加载审计日志
+function loadAuditLogs() {
+    fetch('/auth/audit?' + buildQuery())
+        .then(handleAuthResponse)
+        .then(data => {
+            if (data.error) {
+                throw new Error(data.error);
+            }
+            auditLogs = data.logs;
+            auditTotal = data.total;
+            renderAuditLogs();
+        })
+        .catch(error => {
+            console.error('加载审计日志失败:', error);
+            document.getElementById('auditLogsContainer').innerHTML = `<div class="alert alert-danger">加载审计日志失败：${escapeHtml(error.message)}</div>`;
+        });
+}
+
+// 渲染审计日志列表
+function renderAuditLogs() {
+    const container = document.getElementById('auditLogsContainer');
+    if (auditLogs.length === 0) {
+        container.innerHTML = '<div class="alert alert-info">没有符合条件的审计日志</div>';
+    } else {
+        const rows = auditLogs.map((log, index) => `
+            <tr>
+                <td class="text-nowrap">${new Date(log.created_at).toLocaleString()}</td>
+                <td>${escapeHtml(log.actor)}</td>
+                <td><code>${escapeHtml(log.action)}</code></td>
+                <td>${escapeHtml(log.target_type)}${log.target_id ? ' #' + escapeHtml(log.target_id) : ''}</td>
+                <td>${escapeHtml(log.client_ip) || '-'}</td>
+                <td>
+                    <button type="button" class="btn btn-outline-secondary btn-sm" onclick="showAuditDetail(${index})">
+                        <i class="fa fa-eye"></i> 详情
+                    </button>
+                </td>
+            </tr>
+        `).join('');
+        container.innerHTML = `
+            <div class="table-responsive">
+                <table class="table table-hover align-middle">
+                    <thead>
+                        <tr>
+                            <th>时间</th>
+                            <th>操作者</th>
+                            <th>操作</th>
+                            <th>对象</th>
+                            <th>客户端IP</th>
+                            <th></th>
+                        </tr>
+                    </thead>
+                    <tbody>${rows}</tbody>
+                </table>
+            </div>
+        `;
+    }
+
+    // 更新分页信息
+    const start = auditTotal === 0 ? 0 : auditOffset + 1;
+    const end = auditOffset + auditLogs.length;
+    document.getElementById('auditPageInfo').textContent = `第 ${start}-${end} 条，共 ${auditTotal} 条`;
+    document.getElementById('auditPrevBtn').disabled = auditOffset === 0;
+    document.getElementById('auditNextBtn').disabled = end >= auditTotal;
+}
+
+// 翻页
+function changePage(direction) {
+    auditOffset = Math.max(0, auditOffset + direction * auditPageSize);
+    loadAuditLogs();
+}
+
+// 格式化变更数据
+function formatJSON(value) {
+    if (value === null || value === undefined) {
+        return '（无）';
+    }
+    return JSON.stringify(value, null, 2);
+}
+
+// 显示操作前后的数据
+function showAuditDetail(index) {
+    const log = auditLogs[index];
+    document.getElementById('auditDetailModalLabel').textContent = `${log.action} ${log.target_id || ''}`;
+    document.getElementById('auditDetailBefore').textContent = formatJSON(log.before);
+    document.getElementById('auditDetailAfter').textContent = formatJSON(log.after);
+    new bootstrap.Modal(document.getElementById('auditDetailModal')).show();
+}
@@ -212,6 +212,27 @@ function updatePathStatsChart(stats) {
     }
 }
 
+// 更新调用方统计表格
+function updatePrincipalStatsTable(stats) {
+    const tbody = document.querySelector('#principal-stats-table tbody');
+    
+    // 移除旧的表格行
+    tbody.innerHTML = '';
+    
+    // 按调用次数从多到少添加表格行
+    Object.entries(stats.principal_calls || {})
+        .sort((a, b) => b[1] - a[1])
+        .forEach(([principal, count]) => {
+            const row = document.createElement('tr');
+            row.innerHTML = `
+                <td>${principal}</td>
+                <td class="count-column">${count}</td>
+                <td>${calculatePercentage(count, stats.total_calls)}%</td>
+            `;
+            tbody.appendChild(row);
+        });
+}
+
 // 更新最近调用记录
 function updateLastCallDetails(stats) {
     const tbody = document.querySelector('.detail-section:last-child tbody');
@@ -239,6 +260,7 @@ function updateLastCallDetails(stats) {
             <td>${detail.path}</td>
             <td><span class="badge bg-primary">${detail.method}</span></td>
             <td>${detail.ip}</td>
+            <td>${detail.principal || '-'}</td>
             <td><span class="status-badge ${statusClass}">${detail.status_code}</span></td>
         `;
         
@@ -261,6 +283,7 @@ async function refreshStats() {
         updateMethodStats(stats);
         updatePathStatsTable(stats);
         updatePathStatsChart(stats);
+        updatePrincipalStatsTable(stats);
         updateLastCallDetails(stats);
         
         console.log('Stats refreshed successfully');